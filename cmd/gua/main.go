@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,19 +15,32 @@ func main() {
 	app := &cli.App{
 		Name:  "gua",
 		Usage: "Gua - Lua Interpreter written in Go",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "max-call-depth",
+				Usage: "maximum number of nested calls",
+				Value: interpreter.MaxCallDepth,
+			},
+		},
 		Action: func(c *cli.Context) error {
 			if c.NArg() < 1 {
 				return cli.Exit("Provide path to lua file", -1)
 			}
+			interpreter.MaxCallDepth = c.Int("max-call-depth")
 			path := c.Args().Get(0)
 			buf, err := os.ReadFile(path)
 			if err != nil {
 				return cli.Exit(fmt.Sprintf("Error reading file: %s", err.Error()), -2)
 			}
 
-			_, err = interpreter.Eval(string(buf))
+			_, err = interpreter.EvalChunk(string(buf), path)
 			if err != nil {
-				return cli.Exit(fmt.Sprintf("Error: %s", err.Error()), -3)
+				msg := fmt.Sprintf("Error: %s", err.Error())
+				var luaErr *interpreter.LuaError
+				if errors.As(err, &luaErr) && luaErr.Traceback != "" {
+					msg += "\n" + luaErr.Traceback
+				}
+				return cli.Exit(msg, -3)
 			}
 			//fmt.Printf("Result: %+v", val)
 			return nil
@@ -2,12 +2,17 @@ package ast
 
 type (
 	NumeralExpression struct {
-		Value float64
+		Node
+		// Value is int64 for integer numerals and float64 for float ones
+		Value Value
 	}
 	LiteralString struct {
+		Node
 		Value string
 	}
-	VarArgExpression struct{}
+	VarArgExpression struct {
+		Node
+	}
 	// ParameterList
 	// parlist ::= namelist [‘,’ ‘...’] | ‘...’
 	ParameterList struct {
@@ -17,6 +22,7 @@ type (
 	// ReturnStatement ::= return [explist] [‘;’]
 	// retstat ::= return [explist] [‘;’]
 	ReturnStatement struct {
+		Node
 		Expressions []Expression
 	}
 	// Block
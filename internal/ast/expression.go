@@ -8,23 +8,27 @@ type (
 	// FunctionDefinition
 	// functiondef ::= function funcbody
 	FunctionDefinition struct {
+		Node
 		FunctionBody FunctionBody
 	}
 	// ExpToExpField
 	// ‘[’ exp ‘]’ ‘=’ exp
 	ExpToExpField struct {
+		Node
 		Key   Expression
 		Value Expression
 	}
 	// NameField
 	// Name ‘=’ exp
 	NameField struct {
+		Node
 		Name  string
 		Value Expression
 	}
 	// ExpressionField
 	// exp
 	ExpressionField struct {
+		Node
 		Value Expression
 	}
 	// Field
@@ -33,6 +37,7 @@ type (
 	// TableConstructorExpression
 	// tableconstructor ::= ‘{’ [fieldlist] ‘}’
 	TableConstructorExpression struct {
+		Node
 		Fields []Field
 	}
 	// ExpressionList
@@ -46,6 +51,7 @@ type (
 	// FunctionCall
 	// functioncall ::= prefixexp args | prefixexp ‘:’ Name args
 	FunctionCall struct {
+		Node
 		PrefixExp PrefixExpression
 		Name      string
 		Args      Args
@@ -54,12 +60,21 @@ type (
 	// prefixexp ::= var | functioncall | ‘(’ exp ‘)’
 	PrefixExpression interface {
 		Evaluable
+		Positioned
+	}
+	// ParenExpression
+	// ‘(’ exp ‘)’ around a function call or ‘...’, truncating it to one value
+	ParenExpression struct {
+		Node
+		Exp Expression
 	}
 	UnaryOperatorExpression struct {
+		Node
 		Operator   lexer.Token
 		Expression Expression
 	}
 	BinaryOperatorExpression struct {
+		Node
 		Operator lexer.Token
 		Left     Expression
 		Right    Expression
@@ -72,8 +87,17 @@ type (
 		Eval(ctx *Context) (Value, error)
 	}
 
-	NilExpression     struct{}
+	// MultiValuer is implemented by the expressions that can produce
+	// several values: function calls and ‘...’.
+	MultiValuer interface {
+		EvalMulti(ctx *Context) ([]Value, error)
+	}
+
+	NilExpression struct {
+		Node
+	}
 	BooleanExpression struct {
+		Node
 		Value bool
 	}
 )
@@ -17,6 +17,7 @@ type (
 	// Function
 	// function funcname funcbody
 	Function struct {
+		Node
 		FunctionName FunctionName
 		FuncBody     FunctionBody
 	}
@@ -3,22 +3,22 @@ package ast
 import (
 	"errors"
 	"fmt"
-	"math"
-	"strings"
 
+	"lua-interpreter/internal/coroutine"
 	"lua-interpreter/internal/lexer"
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/stdlib"
+	"lua-interpreter/internal/table"
 )
 
 var (
-	ErrBreak                = errors.New("break statement outside of loop")
-	ErrVarArgNotDefined     = errors.New("cannot use '...' outside a vararg function ")
-	ErrBitwiseAndOnlyInt    = errors.New("bitwise AND can only be applied to integers")
-	ErrBitwiseOrOnlyInt     = errors.New("bitwise OR can only be applied to integers")
-	ErrUnaryMinusOnlyNum    = errors.New("unary minus can only be applied to numbers")
-	ErrBitwiseNotOnlyInt    = errors.New("bitwise NOT can only be applied to integers")
-	ErrInvalidOperandLength = errors.New("invalid operand for # operator")
+	ErrBreak            = errors.New("break statement outside of loop")
+	ErrVarArgNotDefined = errors.New("cannot use '...' outside a vararg function ")
 )
 
+// GotoError passes a goto up to the enclosing block that has its label;
+// the parser has checked that the label is visible.
 type GotoError struct {
 	Label string
 }
@@ -27,35 +27,110 @@ func (e *GotoError) Error() string {
 	return fmt.Sprintf("no visible label '%s' for <goto>", e.Label)
 }
 
-type Value interface{}
+// Value is any Lua value; it is an alias so that values can be passed
+// to the shared table and meta packages as is.
+type Value = interface{}
 
 type Context struct {
+	// chunk is the name of the chunk being evaluated, used in error messages
+	chunk string
+	// Parent is the lexically enclosing scope
 	Parent     *Context
-	Return     Value // для возврата из функций
+	Return     []Value // для возврата из функций
 	isReturned bool
 	Variables  map[string]Value
-	globals    map[string]Value // только в корне
-	labels     map[string]int   // для меток goto
+	globals    *table.Table   // только в корне
+	labels     map[string]int // для меток goto
+	// meta dispatches metamethods, shared by all scopes
+	meta *meta.Dispatcher
+	// isFunction marks the outermost scope of a function call
+	isFunction bool
+	// tail is the call returned by a return statement, made by the caller
+	tail *tailCall
 }
 
-func NewRootContext() *Context {
+// tailCall is the call of a return statement whose results are the results
+// of the function. The calling function makes it after the returning one
+// has ended, so that tail-recursive functions run in constant Go stack.
+type tailCall struct {
+	call *FunctionCall
+	// ctx is the scope the call is evaluated in
+	ctx  *Context
+	fn   Value
+	args []Value
+}
+
+func (tc *tailCall) do() ([]Value, error) {
+	return tc.call.call(tc.ctx, tc.fn, tc.args)
+}
+
+func NewRootContext(chunk string) *Context {
 	ctx := &Context{
+		chunk:     chunk,
 		Variables: make(map[string]Value),
-		globals:   make(map[string]Value),
+		globals:   stdlib.Globals(),
 		labels:    make(map[string]int),
+		// the main chunk is a function with the upvalue _ENV
+		isFunction: true,
 	}
-	ctx.Set("print", printFn)
-	ctx.Set("assert", assertFn)
+	ctx.meta = &meta.Dispatcher{Call: ctx.call, TypeName: typeName, MaxCallDepth: meta.DefaultMaxCallDepth}
+	ctx.meta.Threads = coroutine.NewState(ctx.call)
+	_ = ctx.globals.Set("print", printFn)
+	ctx.meta.Strings = stdlib.StringMetatable(ctx.globals)
+	ctx.Parent = ctx.chunkScope(chunk, ctx.globals)
+	// the main chunk is a vararg function
+	ctx.SetLocal("...", []Value{})
 
 	return ctx
 }
 
+// SetParser sets the function that parses the chunks loaded by the
+// scripts, which the ast package cannot import.
+func (ctx *Context) SetParser(parse func(src, chunk string) (Block, error)) {
+	ctx.meta.Load = func(src, chunk string, env *meta.Env) (interface{}, error) {
+		block, err := parse(src, chunk)
+		if err != nil {
+			return nil, err
+		}
+		var envValue Value = ctx.globals
+		if env != nil {
+			envValue = env.Value
+		}
+		return &FunctionValue{IsVarArg: true, Body: block, Env: ctx.chunkScope(chunk, envValue)}, nil
+	}
+}
+
+// chunkScope returns the scope the functions of a chunk are defined in.
+// It holds their only upvalue, _ENV, whose fields are their global variables.
+func (ctx *Context) chunkScope(chunk string, env Value) *Context {
+	return &Context{
+		chunk:     chunk,
+		Variables: map[string]Value{"_ENV": env},
+		globals:   ctx.globals,
+		labels:    make(map[string]int),
+		meta:      ctx.meta,
+	}
+}
+
+// SetMaxCallDepth limits the number of nested calls in a thread;
+// a deeper call raises a "stack overflow" error.
+func (ctx *Context) SetMaxCallDepth(n int) {
+	ctx.meta.MaxCallDepth = n
+}
+
+// Threads returns the coroutines of the interpreter.
+func (ctx *Context) Threads() *coroutine.State {
+	return ctx.meta.Threads
+}
+
 func (ctx *Context) NewChild() *Context {
 	return &Context{
+		chunk:     ctx.chunk,
 		Parent:    ctx,
 		Variables: make(map[string]Value),
 		globals:   ctx.globals,
 		labels:    make(map[string]int),
+		meta:      ctx.meta,
 	}
 }
 
@@ -63,26 +138,84 @@ func (ctx *Context) SetLocal(name string, val Value) {
 	ctx.Variables[name] = val
 }
 
-func (ctx *Context) Get(name string) Value {
+// local returns the value of a local variable, or false if there is none.
+func (ctx *Context) local(name string) (Value, bool) {
 	for c := ctx; c != nil; c = c.Parent {
 		if val, ok := c.Variables[name]; ok {
-			return val
+			return val, true
 		}
 	}
-	if val, ok := ctx.globals[name]; ok {
-		return val
+	return nil, false
+}
+
+// Get returns the value of a variable; a global variable is
+// the field of _ENV.
+func (ctx *Context) Get(name string) (Value, error) {
+	if val, ok := ctx.local(name); ok {
+		return val, nil
 	}
-	return nil
+	env, kind := ctx.env()
+	return ctx.meta.GetGlobal(env, kind, name)
 }
 
-func (ctx *Context) Set(name string, val Value) {
+// Set assigns a variable; a global variable is the field of _ENV.
+func (ctx *Context) Set(name string, val Value) error {
 	for c := ctx; c != nil; c = c.Parent {
 		if _, ok := c.Variables[name]; ok {
 			c.Variables[name] = val
-			return
+			return nil
+		}
+	}
+	env, kind := ctx.env()
+	return ctx.meta.SetGlobal(env, kind, name, val)
+}
+
+// env returns the value of the _ENV in scope, the local variable of a block
+// or the upvalue of the chunk, and whether it is a "local" or an "upvalue".
+func (ctx *Context) env() (Value, string) {
+	kind := "local"
+	for c := ctx; c != nil; c = c.Parent {
+		if val, ok := c.Variables["_ENV"]; ok {
+			return val, kind
+		}
+		if c.isFunction {
+			kind = "upvalue"
 		}
 	}
-	ctx.globals[name] = val
+	return nil, "upvalue"
+}
+
+// varKind tells whether a name is a local of the running function,
+// an upvalue of an enclosing function or a global.
+func (ctx *Context) varKind(name string) string {
+	kind := "local"
+	for c := ctx; c != nil; c = c.Parent {
+		if _, ok := c.Variables[name]; ok {
+			return kind
+		}
+		if c.isFunction {
+			kind = "upvalue"
+		}
+	}
+	return "global"
+}
+
+// addVarInfo describes in a type error the variable the wrong operand was read from;
+// vals are the values of the operand expressions.
+func (ctx *Context) addVarInfo(err error, operands []Expression, vals []Value) error {
+	return meta.AddVarInfo(err, vals, func(i int) string {
+		return VarInfo(operands[i], ctx.varKind)
+	})
+}
+
+// errorf returns a runtime error positioned at the node n.
+func (ctx *Context) errorf(n Positioned, format string, args ...interface{}) error {
+	return NewError(ctx.chunk, n.Position(), fmt.Errorf(format, args...))
+}
+
+// error attaches the position of the node n to err unless it already has one.
+func (ctx *Context) error(n Positioned, err error) error {
+	return NewError(ctx.chunk, n.Position(), err)
 }
 
 type Evaluable interface {
@@ -106,296 +239,526 @@ func (n *NilExpression) Eval(_ *Context) (Value, error) {
 }
 
 func (v *VarArgExpression) Eval(ctx *Context) (Value, error) {
-	if ctx.Get("...") != nil {
-		return ctx.Get("..."), nil
+	vals, err := v.EvalMulti(ctx)
+	return first(vals), err
+}
+
+func (v *VarArgExpression) EvalMulti(ctx *Context) ([]Value, error) {
+	val, _ := ctx.local("...")
+	if varargs, ok := val.([]Value); ok {
+		return varargs, nil
+	}
+	return nil, ctx.error(v, ErrVarArgNotDefined)
+}
+
+func (p *ParenExpression) Eval(ctx *Context) (Value, error) {
+	return p.Exp.Eval(ctx)
+}
+
+// evalExpressions evaluates an expression list following the Lua adjustment rules:
+// every expression gives exactly one value, except the last one,
+// which gives all its values if it is a function call or '...'.
+func (ctx *Context) evalExpressions(exps []Expression) ([]Value, error) {
+	vals := make([]Value, 0, len(exps))
+	for i, exp := range exps {
+		if mv, ok := exp.(MultiValuer); ok && i == len(exps)-1 {
+			rest, err := mv.EvalMulti(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return append(vals, rest...), nil
+		}
+		val, err := exp.Eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
 	}
-	return nil, ErrVarArgNotDefined
+	return vals, nil
+}
+
+// adjust truncates vals to n values or pads it with nils.
+func adjust(vals []Value, n int) []Value {
+	if len(vals) >= n {
+		return vals[:n]
+	}
+	return append(vals, make([]Value, n-len(vals))...)
+}
+
+// first returns the first value of a list, or nil if the list is empty.
+func first(vals []Value) Value {
+	if len(vals) == 0 {
+		return nil
+	}
+	return vals[0]
+}
+
+// arithmeticOperators maps the arithmetic and bitwise binary operators to number operations.
+var arithmeticOperators = map[lexer.TokenType]number.Op{
+	lexer.TokenPlus:       number.OpAdd,
+	lexer.TokenMinus:      number.OpSub,
+	lexer.TokenMult:       number.OpMul,
+	lexer.TokenDiv:        number.OpDiv,
+	lexer.TokenIntDiv:     number.OpIDiv,
+	lexer.TokenMod:        number.OpMod,
+	lexer.TokenPower:      number.OpPow,
+	lexer.TokenBinAnd:     number.OpBAnd,
+	lexer.TokenBinOr:      number.OpBOr,
+	lexer.TokenTilde:      number.OpBXor,
+	lexer.TokenShiftLeft:  number.OpShl,
+	lexer.TokenShiftRight: number.OpShr,
 }
 
 func (b *BinaryOperatorExpression) Eval(ctx *Context) (Value, error) {
-	left, _ := b.Left.Eval(ctx)
-	right, _ := b.Right.Eval(ctx)
+	left, err := b.Left.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the right operand of a logical operator is evaluated only when it is the result
+	switch b.Operator.Type {
+	case lexer.TokenKeywordAnd:
+		if !isTruthy(left) {
+			return left, nil
+		}
+		return b.Right.Eval(ctx)
+	case lexer.TokenKeywordOr:
+		if isTruthy(left) {
+			return left, nil
+		}
+		return b.Right.Eval(ctx)
+	}
+	right, err := b.Right.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if op, ok := arithmeticOperators[b.Operator.Type]; ok {
+		return b.arith(ctx, op, left, right)
+	}
 
 	switch b.Operator.Type {
-	// Arithmetic operations
-	case lexer.TokenPlus:
-		return left.(float64) + right.(float64), nil
-	case lexer.TokenMinus:
-		return left.(float64) - right.(float64), nil
-	case lexer.TokenMult:
-		return left.(float64) * right.(float64), nil
-	case lexer.TokenDiv:
-		return left.(float64) / right.(float64), nil
-	case lexer.TokenIntDiv:
-		return float64(int(left.(float64)) / int(right.(float64))), nil
-	case lexer.TokenMod:
-		return float64(int(left.(float64)) % int(right.(float64))), nil
-	case lexer.TokenPower:
-		return math.Pow(left.(float64), right.(float64)), nil
 	case lexer.TokenDoubleDot:
-		return left.(string) + right.(string), nil
+		res, err := ctx.meta.Concat(left, right)
+		if err != nil {
+			return nil, ctx.error(b, ctx.addVarInfo(err, []Expression{b.Left, b.Right}, []Value{left, right}))
+		}
+		return res, nil
 	// Comparison operations
 	case lexer.TokenEqual:
-		return left == right, nil
+		return ctx.compare(b, left, right, ctx.meta.Equal)
 	case lexer.TokenNotEqual:
-		return left != right, nil
+		eq, err := ctx.compare(b, left, right, ctx.meta.Equal)
+		if err != nil {
+			return nil, err
+		}
+		return !eq.(bool), nil
 	case lexer.TokenLess:
-		return left.(float64) < right.(float64), nil
+		return ctx.compare(b, left, right, ctx.meta.LessThan)
 	case lexer.TokenLessEqual:
-		return left.(float64) <= right.(float64), nil
+		return ctx.compare(b, left, right, ctx.meta.LessEqual)
 	case lexer.TokenMore:
-		return left.(float64) > right.(float64), nil
+		return ctx.compare(b, right, left, ctx.meta.LessThan)
 	case lexer.TokenMoreEqual:
-		return left.(float64) >= right.(float64), nil
-	// Logical operations
-	case lexer.TokenKeywordAnd:
-		return left.(bool) && right.(bool), nil
-	case lexer.TokenKeywordOr:
-		return left.(bool) || right.(bool), nil
-	// Bitwise operations
-	case lexer.TokenBinAnd:
-		if numLeft, ok := left.(float64); ok {
-			if numRight, ok := right.(float64); ok {
-				if math.Trunc(numLeft) != numLeft || math.Trunc(numRight) != numRight {
-					return nil, ErrBitwiseAndOnlyInt
-				}
-				return float64(int64(numLeft) & int64(numRight)), nil
-			}
-		}
-		return nil, ErrBitwiseAndOnlyInt
-	case lexer.TokenBinOr:
-		if numLeft, ok := left.(float64); ok {
-			if numRight, ok := right.(float64); ok {
-				if math.Trunc(numLeft) != numLeft || math.Trunc(numRight) != numRight {
-					return nil, ErrBitwiseOrOnlyInt
-				}
-				return float64(int64(numLeft) | int64(numRight)), nil
-			}
-		}
-		return nil, ErrBitwiseOrOnlyInt
+		return ctx.compare(b, right, left, ctx.meta.LessEqual)
 	default:
-		return nil, fmt.Errorf("unknown binary operator: %s", b.Operator.Type.String())
+		return nil, ctx.errorf(b, "unknown binary operator: %s", b.Operator.Type.String())
+	}
+}
+
+// arith applies an arithmetic or bitwise operator to the values of the operands.
+func (b *BinaryOperatorExpression) arith(ctx *Context, op number.Op, left, right Value) (Value, error) {
+	res, err := ctx.meta.Arith(op, left, right)
+	if err != nil {
+		return nil, ctx.error(b, ctx.addVarInfo(err, []Expression{b.Left, b.Right}, []Value{left, right}))
+	}
+	return res, nil
+}
+
+// compare applies a comparison, one of the meta.Dispatcher methods, to two values.
+func (ctx *Context) compare(n Positioned, left, right Value, cmp func(a, b interface{}) (bool, error)) (Value, error) {
+	res, err := cmp(left, right)
+	if err != nil {
+		return nil, ctx.error(n, err)
 	}
+	return res, nil
 }
 
 func (u *UnaryOperatorExpression) Eval(ctx *Context) (Value, error) {
-	val, _ := u.Expression.Eval(ctx)
+	val, err := u.Expression.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
 
+	var res Value
 	switch u.Operator.Type {
-	case lexer.TokenNot:
-		if val.(bool) == false {
-			return true, nil
-		}
-		return false, nil
+	case lexer.TokenKeywordNot:
+		return !isTruthy(val), nil
 	case lexer.TokenMinus:
-		if num, ok := val.(float64); ok {
-			return -num, nil
-		}
-		return nil, ErrUnaryMinusOnlyNum
+		res, err = ctx.meta.Arith(number.OpUnm, val, val)
 	case lexer.TokenTilde:
-		if num, ok := val.(float64); ok {
-			if math.Trunc(num) != num {
-				return nil, ErrBitwiseNotOnlyInt
-			}
-			return float64(^int64(num)), nil
-		}
-		return nil, ErrBitwiseNotOnlyInt
+		res, err = ctx.meta.Arith(number.OpBNot, val, val)
 	case lexer.TokenHash:
-		if str, ok := val.(string); ok {
-			return float64(len(str)), nil
-		} else if tbl, ok := val.(map[string]Value); ok {
-			return float64(len(tbl)), nil
-		} else if arr, ok := val.([]Value); ok {
-			return float64(len(arr)), nil
-		} else {
-			return nil, ErrInvalidOperandLength
-		}
+		res, err = ctx.meta.Len(val)
 	default:
-		return nil, fmt.Errorf("unknown unary operator: %s", u.Operator.Type.String())
+		return nil, ctx.errorf(u, "unknown unary operator: %s", u.Operator.Type.String())
 	}
+	if err != nil {
+		return nil, ctx.error(u, ctx.addVarInfo(err, []Expression{u.Expression, u.Expression}, []Value{val, val}))
+	}
+	return res, nil
 }
 
 func (t *TableConstructorExpression) Eval(ctx *Context) (Value, error) {
-	table := map[interface{}]Value{}
+	tbl := table.New(0, 0)
 	// Lua tables are 1-indexed by default
-	var index float64 = 1
+	var index int64 = 1
 
 	for i, field := range t.Fields {
 		switch f := field.(type) {
 		case *ExpToExpField:
-			key, _ := f.Key.Eval(ctx)
-			val, _ := f.Value.Eval(ctx)
-			table[key] = val
+			key, err := f.Key.Eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			val, err := f.Value.Eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if err := tbl.Set(key, val); err != nil {
+				return nil, ctx.error(f, err)
+			}
 		case *NameField:
-			val, _ := f.Value.Eval(ctx)
-			table[f.Name] = val
+			val, err := f.Value.Eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			_ = tbl.Set(f.Name, val)
 		case *ExpressionField:
-			val, _ := f.Value.Eval(ctx)
-			vararg, ok := val.([]Value)
-			if ok && len(vararg) > 0 {
-				if i == len(t.Fields)-1 {
-					for _, v := range vararg {
-						table[index] = v
-						index++
-					}
-				} else {
-					table[index] = vararg[0]
-				}
-			} else {
-				table[index] = val
+			// a function call or '...' in the last field gives all its values
+			vals, err := ctx.evalExpressions([]Expression{f.Value})
+			if err != nil {
+				return nil, err
+			}
+			if i < len(t.Fields)-1 {
+				vals = adjust(vals, 1)
+			}
+			for _, val := range vals {
+				_ = tbl.Set(index, val)
 				index++
 			}
 		}
 	}
 
-	return table, nil
+	return tbl, nil
 }
 
+// Eval runs the block and returns the first of the values it returns;
+// all of them are in ctx.Return.
 func (b *Block) Eval(ctx *Context) (Value, error) {
+	_, err := b.eval(ctx)
+	if err == nil {
+		err = ctx.finishTailCall()
+	}
+	return first(ctx.Return), err
+}
+
+// eval runs the block in ctx and also returns the innermost scope of the block,
+// which holds its locals. Every local declaration opens a new scope,
+// so that a redeclared name is a new variable for closures.
+// To-be-closed variables are closed in reverse order however the block exits.
+func (b *Block) eval(ctx *Context) (_ *Context, err error) {
 	for i, stmt := range b.Statements {
 		if l, ok := stmt.(*Label); ok {
 			ctx.labels[l.Name] = i
 		}
 	}
+	scope := ctx
+	// scopes[i] is the scope statement i was last evaluated in, restored by goto
+	scopes := make([]*Context, len(b.Statements))
+	var tbc []closeVar
+	defer func() {
+		if err == nil && len(tbc) > 0 {
+			// a call returned in the scope of to-be-closed variables
+			// is made before they are closed
+			err = ctx.finishTailCall()
+		}
+		err = ctx.closeVars(tbc, err)
+	}()
 	for i := 0; i < len(b.Statements); i++ {
 		stmt := b.Statements[i]
-		_, err := stmt.Eval(ctx)
+		switch stmt.(type) {
+		case *LocalVarDeclaration, *LocalFunction:
+			scope = scope.NewChild()
+		}
+		scopes[i] = scope
+		_, err := stmt.Eval(scope)
 		if err != nil {
 			var gotoErr *GotoError
 			if errors.As(err, &gotoErr) {
 				if labelIndex, ok := ctx.labels[gotoErr.Label]; ok {
+					if labelIndex < i {
+						scope = scopes[labelIndex]
+						// a backward jump leaves the scope of the variables declared after the label
+						n := len(tbc)
+						for n > 0 && tbc[n-1].index >= labelIndex {
+							n--
+						}
+						if err := ctx.closeVars(tbc[n:], nil); err != nil {
+							return scope, err
+						}
+						tbc = tbc[:n]
+					}
 					i = labelIndex // Переход к метке
 					continue
 				} else {
-					return nil, err
+					return scope, ctx.error(stmt, err)
 				}
 			}
-			return nil, fmt.Errorf("error evaluating statement: %w", err)
+			return scope, ctx.error(stmt, err)
 		}
-
-		if ctx.isReturned {
-			if ctx.Parent != nil {
-				ctx.Parent.isReturned = true
-				ctx.Parent.Return = ctx.Return
+		if decl, ok := stmt.(*LocalVarDeclaration); ok {
+			if name, ok := decl.closeVar(); ok {
+				val, _ := scope.local(name)
+				if !ctx.meta.Closable(val) {
+					return scope, ctx.errorf(stmt, "variable '%s' got a non-closable value", name)
+				}
+				tbc = append(tbc, closeVar{index: i, value: val})
 			}
-			return ctx.Return, nil
+		}
+
+		if scope.isReturned {
+			ctx.passReturn(scope)
+			return scope, nil
 		}
 	}
 	if b.ReturnStatement != nil {
-		var vals []Value
-		for _, exp := range b.ReturnStatement.Expressions {
-			val, err := exp.Eval(ctx)
+		if call, ok := b.ReturnStatement.tailCall(); ok {
+			fn, args, err := call.evalCall(scope)
 			if err != nil {
-				return nil, fmt.Errorf("error evaluating return expression: %w", err)
+				return scope, err
 			}
-			vals = append(vals, val)
+			ctx.setReturn(nil)
+			ctx.tail = &tailCall{call: call, ctx: scope, fn: fn, args: args}
+			return scope, nil
 		}
-		ctx.isReturned = true
-		if len(vals) == 1 {
-			ctx.Return = vals[0]
-		} else {
-			ctx.Return = vals // Можно сделать многозначный return как в Lua
+		vals, err := scope.evalExpressions(b.ReturnStatement.Expressions)
+		if err != nil {
+			return scope, err
 		}
-		if ctx.Parent != nil {
-			ctx.Parent.isReturned = true
-			ctx.Parent.Return = ctx.Return
+		ctx.setReturn(vals)
+	}
+	return scope, nil
+}
+
+// tailCall returns the call of a return statement of the form return f(args).
+func (r *ReturnStatement) tailCall() (*FunctionCall, bool) {
+	if len(r.Expressions) != 1 {
+		return nil, false
+	}
+	call, ok := r.Expressions[0].(*FunctionCall)
+	return call, ok
+}
+
+// closeVar is a to-be-closed variable declared by statement index of a block.
+type closeVar struct {
+	index int
+	value Value
+}
+
+// closeVars calls the __close metamethods of vars in reverse order.
+// err is the error that exits their scope, if any; an error
+// in a metamethod replaces it.
+func (ctx *Context) closeVars(vars []closeVar, err error) error {
+	var gotoErr *GotoError
+	for i := len(vars) - 1; i >= 0; i-- {
+		var errValue Value
+		if err != nil && !errors.Is(err, ErrBreak) && !errors.As(err, &gotoErr) {
+			errValue = meta.ErrorValue(err)
+		}
+		if closeErr := ctx.meta.Close(vars[i].value, errValue); closeErr != nil {
+			err = closeErr
 		}
-		return ctx.Return, nil
 	}
-	return nil, nil
+	return err
+}
+
+func (ctx *Context) setReturn(vals []Value) {
+	ctx.isReturned = true
+	ctx.Return = vals
+}
+
+// passReturn passes a return from scope, a nested scope, on to ctx.
+func (ctx *Context) passReturn(scope *Context) {
+	ctx.setReturn(scope.Return)
+	ctx.tail = scope.tail
+}
+
+// finishTailCall makes the pending tail call of ctx, if any,
+// and returns its results from ctx.
+func (ctx *Context) finishTailCall() error {
+	tc := ctx.tail
+	if tc == nil {
+		return nil
+	}
+	ctx.tail = nil
+	vals, err := tc.do()
+	ctx.Return = vals
+	return err
+}
+
+// evalBlock evaluates a nested block in scope, a child of ctx,
+// and passes a return from inside the block on to ctx.
+func (ctx *Context) evalBlock(b *Block, scope *Context) (Value, error) {
+	_, err := b.eval(scope)
+	if scope.isReturned {
+		ctx.passReturn(scope)
+	}
+	return first(scope.Return), err
 }
 
 func (f *FunctionDefinition) Eval(ctx *Context) (Value, error) {
-	return &FunctionValue{
-		Params:   f.FunctionBody.ParameterList.Names,
-		IsVarArg: f.FunctionBody.ParameterList.IsVarArg,
-		Body:     f.FunctionBody.Block,
-	}, nil
+	return f.FunctionBody.Eval(ctx)
 }
 
 func (fc *FunctionCall) Eval(ctx *Context) (Value, error) {
-	prefixVal, _ := fc.PrefixExp.Eval(ctx)
-	var (
-		fn           *FunctionValue
-		fnNative     *NativeFunction
-		table        map[interface{}]Value
-		ok           bool
-		isMethodCall bool
-	)
+	vals, err := fc.EvalMulti(ctx)
+	return first(vals), err
+}
+
+func (fc *FunctionCall) EvalMulti(ctx *Context) ([]Value, error) {
+	fn, args, err := fc.evalCall(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fc.call(ctx, fn, args)
+}
+
+// evalCall evaluates the function and the arguments of the call.
+func (fc *FunctionCall) evalCall(ctx *Context) (Value, []Value, error) {
+	fn, err := fc.PrefixExp.Eval(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var args []Value
 	if fc.Name != "" {
-		isMethodCall = true
-		if table, ok = prefixVal.(map[interface{}]Value); ok {
-			field, ok := table[fc.Name]
-			if !ok {
-				return nil, fmt.Errorf("undefined method '%s' for table", fc.Name)
-			}
-			fn, ok = field.(*FunctionValue)
-			if !ok {
-				return nil, fmt.Errorf("expected function for method '%s', got: %T", fc.Name, field)
-			}
-		} else {
-			return nil, fmt.Errorf("prefix expression is not a table for method call: %T", prefixVal)
-		}
-	} else {
-		switch val := prefixVal.(type) {
-		case *NativeFunction:
-			fnNative = val
-		case *FunctionValue:
-			fn = val
-		default:
-			return nil, fmt.Errorf("expected function or native function for function call, got: %T", prefixVal)
+		obj := fn
+		if fn, err = ctx.meta.Index(obj, fc.Name); err != nil {
+			return nil, nil, ctx.error(fc, ctx.addVarInfo(err, []Expression{fc.PrefixExp}, []Value{obj}))
 		}
+		// the object is passed as self
+		args = append(args, obj)
 	}
 
-	fnCtx := ctx.NewChild()
-
-	var args []Value
 	switch a := fc.Args.(type) {
 	case []Expression:
-		for _, exp := range a {
-			val, err := exp.Eval(ctx)
-			if err != nil {
-				return nil, fmt.Errorf("error evaluating argument: %w", err)
-			}
-			args = append(args, val)
+		vals, err := ctx.evalExpressions(a)
+		if err != nil {
+			return nil, nil, err
 		}
+		args = append(args, vals...)
 	case *TableConstructorExpression:
 		t, err := a.Eval(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating table constructor: %w", err)
+			return nil, nil, err
 		}
 		args = append(args, t)
 	case *LiteralString:
 		args = append(args, a.Value)
 	}
+	return fn, args, nil
+}
 
-	if fnNative != nil {
-		return fnNative.Call(fnCtx, args), nil
+// call calls fn, the function of the call evaluated in ctx,
+// and describes its errors with the call.
+func (fc *FunctionCall) call(ctx *Context, fn Value, args []Value) ([]Value, error) {
+	vals, err := ctx.call(fn, args)
+	if err != nil {
+		err = meta.AddVarInfo(err, []Value{fn}, func(int) string {
+			if fc.Name != "" {
+				return fmt.Sprintf("method '%s'", fc.Name)
+			}
+			return VarInfo(fc.PrefixExp, ctx.varKind)
+		})
+		return nil, NewCallError(ctx.chunk, fc.Position(), fc.Callee(), err)
 	}
+	return vals, nil
+}
 
-	params := fn.Params
-	if isMethodCall {
-		fnCtx.Variables[params[0]] = table
-		params = params[1:]
+// Callee describes the called function for tracebacks.
+func (fc *FunctionCall) Callee() string {
+	if fc.Name != "" {
+		return fmt.Sprintf("method '%s'", fc.Name)
 	}
-	for i, name := range params {
-		if i < len(args) {
-			fnCtx.Variables[name] = args[i]
+	switch v := fc.PrefixExp.(type) {
+	case *NameVar:
+		return fmt.Sprintf("function '%s'", v.Name)
+	case *MemberVar:
+		return fmt.Sprintf("field '%s'", v.Name)
+	default:
+		return "function <?>"
+	}
+}
+
+// call calls a function value, or a value with the __call metamethod,
+// and returns all its results.
+func (ctx *Context) call(fn Value, args []Value) ([]Value, error) {
+	if err := ctx.meta.EnterCall(); err != nil {
+		return nil, err
+	}
+	defer ctx.meta.LeaveCall()
+	switch f := fn.(type) {
+	case *NativeFunction:
+		return f.Call(ctx, args)
+	case *stdlib.Function:
+		return f.Fn(ctx.meta, args)
+	case *FunctionValue:
+		return f.call(args)
+	}
+	if h, ok := ctx.meta.CallHandler(fn); ok {
+		return ctx.call(h, append([]Value{fn}, args...))
+	}
+	return nil, &meta.TypeError{Op: "call", Value: fn, Type: typeName(fn)}
+}
+
+// call runs the function, and then the functions of its tail calls
+// in the same Go stack frame.
+func (fn *FunctionValue) call(args []Value) ([]Value, error) {
+	for {
+		// the body runs in the scope the function was defined in
+		fnCtx := fn.Env.NewChild()
+		fnCtx.isFunction = true
+
+		for i, name := range fn.Params {
+			if i < len(args) {
+				fnCtx.Variables[name] = args[i]
+			} else {
+				fnCtx.Variables[name] = nil
+			}
+		}
+		if fn.IsVarArg {
+			varargs := []Value{}
+			if len(args) > len(fn.Params) {
+				varargs = args[len(fn.Params):]
+			}
+			fnCtx.Variables["..."] = varargs
 		} else {
-			fnCtx.Variables[name] = nil
+			// hide the varargs of the enclosing function
+			fnCtx.Variables["..."] = nil
 		}
-	}
-	if fn.IsVarArg {
-		var varargs []Value
-		if len(args) > len(params) {
-			varargs = args[len(params):]
+
+		if _, err := fn.Body.eval(fnCtx); err != nil {
+			return nil, err
+		}
+		tc := fnCtx.tail
+		if tc == nil {
+			return fnCtx.Return, nil
 		}
-		fnCtx.Variables["..."] = varargs
+		next, ok := tc.fn.(*FunctionValue)
+		if !ok {
+			return tc.do()
+		}
+		fn, args = next, tc.args
 	}
-
-	res, err := fn.Body.Eval(fnCtx)
-	ctx.isReturned = false
-	return res, err
 }
 
 func (s *EmptyStatement) Eval(_ *Context) (Value, error) {
@@ -403,125 +766,89 @@ func (s *EmptyStatement) Eval(_ *Context) (Value, error) {
 }
 
 func (v *NameVar) Eval(ctx *Context) (Value, error) {
-	return ctx.Get(v.Name), nil
+	val, err := ctx.Get(v.Name)
+	if err != nil {
+		return nil, ctx.error(v, err)
+	}
+	return val, nil
 }
 
 func (v *IndexedVar) Eval(ctx *Context) (Value, error) {
-	var table map[interface{}]Value
 	prefix, err := v.PrefixExp.Eval(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error evaluating prefix expression: %w", err)
+		return nil, err
 	}
-	if tbl, ok := prefix.(map[interface{}]Value); !ok {
-		return nil, fmt.Errorf("expected table for indexed variable, got: %T", table)
-	} else {
-		table = tbl
+	key, err := v.Exp.Eval(ctx)
+	if err != nil {
+		return nil, err
 	}
-	key, _ := v.Exp.Eval(ctx)
-	val, ok := table[key]
-	if !ok {
-		return nil, nil
+	val, err := ctx.meta.Index(prefix, key)
+	if err != nil {
+		return nil, ctx.error(v, ctx.addVarInfo(err, []Expression{v.PrefixExp}, []Value{prefix}))
 	}
 	return val, nil
 }
 
 func (v *MemberVar) Eval(ctx *Context) (Value, error) {
-	var table map[interface{}]Value
 	prefix, err := v.PrefixExp.Eval(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error evaluating prefix expression: %w", err)
+		return nil, err
 	}
-	if tbl, ok := prefix.(map[interface{}]Value); !ok {
-		return nil, fmt.Errorf("expected table for member variable, got: %T", table)
-	} else {
-		table = tbl
+	val, err := ctx.meta.Index(prefix, v.Name)
+	if err != nil {
+		return nil, ctx.error(v, ctx.addVarInfo(err, []Expression{v.PrefixExp}, []Value{prefix}))
 	}
-	return table[v.Name], nil
+	return val, nil
 }
 
+// Eval evaluates the values in the enclosing scope, where the new variables
+// are not visible yet, and declares them in ctx, the scope opened for them.
 func (s *LocalVarDeclaration) Eval(ctx *Context) (Value, error) {
-	for i := 0; i < len(s.Vars); i++ {
-		name := s.Vars[i]
-		if i < len(s.Exps)-1 {
-			val, err := s.Exps[i].Eval(ctx)
-			if err != nil {
-				return nil, fmt.Errorf("error evaluating local variable %s: %w", name, err)
-			}
-			vals, ok := val.([]Value)
-			if ok && len(vals) > 0 {
-				val = vals[0]
-			}
-			ctx.SetLocal(name, val)
-		} else if i == len(s.Exps)-1 {
-			val, err := s.Exps[i].Eval(ctx)
-			if err != nil {
-				return nil, fmt.Errorf("error evaluating local variable %s: %w", name, err)
-			}
-			vals, ok := val.([]Value)
-			if ok {
-				for _, v := range vals {
-					if i < len(s.Vars) {
-						ctx.SetLocal(s.Vars[i], v)
-						i++
-					} else {
-						break
-					}
-				}
-			} else {
-				ctx.SetLocal(name, val)
-			}
-		} else {
-			ctx.SetLocal(name, nil)
-		}
+	scope := ctx
+	if ctx.Parent != nil {
+		scope = ctx.Parent
+	}
+	vals, err := scope.evalExpressions(s.Exps)
+	if err != nil {
+		return nil, err
+	}
+	vals = adjust(vals, len(s.Vars))
+	for i, name := range s.Vars {
+		ctx.SetLocal(name, vals[i])
 	}
 	return nil, nil
 }
 
+// closeVar returns the name of the to-be-closed variable of the declaration.
+func (s *LocalVarDeclaration) closeVar() (string, bool) {
+	for i, attrib := range s.Attribs {
+		if attrib == "close" {
+			return s.Vars[i], true
+		}
+	}
+	return "", false
+}
+
+// Eval follows the order of the reference implementation: the tables and keys
+// of the targets are evaluated left to right, then the values, and only then
+// the values are assigned, from the last target to the first.
 func (s *Assignment) Eval(ctx *Context) (Value, error) {
-	for i := 0; i < len(s.Vars); i++ {
-		v := s.Vars[i]
-		if i < len(s.Exps)-1 {
-			val, err := s.Exps[i].Eval(ctx)
-			if err != nil {
-				return nil, fmt.Errorf("error evaluating local variable %s: %w", v, err)
-			}
-			vals, ok := val.([]Value)
-			if ok && len(vals) > 0 {
-				val = vals[0]
-			}
-			err = v.Set(ctx, val)
-			if err != nil {
-				return nil, fmt.Errorf("error setting value for variable %s: %w", v, err)
-			}
-		} else if i == len(s.Exps)-1 {
-			val, err := s.Exps[i].Eval(ctx)
-			if err != nil {
-				return nil, fmt.Errorf("error evaluating local variable %s: %w", v, err)
-			}
-			vals, ok := val.([]Value)
-			if ok {
-				for _, vl := range vals {
-					if i < len(s.Vars) {
-						err = s.Vars[i].Set(ctx, vl)
-						if err != nil {
-							return nil, fmt.Errorf("error setting value for variable %s: %w", v, err)
-						}
-						i++
-					} else {
-						break
-					}
-				}
-			} else {
-				err = v.Set(ctx, val)
-				if err != nil {
-					return nil, fmt.Errorf("error setting value for variable %s: %w", v, err)
-				}
-			}
-		} else {
-			err := v.Set(ctx, nil)
-			if err != nil {
-				return nil, fmt.Errorf("error setting value for variable %s: %w", v, err)
-			}
+	assigns := make([]func(Value) error, len(s.Vars))
+	for i, v := range s.Vars {
+		assign, err := v.Target(ctx)
+		if err != nil {
+			return nil, err
+		}
+		assigns[i] = assign
+	}
+	vals, err := ctx.evalExpressions(s.Exps)
+	if err != nil {
+		return nil, err
+	}
+	vals = adjust(vals, len(s.Vars))
+	for i := len(assigns) - 1; i >= 0; i-- {
+		if err := assigns[i](vals[i]); err != nil {
+			return nil, err
 		}
 	}
 	return nil, nil
@@ -535,20 +862,20 @@ func (s *Goto) Eval(_ *Context) (Value, error) {
 	return nil, &GotoError{Label: s.Name}
 }
 
-func (s *Break) Eval(_ *Context) (Value, error) {
-	return nil, ErrBreak
+func (s *Break) Eval(ctx *Context) (Value, error) {
+	return nil, ctx.error(s, ErrBreak)
 }
 
 func (s *Do) Eval(ctx *Context) (Value, error) {
-	newCtx := ctx.NewChild()
-	return s.Block.Eval(newCtx)
+	return ctx.evalBlock(&s.Block, ctx.NewChild())
 }
 
 func (s *LocalFunction) Eval(ctx *Context) (Value, error) {
-	fn := &FunctionValue{
-		Params:   s.FunctionBody.ParameterList.Names,
-		IsVarArg: s.FunctionBody.ParameterList.IsVarArg,
-		Body:     s.FunctionBody.Block,
+	// the local is declared before the body is closed over, so the function can call itself
+	ctx.SetLocal(s.Name, nil)
+	fn, err := s.FunctionBody.Eval(ctx)
+	if err != nil {
+		return nil, err
 	}
 	ctx.SetLocal(s.Name, fn)
 	return nil, nil
@@ -558,17 +885,17 @@ func (s *While) Eval(ctx *Context) (Value, error) {
 	for {
 		cond, err := s.Exp.Eval(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating while condition: %w", err)
+			return nil, err
 		}
 		if !isTruthy(cond) {
 			break
 		}
-		_, err = s.Block.Eval(ctx.NewChild())
+		_, err = ctx.evalBlock(&s.Block, ctx.NewChild())
 		if errors.Is(err, ErrBreak) {
 			break // прерывание цикла
 		}
-		if err != nil {
-			return nil, fmt.Errorf("error evaluating while block: %w", err)
+		if err != nil || ctx.isReturned {
+			return nil, err
 		}
 	}
 	return nil, nil
@@ -576,16 +903,22 @@ func (s *While) Eval(ctx *Context) (Value, error) {
 
 func (s *Repeat) Eval(ctx *Context) (Value, error) {
 	for {
-		_, err := s.Block.Eval(ctx.NewChild())
+		// the condition can see the locals of the block
+		blockCtx := ctx.NewChild()
+		scope, err := s.Block.eval(blockCtx)
+		if blockCtx.isReturned {
+			ctx.passReturn(blockCtx)
+			return nil, nil
+		}
 		if errors.Is(err, ErrBreak) {
 			break // прерывание цикла
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating repeat block: %w", err)
+			return nil, err
 		}
-		cond, err := s.Exp.Eval(ctx)
+		cond, err := s.Exp.Eval(scope)
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating repeat condition: %w", err)
+			return nil, err
 		}
 		if isTruthy(cond) {
 			break
@@ -595,72 +928,79 @@ func (s *Repeat) Eval(ctx *Context) (Value, error) {
 }
 
 func (s *For) Eval(ctx *Context) (Value, error) {
-	val, err := s.Init.Eval(ctx)
+	init, err := s.Init.Eval(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error evaluating for loop init: %w", err)
+		return nil, err
 	}
-	init, ok := val.(float64)
-	if !ok {
-		return nil, fmt.Errorf("expected numeric value for for loop init, got: %T", val)
-	}
-	val, err = s.Limit.Eval(ctx)
+	limit, err := s.Limit.Eval(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error evaluating for loop limit: %w", err)
-	}
-	limit, ok := val.(float64)
-	if !ok {
-		return nil, fmt.Errorf("expected numeric value for for loop limit, got: %T", val)
+		return nil, err
 	}
-	step := 1.0
+	var step Value = int64(1)
 	if s.Step != nil {
-		val, err = s.Step.Eval(ctx)
+		step, err = s.Step.Eval(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating for loop step: %w", err)
+			return nil, err
 		}
-		step, ok = val.(float64)
+	}
+	loop, err := number.NewForLoop(init, limit, step)
+	if err != nil {
+		return nil, ctx.error(s, err)
+	}
+	for {
+		i, ok := loop.Next()
 		if !ok {
-			return nil, fmt.Errorf("expected numeric value for for loop step, got: %T", val)
+			break
 		}
-	}
-	for i := init; (step > 0 && i <= limit) || (step < 0 && i >= limit); i += step {
 		loopCtx := ctx.NewChild()
 		loopCtx.SetLocal(s.Name, i)
-		_, err = s.Block.Eval(loopCtx)
+		_, err = ctx.evalBlock(&s.Block, loopCtx)
 		if errors.Is(err, ErrBreak) {
 			break // прерывание цикла
 		}
-		if err != nil {
-			return nil, fmt.Errorf("error evaluating for loop body: %w", err)
+		if err != nil || ctx.isReturned {
+			return nil, err
 		}
 	}
 	return nil, nil
 }
 
-func (s *ForIn) Eval(ctx *Context) (Value, error) {
-	// todo:
-	val, err := s.Exps[0].Eval(ctx)
+// Eval runs the generic for: the expressions give the iterator function,
+// the state, the initial control value and a closing value, which is
+// closed when the loop ends.
+func (s *ForIn) Eval(ctx *Context) (_ Value, err error) {
+	vals, err := ctx.evalExpressions(s.Exps)
 	if err != nil {
-		return nil, fmt.Errorf("error evaluating for-in iterator: %w", err)
+		return nil, err
 	}
-	iter, ok := val.(func() (map[string]Value, bool))
-	if !ok {
-		return nil, fmt.Errorf("expected iterator function for for-in loop, got: %T", val)
+	vals = adjust(vals, 4)
+	iter, state, control, closing := vals[0], vals[1], vals[2], vals[3]
+	if !ctx.meta.Closable(closing) {
+		return nil, ctx.errorf(s, "variable '(for state)' got a non-closable value")
 	}
+	defer func() {
+		err = ctx.closeVars([]closeVar{{value: closing}}, err)
+	}()
 	for {
-		val, ok := iter()
-		if !ok {
+		vals, err := ctx.call(iter, []Value{state, control})
+		if err != nil {
+			return nil, NewCallError(ctx.chunk, s.Position(), "for iterator 'for iterator'", err)
+		}
+		vals = adjust(vals, len(s.Names))
+		if vals[0] == nil {
 			break
 		}
+		control = vals[0]
 		loopCtx := ctx.NewChild()
-		for _, name := range s.Names {
-			loopCtx.SetLocal(name, val[name]) // упрощённо
+		for i, name := range s.Names {
+			loopCtx.SetLocal(name, vals[i])
 		}
-		_, err := s.Block.Eval(loopCtx)
+		_, err = ctx.evalBlock(&s.Block, loopCtx)
 		if errors.Is(err, ErrBreak) {
 			break // прерывание цикла
 		}
-		if err != nil {
-			return nil, fmt.Errorf("error evaluating for-in loop body: %w", err)
+		if err != nil || ctx.isReturned {
+			return nil, err
 		}
 	}
 	return nil, nil
@@ -670,15 +1010,15 @@ func (s *If) Eval(ctx *Context) (Value, error) {
 	for i, cond := range s.Exps {
 		res, err := cond.Eval(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating if condition: %w", err)
+			return nil, err
 		}
 		if isTruthy(res) {
-			return s.Blocks[i].Eval(ctx.NewChild())
+			return ctx.evalBlock(&s.Blocks[i], ctx.NewChild())
 		}
 	}
 	// else-блок, если он есть
 	if len(s.Blocks) > len(s.Exps) {
-		return s.Blocks[len(s.Blocks)-1].Eval(ctx.NewChild())
+		return ctx.evalBlock(&s.Blocks[len(s.Blocks)-1], ctx.NewChild())
 	}
 	return nil, nil
 }
@@ -694,10 +1034,13 @@ func isTruthy(val Value) bool {
 	}
 }
 
+// FunctionValue is a closure: a function body together with
+// the scope it was defined in, whose variables it shares.
 type FunctionValue struct {
 	Params   []string
 	IsVarArg bool
 	Body     Block
+	Env      *Context
 }
 
 func (fb *FunctionBody) Eval(ctx *Context) (Value, error) {
@@ -705,78 +1048,90 @@ func (fb *FunctionBody) Eval(ctx *Context) (Value, error) {
 		Params:   fb.ParameterList.Names,
 		IsVarArg: fb.ParameterList.IsVarArg,
 		Body:     fb.Block,
+		Env:      ctx,
 	}, nil
 }
 
 func (f *Function) Eval(ctx *Context) (Value, error) {
 	body, err := f.FuncBody.Eval(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error evaluating function body: %w", err)
+		return nil, err
 	}
 	fnVal, ok := body.(*FunctionValue)
 	if !ok {
-		return nil, fmt.Errorf("expected function value, got: %T", fnVal)
+		return nil, ctx.errorf(f, "expected function value, got: %T", fnVal)
 	}
 
 	if len(f.FunctionName.PrefixNames) > 0 {
-		table := ctx.Get(f.FunctionName.PrefixNames[0]).(map[interface{}]Value)
-		for i, name := range f.FunctionName.PrefixNames[1:] {
-			if field, ok := table[name]; ok {
-				if innerTable, ok := field.(map[interface{}]Value); ok {
-					table = innerTable
-				} else {
-					return nil, fmt.Errorf("expected table for prefix name '%s', got: %T", name, field)
-				}
-			} else {
-				return nil, fmt.Errorf(
-					"undefined table name '%s' in function definition",
-					strings.Join(f.FunctionName.PrefixNames[:i+2], "."),
-				)
+		first := f.FunctionName.PrefixNames[0]
+		obj, err := ctx.Get(first)
+		if err != nil {
+			return nil, ctx.error(f, err)
+		}
+		// info describes the variable obj was read from
+		info := fmt.Sprintf("%s '%s'", ctx.varKind(first), first)
+		for _, name := range f.FunctionName.PrefixNames[1:] {
+			field, err := ctx.meta.Index(obj, name)
+			if err != nil {
+				return nil, ctx.error(f, meta.AddVarInfo(err, []Value{obj}, func(int) string { return info }))
 			}
+			obj, info = field, fmt.Sprintf("field '%s'", name)
 		}
 		if f.FunctionName.IsMethod {
 			fnVal.Params = append([]string{"self"}, fnVal.Params...)
 		}
-		table[f.FunctionName.Name] = fnVal
+		if err := ctx.meta.SetIndex(obj, f.FunctionName.Name, fnVal); err != nil {
+			return nil, ctx.error(f, meta.AddVarInfo(err, []Value{obj}, func(int) string { return info }))
+		}
 	} else {
-		ctx.Set(f.FunctionName.Name, fnVal)
+		if err := ctx.Set(f.FunctionName.Name, fnVal); err != nil {
+			return nil, ctx.error(f, err)
+		}
 	}
 
 	return nil, nil
 }
 
+// Settable is an assignment target.
 type Settable interface {
-	Set(ctx *Context, val Value) error
+	// Target evaluates the table and the key of the variable
+	// and returns the function that assigns a value to it.
+	Target(ctx *Context) (func(val Value) error, error)
 }
 
-func (v *NameVar) Set(ctx *Context, val Value) error {
-	ctx.Set(v.Name, val)
-	return nil
+func (v *NameVar) Target(ctx *Context) (func(val Value) error, error) {
+	return func(val Value) error {
+		return ctx.error(v, ctx.Set(v.Name, val))
+	}, nil
 }
 
-func (v *IndexedVar) Set(ctx *Context, val Value) error {
+func (v *IndexedVar) Target(ctx *Context) (func(val Value) error, error) {
 	prefix, err := v.PrefixExp.Eval(ctx)
 	if err != nil {
-		return fmt.Errorf("error evaluating indexed variable prefix: %w", err)
+		return nil, err
 	}
-	table, ok := prefix.(map[interface{}]Value)
-	if !ok {
-		return fmt.Errorf("expected table for indexed variable, got: %T", prefix)
+	key, err := v.Exp.Eval(ctx)
+	if err != nil {
+		return nil, err
 	}
-	key, _ := v.Exp.Eval(ctx)
-	table[key] = val
-	return nil
+	return ctx.fieldTarget(v, v.PrefixExp, prefix, key), nil
 }
 
-func (v *MemberVar) Set(ctx *Context, val Value) error {
+func (v *MemberVar) Target(ctx *Context) (func(val Value) error, error) {
 	prefix, err := v.PrefixExp.Eval(ctx)
 	if err != nil {
-		return fmt.Errorf("error evaluating member variable prefix: %w", err)
+		return nil, err
 	}
-	table, ok := prefix.(map[interface{}]Value)
-	if !ok {
-		return fmt.Errorf("expected table for member variable, got: %T", prefix)
+	return ctx.fieldTarget(v, v.PrefixExp, prefix, v.Name), nil
+}
+
+// fieldTarget returns the function that assigns a value to the field key
+// of the value of the expression prefixExp; errors are positioned at n.
+func (ctx *Context) fieldTarget(n Positioned, prefixExp Expression, prefix, key Value) func(val Value) error {
+	return func(val Value) error {
+		if err := ctx.meta.SetIndex(prefix, key, val); err != nil {
+			return ctx.error(n, ctx.addVarInfo(err, []Expression{prefixExp}, []Value{prefix}))
+		}
+		return nil
 	}
-	table[v.Name] = val
-	return nil
 }
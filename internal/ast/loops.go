@@ -2,14 +2,17 @@ package ast
 
 type (
 	While struct {
+		Node
 		Exp   Expression
 		Block Block
 	}
 	Repeat struct {
+		Node
 		Block Block
 		Exp   Expression
 	}
 	For struct {
+		Node
 		Name  string
 		Init  Expression
 		Limit Expression
@@ -17,6 +20,7 @@ type (
 		Block Block
 	}
 	ForIn struct {
+		Node
 		Names []string
 		Exps  []Expression
 		Block Block
@@ -1,12 +1,21 @@
 package ast
 
-import "fmt"
+import (
+	"fmt"
 
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/stdlib"
+	"lua-interpreter/internal/table"
+)
+
+// NativeFunction is a function implemented in Go.
+// It returns the list of its results.
 type NativeFunction struct {
-	Fn func(ctx *Context, args []Value) Value
+	Fn func(ctx *Context, args []Value) ([]Value, error)
 }
 
-func (nf *NativeFunction) Call(ctx *Context, args []Value) Value {
+func (nf *NativeFunction) Call(ctx *Context, args []Value) ([]Value, error) {
 	return nf.Fn(ctx, args)
 }
 
@@ -16,64 +25,57 @@ func toString(val Value) string {
 		return "nil"
 	case string:
 		return v
-	case float64:
-		return fmt.Sprintf("%g", v)
+	case int64, float64:
+		return number.ToString(v)
 	case bool:
 		return fmt.Sprintf("%t", v)
-	case *FunctionValue, *NativeFunction:
+	case *FunctionValue, *NativeFunction, *stdlib.Function:
+		return fmt.Sprintf("function: %p", v)
+	case *table.Table:
+		return fmt.Sprintf("table: %p", v)
+	case *coroutine.Coroutine:
+		return fmt.Sprintf("thread: %p", v)
+	default:
+		return fmt.Sprintf("<unknown:%T>", v)
+	}
+}
+
+// typeName returns the Lua type of a value.
+func typeName(val Value) string {
+	switch val.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case *FunctionValue, *NativeFunction, *stdlib.Function:
 		return "function"
-	case map[interface{}]Value:
+	case *table.Table:
 		return "table"
+	case *coroutine.Coroutine:
+		return "thread"
 	default:
-		return fmt.Sprintf("<unknown:%T>", v)
+		return "userdata"
 	}
 }
 
 var printFn = &NativeFunction{
-	Fn: func(ctx *Context, args []Value) Value {
+	Fn: func(ctx *Context, args []Value) ([]Value, error) {
 		for i, arg := range args {
 			if i > 0 {
 				fmt.Print("\t")
 			}
-			vals, ok := arg.([]Value)
-			if ok && len(vals) > 0 {
-				if i == len(args)-1 {
-					for j, v := range vals {
-						if j > 0 {
-							fmt.Print("\t")
-						}
-						fmt.Print(toString(v))
-					}
-				} else {
-					fmt.Print(toString(vals[0]))
-				}
-			} else {
-				fmt.Print(toString(arg))
+			s, err := ctx.meta.ToString(arg, toString)
+			if err != nil {
+				return nil, err
 			}
+			fmt.Print(s)
 		}
 
 		fmt.Println()
-		return nil
-	},
-}
-
-var assertFn = &NativeFunction{
-	Fn: func(ctx *Context, args []Value) Value {
-		if len(args) == 0 {
-			panic("assert: missing condition")
-		}
-
-		cond := args[0]
-		if cond == false || cond == nil {
-			var msg string
-			if len(args) > 1 {
-				msg, _ = args[1].(string)
-			} else {
-				msg = "assertion failed!"
-			}
-			panic("assert: " + msg)
-		}
-
-		return []Value{cond}
+		return nil, nil
 	},
 }
@@ -0,0 +1,99 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+
+	"lua-interpreter/internal/lexer"
+	"lua-interpreter/internal/meta"
+)
+
+// Node holds the position of the first token of a syntax tree node.
+type Node struct {
+	Pos lexer.Position
+}
+
+func (n *Node) Position() lexer.Position {
+	return n.Pos
+}
+
+// Positioned is implemented by every node that embeds Node.
+type Positioned interface {
+	Position() lexer.Position
+}
+
+// Error is an error attributed to a position in a chunk.
+// It is formatted as chunkname:line:col: message.
+type Error struct {
+	Chunk string
+	Pos   lexer.Position
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Chunk, e.Pos.Line, e.Pos.Column, e.Err.Error())
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Where returns the position of the error in a traceback, chunkname:line.
+func (e *Error) Where() string {
+	return where(e.Chunk, e.Pos)
+}
+
+func where(chunk string, pos lexer.Position) string {
+	return fmt.Sprintf("%s:%d", chunk, pos.Line)
+}
+
+// NewError attaches a position to err unless it already carries one.
+func NewError(chunk string, pos lexer.Position, err error) error {
+	if err == nil {
+		return nil
+	}
+	var posErr *Error
+	if errors.As(err, &posErr) {
+		return err
+	}
+	// the value of a Lua error is kept as it is, it only learns the
+	// position of a metamethod call it leaves
+	var luaErr *meta.LuaError
+	if errors.As(err, &luaErr) {
+		luaErr.Locate(where(chunk, pos))
+		return err
+	}
+	return &Error{Chunk: chunk, Pos: pos, Err: err}
+}
+
+// NewCallError turns an error that leaves a call at pos into a Lua error:
+// a runtime error gets the position, and the call, described by callee,
+// is added to the traceback.
+func NewCallError(chunk string, pos lexer.Position, callee string, err error) error {
+	// a Lua error already has its position in the callee
+	var luaErr *meta.LuaError
+	if !errors.As(err, &luaErr) {
+		luaErr = meta.NewLuaError(NewError(chunk, pos, err))
+	}
+	luaErr.PassCall(where(chunk, pos), callee)
+	return luaErr
+}
+
+// VarInfo describes the variable the value of exp is read from for error
+// messages, e.g. "global 'x'" or "field 'y'", or returns "" if exp is not
+// a variable. kind tells whether a name is a "local", an "upvalue" or a "global".
+func VarInfo(exp Expression, kind func(name string) string) string {
+	switch e := exp.(type) {
+	case *ParenExpression:
+		return VarInfo(e.Exp, kind)
+	case *NameVar:
+		return fmt.Sprintf("%s '%s'", kind(e.Name), e.Name)
+	case *MemberVar:
+		return fmt.Sprintf("field '%s'", e.Name)
+	case *IndexedVar:
+		if key, ok := e.Exp.(*LiteralString); ok {
+			return fmt.Sprintf("field '%s'", key.Value)
+		}
+	}
+	return ""
+}
@@ -1,15 +1,20 @@
 package ast
 
 type (
-	EmptyStatement struct{}
-	NameVar        struct {
+	EmptyStatement struct {
+		Node
+	}
+	NameVar struct {
+		Node
 		Name string
 	}
 	IndexedVar struct {
+		Node
 		PrefixExp PrefixExpression
 		Exp       Expression
 	}
 	MemberVar struct {
+		Node
 		PrefixExp PrefixExpression
 		Name      string
 	}
@@ -19,31 +24,44 @@ type (
 		Evaluable
 		Settable
 	}
+	// LocalVarDeclaration
+	// local attnamelist [‘=’ explist]
 	LocalVarDeclaration struct {
+		Node
 		Vars []string
-		Exps []Expression
+		// Attribs holds the attribute of each variable: "", "const" or "close"
+		Attribs []string
+		Exps    []Expression
 	}
 	Assignment struct {
+		Node
 		Vars []Var
 		Exps []Expression
 	}
 	Label struct {
+		Node
 		Name string
 	}
-	Break struct{}
-	Goto  struct {
+	Break struct {
+		Node
+	}
+	Goto struct {
+		Node
 		Name string
 	}
 	Do struct {
+		Node
 		Block Block
 	}
 	If struct {
+		Node
 		Exps   []Expression
 		Blocks []Block
 	}
 	// LocalFunction
 	// local function Name funcbody
 	LocalFunction struct {
+		Node
 		Name         string
 		FunctionBody FunctionBody
 	}
@@ -65,5 +83,6 @@ type (
 	//	|  local namelist [‘=’ explist]
 	Statement interface {
 		Evaluable
+		Positioned
 	}
 )
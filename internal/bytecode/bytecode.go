@@ -1,7 +1,13 @@
 package bytecode
 
 type Bytecode struct {
+	// Chunk is the name of the compiled chunk used in error messages
+	Chunk     string
 	Code      []Instruction
 	LocalVars []string
 	Constants []interface{}
 }
+
+// MultRet as a number of results of a call or '...' keeps all the values.
+// The number of values pushed is then known only at run time.
+const MultRet = -1
@@ -1,8 +1,12 @@
 package bytecode
 
+import "lua-interpreter/internal/meta"
+
 type Function struct {
 	Bytecode  Bytecode
 	NumParams int
 	IsVararg  bool
 	Upvalues  []Value
+	// Env is the upvalue _ENV, shared by the functions of a chunk
+	Env *meta.Env
 }
@@ -1,6 +1,13 @@
 package bytecode
 
+import "lua-interpreter/internal/lexer"
+
 type Instruction struct {
 	Op   OpCode
 	Args []interface{}
+	// Pos is the position of the source code the instruction was compiled from
+	Pos lexer.Position
+	// VarInfo describes the variables the operands were read from
+	// for error messages, e.g. "local 't'"; it may be shorter than the operands
+	VarInfo []string
 }
@@ -14,8 +14,15 @@ const (
 	OpSub
 	OpMul
 	OpDiv
+	OpIDiv
 	OpMod
 	OpPow
+	OpBAnd
+	OpBOr
+	OpBXor
+	OpShl
+	OpShr
+	OpBNot
 	OpUnm
 	OpNot
 	OpLen
@@ -29,17 +36,24 @@ const (
 	OpTest
 	OpTestSet
 	OpCall
+	OpSelf
 	OpTailCall
 	OpGetGlobal
 	OpSetGlobal
+	OpGetEnv
+	OpSetEnv
 	OpGetLocal
 	OpSetLocal
 	OpNewTable
 	OpSetTable
+	OpSetList
 	OpGetTable
 	OpForPrep
 	OpForLoop
 	OpJmp
 	OpLoadBool
 	OpLoadNil
+	OpPop
+	OpToBeClosed
+	OpClose
 )
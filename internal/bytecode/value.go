@@ -1,3 +1,5 @@
 package bytecode
 
-type Value interface{}
+// Value is any Lua value; it is an alias so that values can be passed
+// to the shared table and meta packages as is.
+type Value = interface{}
@@ -1,8 +1,8 @@
 package compiler
 
 import (
-	"errors"
 	"fmt"
+	"maps"
 
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/bytecode"
@@ -10,16 +10,60 @@ import (
 )
 
 type Compiler struct {
+	// chunk is the name of the compiled chunk used in error messages
+	chunk    string
 	bytecode bytecode.Bytecode
 	// Track local variables in current scope
 	locals map[string]int
 	// Track scope nesting level
 	scopeLevel int
+	// outerLocals holds the locals of the enclosing scopes,
+	// restored when a scope is exited
+	outerLocals []map[string]int
+	// tbcCount is the number of to-be-closed variables in scope;
+	// outerTBCCounts holds it for the enclosing scopes
+	tbcCount       int
+	outerTBCCounts []int
+	// loops holds the loops being compiled, innermost last
+	loops []*loop
+	// labels are the labels visible in the current scope and gotos the jumps
+	// to labels not compiled yet; outerLabelCounts and outerGotoCounts hold
+	// their numbers at the start of the enclosing scopes
+	labels           []label
+	gotos            []pendingGoto
+	outerLabelCounts []int
+	outerGotoCounts  []int
+	// pos is the position of the node being compiled, given to the emitted instructions
+	pos lexer.Position
 }
 
-func New() *Compiler {
+// label is the position of a label and the number of to-be-closed
+// variables in scope there.
+type label struct {
+	name     string
+	pc       int
+	tbcCount int
+}
+
+// pendingGoto is a goto compiled to an OpClose at pos and an OpJmp,
+// patched when its label is compiled.
+type pendingGoto struct {
+	name string
+	pos  int
+}
+
+// loop collects the jumps of the break statements of a loop.
+type loop struct {
+	breaks []int
+	// tbcCount is the number of to-be-closed variables outside the loop body
+	tbcCount int
+}
+
+func New(chunk string) *Compiler {
 	return &Compiler{
+		chunk: chunk,
 		bytecode: bytecode.Bytecode{
+			Chunk:     chunk,
 			Code:      make([]bytecode.Instruction, 0),
 			LocalVars: make([]string, 0),
 			Constants: make([]interface{}, 0),
@@ -34,6 +78,10 @@ func (c *Compiler) Compile(block *ast.Block) (bytecode.Bytecode, error) {
 	if err != nil {
 		return bytecode.Bytecode{}, err
 	}
+	if block.ReturnStatement == nil {
+		// Implicit return without values
+		c.emit(bytecode.OpReturn, 0, false)
+	}
 	return c.bytecode, nil
 }
 
@@ -52,10 +100,6 @@ func (c *Compiler) compileBlock(block *ast.Block) error {
 		if err != nil {
 			return err
 		}
-	} else {
-		// Implicit return nil if no return statement
-		c.emit(bytecode.OpPushNil)
-		c.emit(bytecode.OpReturn)
 	}
 
 	c.exitScope()
@@ -64,6 +108,7 @@ func (c *Compiler) compileBlock(block *ast.Block) error {
 }
 
 func (c *Compiler) compileStatement(stmt ast.Statement) error {
+	defer c.at(stmt)()
 	switch s := stmt.(type) {
 	case *ast.EmptyStatement:
 		return nil
@@ -72,7 +117,16 @@ func (c *Compiler) compileStatement(stmt ast.Statement) error {
 	case *ast.Assignment:
 		return c.compileAssignment(s)
 	case *ast.FunctionCall:
-		return c.compileFunctionCall(s)
+		// the results of a call statement are discarded
+		return c.compileFunctionCall(s, 0)
+	case *ast.Break:
+		return c.compileBreak(s)
+	case *ast.Goto:
+		c.compileGoto(s)
+		return nil
+	case *ast.Label:
+		c.compileLabel(s)
+		return nil
 	case *ast.Do:
 		return c.compileDoBlock(s)
 	case *ast.While:
@@ -85,56 +139,110 @@ func (c *Compiler) compileStatement(stmt ast.Statement) error {
 		return c.compileForIn(s)
 	case *ast.LocalFunction:
 		return c.compileLocalFunction(s)
-	case *ast.FunctionDefinition:
-		return c.compileFunctionDefinition(s)
+	case *ast.Function:
+		return c.compileFunction(s)
 	default:
-		return fmt.Errorf("unsupported statement type: %T", stmt)
+		return c.errorf(stmt, "unsupported statement type: %T", stmt)
 	}
 }
 
 func (c *Compiler) compileLocalVarDecl(decl *ast.LocalVarDeclaration) error {
-	for i, exp := range decl.Exps {
+	err := c.compileExpressionList(decl.Exps, len(decl.Vars))
+	if err != nil {
+		return err
+	}
+
+	// the values are on the stack in order, the last one on top
+	slots := make([]int, len(decl.Vars))
+	for i, name := range decl.Vars {
+		slots[i] = c.declareLocal(name)
+	}
+	for i := len(slots) - 1; i >= 0; i-- {
+		c.emit(bytecode.OpSetLocal, slots[i])
+	}
+	for i, attrib := range decl.Attribs {
+		if attrib == "close" {
+			c.emit(bytecode.OpToBeClosed, slots[i])
+			c.tbcCount++
+		}
+	}
+
+	return nil
+}
+
+// compileExpressionList compiles expressions so that they leave
+// exactly n values on the stack, padding with nils or dropping extra values.
+// A call or '...' at the end of the list gives all the missing values.
+func (c *Compiler) compileExpressionList(exps []ast.Expression, n int) error {
+	for i, exp := range exps {
+		if i == len(exps)-1 && len(exps) <= n {
+			switch e := exp.(type) {
+			case *ast.FunctionCall:
+				return c.compileFunctionCall(e, n-i)
+			case *ast.VarArgExpression:
+				c.emit(bytecode.OpPushVarArg, n-i)
+				return nil
+			}
+		}
 		err := c.compileExpression(exp)
 		if err != nil {
 			return err
 		}
-
-		varName := decl.Vars[i]
-		c.locals[varName] = len(c.locals)
-		c.bytecode.LocalVars = append(c.bytecode.LocalVars, varName)
-
-		c.emit(bytecode.OpSetLocal, c.locals[varName])
 	}
+	if len(exps) > n {
+		c.emit(bytecode.OpPop, len(exps)-n)
+	}
+	for i := len(exps); i < n; i++ {
+		c.emit(bytecode.OpPushNil)
+	}
+	return nil
+}
 
-	for i := len(decl.Exps); i < len(decl.Vars); i++ {
-		c.emit(bytecode.OpLoadNil)
-		varName := decl.Vars[i]
-		c.locals[varName] = len(c.locals)
-		c.bytecode.LocalVars = append(c.bytecode.LocalVars, varName)
-		c.emit(bytecode.OpSetLocal, c.locals[varName])
+// compileOpenExpressionList compiles expressions keeping all the values
+// of a call or '...' at the end of the list. It returns the number of the other
+// values and whether the list ends with such an open expression.
+func (c *Compiler) compileOpenExpressionList(exps []ast.Expression) (int, bool, error) {
+	for i, exp := range exps {
+		if i == len(exps)-1 {
+			switch e := exp.(type) {
+			case *ast.FunctionCall:
+				return i, true, c.compileFunctionCall(e, bytecode.MultRet)
+			case *ast.VarArgExpression:
+				c.emit(bytecode.OpPushVarArg, bytecode.MultRet)
+				return i, true, nil
+			}
+		}
+		err := c.compileExpression(exp)
+		if err != nil {
+			return 0, false, err
+		}
 	}
+	return len(exps), false, nil
+}
 
-	return nil
+// declareLocal allocates a new slot for a local variable in the current scope.
+func (c *Compiler) declareLocal(name string) int {
+	idx := len(c.bytecode.LocalVars)
+	c.locals[name] = idx
+	c.bytecode.LocalVars = append(c.bytecode.LocalVars, name)
+	return idx
 }
 
 func (c *Compiler) compilePrefixExp(prefixExp ast.PrefixExpression) error {
 	switch e := prefixExp.(type) {
 	case *ast.NameVar:
-		if idx, ok := c.locals[e.Name]; ok {
-			c.emit(bytecode.OpGetLocal, idx)
-		} else {
-			c.emit(bytecode.OpGetGlobal, e.Name)
-		}
+		c.compileGetName(e.Name)
 	case *ast.IndexedVar:
 		err := c.compilePrefixExp(e.PrefixExp)
 		if err != nil {
 			return err
 		}
-		err = c.compilePrefixExp(e.Exp)
+		err = c.compileExpression(e.Exp)
 		if err != nil {
 			return err
 		}
 		c.emit(bytecode.OpGetTable)
+		c.setVarInfo(e.PrefixExp)
 	case *ast.MemberVar:
 		err := c.compilePrefixExp(e.PrefixExp)
 		if err != nil {
@@ -142,13 +250,65 @@ func (c *Compiler) compilePrefixExp(prefixExp ast.PrefixExpression) error {
 		}
 		c.emit(bytecode.OpPushString, e.Name)
 		c.emit(bytecode.OpGetTable)
+		c.setVarInfo(e.PrefixExp)
 	default:
-		return fmt.Errorf("unsupported prefix expression type: %T", prefixExp)
+		// a call or an expression in parentheses
+		return c.compileExpression(prefixExp)
 	}
 	return nil
 }
 
+// compileGetName pushes the value of a local or global variable.
+// A global variable is the field of _ENV: a local variable _ENV,
+// or else the upvalue _ENV of the chunk.
+func (c *Compiler) compileGetName(name string) {
+	if idx, ok := c.locals[name]; ok {
+		c.emit(bytecode.OpGetLocal, idx)
+	} else if name == "_ENV" {
+		c.emit(bytecode.OpGetEnv)
+	} else {
+		c.emit(bytecode.OpGetGlobal, name, c.envLocal())
+	}
+}
+
+// envLocal returns the slot of the local variable _ENV, or -1 if there is none.
+func (c *Compiler) envLocal() int {
+	if idx, ok := c.locals["_ENV"]; ok {
+		return idx
+	}
+	return -1
+}
+
+// varKind tells whether a name is a local or a global.
+func (c *Compiler) varKind(name string) string {
+	if _, ok := c.locals[name]; ok {
+		return "local"
+	}
+	return "global"
+}
+
+// setVarInfo describes the operand expressions of the last emitted instruction.
+func (c *Compiler) setVarInfo(operands ...ast.Expression) {
+	info := make([]string, len(operands))
+	for i, exp := range operands {
+		info[i] = ast.VarInfo(exp, c.varKind)
+	}
+	c.bytecode.Code[len(c.bytecode.Code)-1].VarInfo = info
+}
+
+// compileSetName pops a value into a local or global variable.
+func (c *Compiler) compileSetName(name string) {
+	if idx, ok := c.locals[name]; ok {
+		c.emit(bytecode.OpSetLocal, idx)
+	} else if name == "_ENV" {
+		c.emit(bytecode.OpSetEnv)
+	} else {
+		c.emit(bytecode.OpSetGlobal, name, c.envLocal())
+	}
+}
+
 func (c *Compiler) compileExpression(exp ast.Expression) error {
+	defer c.at(exp)()
 	switch e := exp.(type) {
 	case *ast.NumeralExpression:
 		c.emit(bytecode.OpPushNumber, e.Value)
@@ -169,17 +329,60 @@ func (c *Compiler) compileExpression(exp ast.Expression) error {
 			return err
 		}
 	case *ast.VarArgExpression:
-		c.emit(bytecode.OpPushVarArg)
+		c.emit(bytecode.OpPushVarArg, 1)
+	case *ast.ParenExpression:
+		return c.compileExpression(e.Exp)
+	case *ast.TableConstructorExpression:
+		return c.compileTableConstructor(e)
+	case *ast.FunctionDefinition:
+		return c.compileFunctionDefinition(e)
 	case *ast.FunctionCall:
-		err := c.compileFunctionCall(e)
+		err := c.compileFunctionCall(e, 1)
 		if err != nil {
 			return err
 		}
+	case *ast.NameVar, *ast.IndexedVar, *ast.MemberVar:
+		return c.compilePrefixExp(e.(ast.PrefixExpression))
 	default:
-		err := c.compilePrefixExp(exp)
+		return fmt.Errorf("unsupported expression type: %T", exp)
+	}
+	return nil
+}
+
+func (c *Compiler) compileTableConstructor(exp *ast.TableConstructorExpression) error {
+	c.emit(bytecode.OpNewTable)
+	var index int64 = 1
+	for i, field := range exp.Fields {
+		var key, value ast.Expression
+		switch f := field.(type) {
+		case *ast.ExpToExpField:
+			key, value = f.Key, f.Value
+		case *ast.NameField:
+			key, value = &ast.LiteralString{Node: f.Node, Value: f.Name}, f.Value
+		case *ast.ExpressionField:
+			if i == len(exp.Fields)-1 {
+				// a call or '...' in the last field gives all its values
+				n, open, err := c.compileOpenExpressionList([]ast.Expression{f.Value})
+				if err != nil {
+					return err
+				}
+				c.emit(bytecode.OpSetList, index, n, open)
+				return nil
+			}
+			key, value = &ast.NumeralExpression{Node: f.Node, Value: index}, f.Value
+			index++
+		}
+		err := c.compileExpression(key)
 		if err != nil {
-			return fmt.Errorf("unsupported expression type: %T", exp)
+			return err
 		}
+		err = c.compileExpression(value)
+		if err != nil {
+			return err
+		}
+		// the table stays on the stack for the next fields
+		c.emit(bytecode.OpSetTable, 0)
+		c.emit(bytecode.OpPop, 1)
 	}
 	return nil
 }
@@ -190,6 +393,13 @@ func (c *Compiler) compileBinaryOp(exp *ast.BinaryOperatorExpression) error {
 		return err
 	}
 
+	switch exp.Operator.Type {
+	case lexer.TokenKeywordAnd:
+		return c.compileLogicalOp(exp, false)
+	case lexer.TokenKeywordOr:
+		return c.compileLogicalOp(exp, true)
+	}
+
 	err = c.compileExpression(exp.Right)
 	if err != nil {
 		return err
@@ -204,10 +414,22 @@ func (c *Compiler) compileBinaryOp(exp *ast.BinaryOperatorExpression) error {
 		c.emit(bytecode.OpMul)
 	case lexer.TokenDiv:
 		c.emit(bytecode.OpDiv)
+	case lexer.TokenIntDiv:
+		c.emit(bytecode.OpIDiv)
 	case lexer.TokenMod:
 		c.emit(bytecode.OpMod)
 	case lexer.TokenPower:
 		c.emit(bytecode.OpPow)
+	case lexer.TokenBinAnd:
+		c.emit(bytecode.OpBAnd)
+	case lexer.TokenBinOr:
+		c.emit(bytecode.OpBOr)
+	case lexer.TokenTilde:
+		c.emit(bytecode.OpBXor)
+	case lexer.TokenShiftLeft:
+		c.emit(bytecode.OpShl)
+	case lexer.TokenShiftRight:
+		c.emit(bytecode.OpShr)
 	case lexer.TokenEqual:
 		c.emit(bytecode.OpEq)
 	case lexer.TokenLess:
@@ -223,9 +445,25 @@ func (c *Compiler) compileBinaryOp(exp *ast.BinaryOperatorExpression) error {
 	case lexer.TokenMoreEqual:
 		c.emit(bytecode.OpGe)
 	default:
-		return fmt.Errorf("unsupported binary operator: %s", exp.Operator.Type)
+		return c.errorf(exp, "unsupported binary operator: %s", exp.Operator.Type)
+	}
+	c.setVarInfo(exp.Left, exp.Right)
+
+	return nil
+}
+
+// compileLogicalOp compiles the right operand of and/or, the left one is on the stack.
+// It is the result when the left one is falsy for and, truthy for or (jumpIf).
+func (c *Compiler) compileLogicalOp(exp *ast.BinaryOperatorExpression, jumpIf bool) error {
+	jumpPos := len(c.bytecode.Code)
+	c.emit(bytecode.OpTestSet, 0, jumpIf) // Placeholder jump offset
+
+	err := c.compileExpression(exp.Right)
+	if err != nil {
+		return err
 	}
 
+	c.bytecode.Code[jumpPos].Args[0] = len(c.bytecode.Code) - jumpPos - 1
 	return nil
 }
 
@@ -240,68 +478,94 @@ func (c *Compiler) compileUnaryOp(exp *ast.UnaryOperatorExpression) error {
 		c.emit(bytecode.OpUnm)
 	case lexer.TokenHash:
 		c.emit(bytecode.OpLen)
-	case lexer.TokenNot:
+	case lexer.TokenTilde:
+		c.emit(bytecode.OpBNot)
+	case lexer.TokenKeywordNot:
 		c.emit(bytecode.OpNot)
 	default:
-		return fmt.Errorf("unsupported unary operator: %s", exp.Operator.Type)
+		return c.errorf(exp, "unsupported unary operator: %s", exp.Operator.Type)
 	}
+	c.setVarInfo(exp.Expression)
 
 	return nil
 }
 
 func (c *Compiler) compileReturn(ret *ast.ReturnStatement) error {
-	if ret == nil {
-		c.emit(bytecode.OpPushNil)
-		c.emit(bytecode.OpReturn)
-		return nil
-	}
-
-	for _, exp := range ret.Expressions {
-		err := c.compileExpression(exp)
-		if err != nil {
-			return err
+	// return f(args) is a tail call, unless variables are to be closed after the call
+	if len(ret.Expressions) == 1 && c.tbcCount == 0 {
+		if call, ok := ret.Expressions[0].(*ast.FunctionCall); ok {
+			if err := c.compileFunctionCall(call, bytecode.MultRet); err != nil {
+				return err
+			}
+			c.bytecode.Code[len(c.bytecode.Code)-1].Op = bytecode.OpTailCall
+			// returns the results of a call that does not replace the frame
+			c.emit(bytecode.OpReturn, 0, true)
+			return nil
 		}
 	}
-	c.emit(bytecode.OpReturn)
+	n, open, err := c.compileOpenExpressionList(ret.Expressions)
+	if err != nil {
+		return err
+	}
+	c.emit(bytecode.OpReturn, n, open)
 	return nil
 }
 
+// errorf returns a compilation error positioned at the node n.
+func (c *Compiler) errorf(n ast.Positioned, format string, args ...interface{}) error {
+	return ast.NewError(c.chunk, n.Position(), fmt.Errorf(format, args...))
+}
+
 func (c *Compiler) emit(op bytecode.OpCode, args ...interface{}) {
-	c.bytecode.Code = append(c.bytecode.Code, bytecode.Instruction{Op: op, Args: args})
+	c.bytecode.Code = append(c.bytecode.Code, bytecode.Instruction{Op: op, Args: args, Pos: c.pos})
+}
+
+// at makes n the current node for the emitted instructions
+// and returns the function that restores the previous one.
+func (c *Compiler) at(n interface{}) func() {
+	p, ok := n.(ast.Positioned)
+	if !ok {
+		return func() {}
+	}
+	prev := c.pos
+	c.pos = p.Position()
+	return func() {
+		c.pos = prev
+	}
 }
 
 func (c *Compiler) enterScope() {
 	c.scopeLevel++
+	c.outerLocals = append(c.outerLocals, maps.Clone(c.locals))
+	c.outerTBCCounts = append(c.outerTBCCounts, c.tbcCount)
+	c.outerLabelCounts = append(c.outerLabelCounts, len(c.labels))
+	c.outerGotoCounts = append(c.outerGotoCounts, len(c.gotos))
 }
 
+// exitScope restores the locals and labels of the enclosing scope
+// and closes the to-be-closed variables of the scope. The pending gotos
+// of the scope are left to the enclosing one.
 func (c *Compiler) exitScope() {
-	for name, idx := range c.locals {
-		if idx >= len(c.bytecode.LocalVars)-c.scopeLevel {
-			delete(c.locals, name)
-		}
-	}
+	c.labels = c.labels[:c.outerLabelCounts[len(c.outerLabelCounts)-1]]
+	c.outerLabelCounts = c.outerLabelCounts[:len(c.outerLabelCounts)-1]
+	c.outerGotoCounts = c.outerGotoCounts[:len(c.outerGotoCounts)-1]
+	c.locals = c.outerLocals[len(c.outerLocals)-1]
+	c.outerLocals = c.outerLocals[:len(c.outerLocals)-1]
+	if outer := c.outerTBCCounts[len(c.outerTBCCounts)-1]; c.tbcCount > outer {
+		c.emit(bytecode.OpClose, outer)
+		c.tbcCount = outer
+	}
+	c.outerTBCCounts = c.outerTBCCounts[:len(c.outerTBCCounts)-1]
 	c.scopeLevel--
 }
 
 func (c *Compiler) compileAssignment(assign *ast.Assignment) error {
-	for _, exp := range assign.Exps {
-		err := c.compileExpression(exp)
-		if err != nil {
-			return err
-		}
-	}
-
-	for i := len(assign.Vars) - 1; i >= 0; i-- {
-		v := assign.Vars[i]
+	// the tables and keys of the targets are evaluated first, left to right
+	nOperands := 0
+	for _, v := range assign.Vars {
 		switch vr := v.(type) {
-		case *ast.NameVar:
-			if idx, ok := c.locals[vr.Name]; ok {
-				c.emit(bytecode.OpSetLocal, idx)
-			} else {
-				c.emit(bytecode.OpSetGlobal, vr.Name)
-			}
 		case *ast.IndexedVar:
-			err := c.compileExpression(vr.PrefixExp)
+			err := c.compilePrefixExp(vr.PrefixExp)
 			if err != nil {
 				return err
 			}
@@ -309,53 +573,90 @@ func (c *Compiler) compileAssignment(assign *ast.Assignment) error {
 			if err != nil {
 				return err
 			}
-			c.emit(bytecode.OpSetTable)
+			nOperands += 2
 		case *ast.MemberVar:
-			err := c.compileExpression(vr.PrefixExp)
+			err := c.compilePrefixExp(vr.PrefixExp)
 			if err != nil {
 				return err
 			}
 			c.emit(bytecode.OpPushString, vr.Name)
-			c.emit(bytecode.OpSetTable)
+			nOperands += 2
+		}
+	}
+
+	err := c.compileExpressionList(assign.Exps, len(assign.Vars))
+	if err != nil {
+		return err
+	}
+
+	// the values are assigned from the last one, which is on top of the stack;
+	// above the key of a target are the values and the operands of the targets after it
+	operandsAfter := 0
+	for i := len(assign.Vars) - 1; i >= 0; i-- {
+		switch vr := assign.Vars[i].(type) {
+		case *ast.NameVar:
+			c.compileSetName(vr.Name)
+		case *ast.IndexedVar:
+			c.emit(bytecode.OpSetTable, i+operandsAfter)
+			c.setVarInfo(vr.PrefixExp)
+			operandsAfter += 2
+		case *ast.MemberVar:
+			c.emit(bytecode.OpSetTable, i+operandsAfter)
+			c.setVarInfo(vr.PrefixExp)
+			operandsAfter += 2
+		default:
+			return c.errorf(assign, "unsupported assignment target: %T", vr)
 		}
 	}
+	if nOperands > 0 {
+		c.emit(bytecode.OpPop, nOperands)
+	}
 
 	return nil
 }
 
-func (c *Compiler) compileFunctionCall(call *ast.FunctionCall) error {
+// compileFunctionCall compiles a call that leaves nResults values on the stack.
+func (c *Compiler) compileFunctionCall(call *ast.FunctionCall, nResults int) error {
+	defer c.at(call)()
 	err := c.compilePrefixExp(call.PrefixExp)
 	if err != nil {
 		return err
 	}
 
+	nArgs := 0
 	if call.Name != "" {
-		c.emit(bytecode.OpPushString, call.Name)
-		c.emit(bytecode.OpGetTable)
+		// the object is passed as self
+		c.emit(bytecode.OpSelf, call.Name)
+		c.setVarInfo(call.PrefixExp)
+		nArgs = 1
 	}
 
-	nArgs := 0
+	open := false
 	switch args := call.Args.(type) {
 	case []ast.Expression:
-		for _, arg := range args {
-			err := c.compileExpression(arg)
-			if err != nil {
-				return err
-			}
-			nArgs++
+		n, isOpen, err := c.compileOpenExpressionList(args)
+		if err != nil {
+			return err
 		}
+		nArgs += n
+		open = isOpen
 	case *ast.TableConstructorExpression:
 		err := c.compileExpression(args)
 		if err != nil {
 			return err
 		}
-		nArgs = 1
+		nArgs++
 	case *ast.LiteralString:
 		c.emit(bytecode.OpPushString, args.Value)
-		nArgs = 1
+		nArgs++
 	}
 
-	c.emit(bytecode.OpCall, nArgs)
+	c.emit(bytecode.OpCall, nArgs, nResults, open, call.Callee())
+	if call.Name != "" {
+		c.bytecode.Code[len(c.bytecode.Code)-1].VarInfo = []string{fmt.Sprintf("method '%s'", call.Name)}
+	} else {
+		c.setVarInfo(call.PrefixExp)
+	}
 	return nil
 }
 
@@ -365,15 +666,15 @@ func (c *Compiler) compileDoBlock(do *ast.Do) error {
 
 func (c *Compiler) compileWhile(while *ast.While) error {
 	conditionPos := len(c.bytecode.Code)
+	c.enterLoop()
 
 	err := c.compileExpression(while.Exp)
 	if err != nil {
 		return err
 	}
 
-	c.emit(bytecode.OpTest)
 	jumpPos := len(c.bytecode.Code)
-	c.emit(bytecode.OpJmp, 0) // Placeholder jump offset
+	c.emit(bytecode.OpTest, 0) // Placeholder jump offset
 
 	err = c.compileBlock(&while.Block)
 	if err != nil {
@@ -383,10 +684,73 @@ func (c *Compiler) compileWhile(while *ast.While) error {
 	c.emit(bytecode.OpJmp, conditionPos-len(c.bytecode.Code)-1)
 
 	c.bytecode.Code[jumpPos].Args[0] = len(c.bytecode.Code) - jumpPos - 1
+	c.exitLoop()
+
+	return nil
+}
+
+// enterLoop starts collecting the break statements of a loop body.
+func (c *Compiler) enterLoop() {
+	c.loops = append(c.loops, &loop{tbcCount: c.tbcCount})
+}
 
+// exitLoop makes the break statements of the loop jump to the current position.
+func (c *Compiler) exitLoop() {
+	l := c.loops[len(c.loops)-1]
+	for _, pos := range l.breaks {
+		c.bytecode.Code[pos].Args[0] = len(c.bytecode.Code) - pos - 1
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+}
+
+func (c *Compiler) compileBreak(b *ast.Break) error {
+	if len(c.loops) == 0 {
+		return c.errorf(b, "break outside a loop")
+	}
+	l := c.loops[len(c.loops)-1]
+	if c.tbcCount > l.tbcCount {
+		c.emit(bytecode.OpClose, l.tbcCount)
+	}
+	l.breaks = append(l.breaks, len(c.bytecode.Code))
+	c.emit(bytecode.OpJmp, 0) // Placeholder jump offset
 	return nil
 }
 
+// compileGoto jumps to a label, closing the to-be-closed variables the jump
+// leaves. The parser has checked that the label is visible.
+func (c *Compiler) compileGoto(g *ast.Goto) {
+	for i := len(c.labels) - 1; i >= 0; i-- {
+		if l := c.labels[i]; l.name == g.Name {
+			if c.tbcCount > l.tbcCount {
+				c.emit(bytecode.OpClose, l.tbcCount)
+			}
+			c.emit(bytecode.OpJmp, l.pc-len(c.bytecode.Code)-1)
+			return
+		}
+	}
+	c.gotos = append(c.gotos, pendingGoto{name: g.Name, pos: len(c.bytecode.Code)})
+	c.emit(bytecode.OpClose, c.tbcCount) // Placeholder level
+	c.emit(bytecode.OpJmp, 0)            // Placeholder jump offset
+}
+
+// compileLabel makes a label visible and patches the pending gotos
+// of the current scope that jump to it.
+func (c *Compiler) compileLabel(l *ast.Label) {
+	target := label{name: l.Name, pc: len(c.bytecode.Code), tbcCount: c.tbcCount}
+	c.labels = append(c.labels, target)
+	first := c.outerGotoCounts[len(c.outerGotoCounts)-1]
+	pending := c.gotos[:first]
+	for _, g := range c.gotos[first:] {
+		if g.name != l.Name {
+			pending = append(pending, g)
+			continue
+		}
+		c.bytecode.Code[g.pos].Args[0] = target.tbcCount
+		c.bytecode.Code[g.pos+1].Args[0] = target.pc - g.pos - 2
+	}
+	c.gotos = pending
+}
+
 func (c *Compiler) compileIf(ifStmt *ast.If) error {
 	endJumps := make([]int, 0)
 
@@ -396,16 +760,15 @@ func (c *Compiler) compileIf(ifStmt *ast.If) error {
 			return err
 		}
 
-		c.emit(bytecode.OpTest)
 		jumpPos := len(c.bytecode.Code)
-		c.emit(bytecode.OpJmp, 0)
+		c.emit(bytecode.OpTest, 0) // Placeholder jump offset
 
 		err = c.compileBlock(&ifStmt.Blocks[i])
 		if err != nil {
 			return err
 		}
 
-		if i < len(ifStmt.Exps)-1 {
+		if i < len(ifStmt.Blocks)-1 {
 			endJumps = append(endJumps, len(c.bytecode.Code))
 			c.emit(bytecode.OpJmp, 0) // Placeholder jump offset
 		}
@@ -427,7 +790,11 @@ func (c *Compiler) compileIf(ifStmt *ast.If) error {
 	return nil
 }
 
+// compileForNum compiles the numeric for. OpForPrep keeps the control
+// of the loop in a hidden local, and OpForLoop sets the control variable
+// to the next value or leaves the loop.
 func (c *Compiler) compileForNum(forStmt *ast.For) error {
+	c.enterScope()
 	err := c.compileExpression(forStmt.Init)
 	if err != nil {
 		return err
@@ -445,59 +812,95 @@ func (c *Compiler) compileForNum(forStmt *ast.For) error {
 			return err
 		}
 	} else {
-		c.emit(bytecode.OpPushNumber, float64(1))
+		c.emit(bytecode.OpPushNumber, int64(1))
 	}
 
-	loopStartPos := len(c.bytecode.Code)
-	c.emit(bytecode.OpForPrep, 0) // Placeholder jump offset
+	state := c.declareLocal("(for state)")
+	c.emit(bytecode.OpForPrep, state)
 
-	c.locals[forStmt.Name] = len(c.locals)
-	c.bytecode.LocalVars = append(c.bytecode.LocalVars, forStmt.Name)
+	loopStart := len(c.bytecode.Code)
+	c.enterLoop()
+	c.enterScope()
+	c.emit(bytecode.OpForLoop, state, c.declareLocal(forStmt.Name), 0) // Placeholder jump offset
 
 	err = c.compileBlock(&forStmt.Block)
 	if err != nil {
 		return err
 	}
+	c.exitScope()
+	c.emit(bytecode.OpJmp, loopStart-len(c.bytecode.Code)-1)
 
-	c.emit(bytecode.OpForLoop, loopStartPos-len(c.bytecode.Code)-1)
-
-	c.bytecode.Code[loopStartPos].Args[0] = len(c.bytecode.Code) - loopStartPos - 1
+	c.bytecode.Code[loopStart].Args[2] = len(c.bytecode.Code) - loopStart - 1
+	c.exitLoop()
+	c.exitScope()
 
 	return nil
 }
 
+// compileForIn compiles the generic for. Its expressions are kept in four
+// hidden locals: the iterator function, the state, the control variable
+// and the closing value, which is closed when the loop ends.
 func (c *Compiler) compileForIn(forStmt *ast.ForIn) error {
-	// TODO: Implement generic for loop
-	return errors.New("generic for loop not implemented yet")
-}
-
-func (c *Compiler) compileLocalFunction(fn *ast.LocalFunction) error {
-	function := &bytecode.Function{
-		NumParams: len(fn.FunctionBody.ParameterList.Names),
-		IsVararg:  fn.FunctionBody.ParameterList.IsVarArg,
+	c.enterScope()
+	err := c.compileExpressionList(forStmt.Exps, 4)
+	if err != nil {
+		return err
+	}
+	var hidden [4]int
+	for i := range hidden {
+		hidden[i] = c.declareLocal("(for state)")
+	}
+	for i := len(hidden) - 1; i >= 0; i-- {
+		c.emit(bytecode.OpSetLocal, hidden[i])
 	}
+	c.emit(bytecode.OpToBeClosed, hidden[3])
+	c.tbcCount++
 
-	funcCompiler := New()
+	loopStart := len(c.bytecode.Code)
+	c.enterLoop()
+	c.emit(bytecode.OpGetLocal, hidden[0])
+	c.emit(bytecode.OpGetLocal, hidden[1])
+	c.emit(bytecode.OpGetLocal, hidden[2])
+	c.emit(bytecode.OpCall, 2, len(forStmt.Names), false, "for iterator 'for iterator'")
 
-	for _, param := range fn.FunctionBody.ParameterList.Names {
-		funcCompiler.locals[param] = len(funcCompiler.locals)
-		funcCompiler.bytecode.LocalVars = append(funcCompiler.bytecode.LocalVars, param)
-	}
+	c.enterScope()
+	slots := make([]int, len(forStmt.Names))
+	for i, name := range forStmt.Names {
+		slots[i] = c.declareLocal(name)
+	}
+	for i := len(slots) - 1; i >= 0; i-- {
+		c.emit(bytecode.OpSetLocal, slots[i])
+	}
+	// the loop ends when the first value is nil, otherwise it is the new control value
+	c.emit(bytecode.OpGetLocal, slots[0])
+	c.emit(bytecode.OpPushNil)
+	c.emit(bytecode.OpNeq)
+	exitJump := len(c.bytecode.Code)
+	c.emit(bytecode.OpTest, 0) // Placeholder jump offset
+	c.emit(bytecode.OpGetLocal, slots[0])
+	c.emit(bytecode.OpSetLocal, hidden[2])
 
-	if fn.FunctionBody.ParameterList.IsVarArg {
-		funcCompiler.locals["..."] = len(funcCompiler.locals)
-		funcCompiler.bytecode.LocalVars = append(funcCompiler.bytecode.LocalVars, "...")
+	err = c.compileBlock(&forStmt.Block)
+	if err != nil {
+		return err
 	}
+	c.exitScope()
+	c.emit(bytecode.OpJmp, loopStart-len(c.bytecode.Code)-1)
 
-	bc, err := funcCompiler.Compile(&fn.FunctionBody.Block)
+	c.bytecode.Code[exitJump].Args[0] = len(c.bytecode.Code) - exitJump - 1
+	c.exitLoop()
+	c.exitScope()
+
+	return nil
+}
+
+func (c *Compiler) compileLocalFunction(fn *ast.LocalFunction) error {
+	function, err := c.compileFunctionBody(&fn.FunctionBody, false)
 	if err != nil {
 		return err
 	}
 
-	function.Bytecode = bc
-
-	c.locals[fn.Name] = len(c.locals)
-	c.bytecode.LocalVars = append(c.bytecode.LocalVars, fn.Name)
+	c.declareLocal(fn.Name)
 
 	c.emit(bytecode.OpPushFunction, function)
 	c.emit(bytecode.OpSetLocal, c.locals[fn.Name])
@@ -506,31 +909,69 @@ func (c *Compiler) compileLocalFunction(fn *ast.LocalFunction) error {
 }
 
 func (c *Compiler) compileFunctionDefinition(fn *ast.FunctionDefinition) error {
-	function := &bytecode.Function{
-		NumParams: len(fn.FunctionBody.ParameterList.Names),
-		IsVararg:  fn.FunctionBody.ParameterList.IsVarArg,
+	function, err := c.compileFunctionBody(&fn.FunctionBody, false)
+	if err != nil {
+		return err
 	}
 
-	funcCompiler := New()
-
-	for _, param := range fn.FunctionBody.ParameterList.Names {
-		funcCompiler.locals[param] = len(funcCompiler.locals)
-		funcCompiler.bytecode.LocalVars = append(funcCompiler.bytecode.LocalVars, param)
-	}
+	c.emit(bytecode.OpPushFunction, function)
 
-	if fn.FunctionBody.ParameterList.IsVarArg {
-		funcCompiler.locals["..."] = len(funcCompiler.locals)
-		funcCompiler.bytecode.LocalVars = append(funcCompiler.bytecode.LocalVars, "...")
-	}
+	return nil
+}
 
-	bc, err := funcCompiler.Compile(&fn.FunctionBody.Block)
+// compileFunction compiles a function statement: function a.b.c:m() ... end
+func (c *Compiler) compileFunction(fn *ast.Function) error {
+	name := fn.FunctionName
+	function, err := c.compileFunctionBody(&fn.FuncBody, name.IsMethod)
 	if err != nil {
 		return err
 	}
 
-	function.Bytecode = bc
+	if len(name.PrefixNames) == 0 {
+		c.emit(bytecode.OpPushFunction, function)
+		c.compileSetName(name.Name)
+		return nil
+	}
 
+	// prefix is the variable the table is read from
+	var prefix ast.PrefixExpression = &ast.NameVar{Node: fn.Node, Name: name.PrefixNames[0]}
+	c.compileGetName(name.PrefixNames[0])
+	for _, field := range name.PrefixNames[1:] {
+		c.emit(bytecode.OpPushString, field)
+		c.emit(bytecode.OpGetTable)
+		c.setVarInfo(prefix)
+		prefix = &ast.MemberVar{Node: fn.Node, PrefixExp: prefix, Name: field}
+	}
+	c.emit(bytecode.OpPushString, name.Name)
 	c.emit(bytecode.OpPushFunction, function)
+	c.emit(bytecode.OpSetTable, 0)
+	c.setVarInfo(prefix)
+	c.emit(bytecode.OpPop, 2)
 
 	return nil
 }
+
+// compileFunctionBody compiles a function body with a new compiler;
+// a method gets self as its first parameter.
+func (c *Compiler) compileFunctionBody(body *ast.FunctionBody, isMethod bool) (*bytecode.Function, error) {
+	params := body.ParameterList.Names
+	if isMethod {
+		params = append([]string{"self"}, params...)
+	}
+
+	funcCompiler := New(c.chunk)
+	for _, param := range params {
+		funcCompiler.declareLocal(param)
+	}
+
+	bc, err := funcCompiler.Compile(&body.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bytecode.Function{
+		Bytecode:  bc,
+		NumParams: len(params),
+		IsVararg:  body.ParameterList.IsVarArg,
+	}, nil
+}
@@ -0,0 +1,279 @@
+// Package coroutine implements Lua threads for both engines.
+//
+// The function of a coroutine runs in its own goroutine, so that it can
+// yield from any depth of the recursive evaluation of the engines.
+// Control passes between the goroutines over channels: only one of them
+// runs at a time, and a goroutine ends when its coroutine returns, fails
+// or is closed.
+//
+// Scripts hold a Coroutine, while its goroutine only refers to the thread
+// inside it. A coroutine that is abandoned while suspended can then
+// be collected, and its goroutine is ended by closing the thread.
+package coroutine
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+var (
+	ErrYieldOutside = errors.New("attempt to yield from outside a coroutine")
+	ErrDead         = errors.New("cannot resume dead coroutine")
+	ErrNotSuspended = errors.New("cannot resume non-suspended coroutine")
+	ErrCloseRunning = errors.New("cannot close a running coroutine")
+	ErrCloseNormal  = errors.New("cannot close a normal coroutine")
+	ErrYieldClosing = errors.New("attempt to yield from a closing coroutine")
+	// ErrClosing unwinds a suspended coroutine that is being closed:
+	// it is returned by Yield and must not be caught by pcall.
+	ErrClosing = errors.New("coroutine is being closed")
+)
+
+// Status is the status of a coroutine, as coroutine.status reports it.
+type Status int
+
+const (
+	Suspended Status = iota
+	Running
+	// Normal is a coroutine that resumed another one
+	Normal
+	Dead
+)
+
+func (s Status) String() string {
+	switch s {
+	case Suspended:
+		return "suspended"
+	case Running:
+		return "running"
+	case Normal:
+		return "normal"
+	default:
+		return "dead"
+	}
+}
+
+// State holds the threads of an interpreter.
+type State struct {
+	// Main is the main thread, which cannot yield.
+	Main *Coroutine
+	// Switch, if set, is called when control passes from one thread
+	// to another, before the other one runs; engines use it to swap
+	// their per-thread state.
+	Switch func(from, to *Coroutine)
+
+	call    func(fn interface{}, args []interface{}) ([]interface{}, error)
+	current *Coroutine
+	// suspended holds the started threads that wait to be resumed; it
+	// refers to the threads and not to their coroutines, which stay
+	// collectable
+	suspended map[*thread]struct{}
+
+	// mu guards collected, which the finalizers of coroutines fill
+	mu        sync.Mutex
+	collected []*thread
+}
+
+// Coroutine is a Lua thread, as scripts hold it.
+type Coroutine struct {
+	*thread
+}
+
+// thread is the state of a coroutine. It must not refer to the Coroutine,
+// which would keep it from being collected while the thread is suspended.
+type thread struct {
+	// Data holds the per-thread state of the engine.
+	Data interface{}
+	// Depth is the number of nested calls running in the thread.
+	Depth int
+
+	state  *State
+	fn     interface{}
+	status Status
+	// in passes values to the goroutine of the coroutine, out passes them back
+	in, out chan transfer
+	// err is the error the coroutine died with, reported by Close
+	err error
+	// closing is set while the coroutine is unwound by Close
+	closing bool
+}
+
+// transfer is what passes between threads on a switch.
+type transfer struct {
+	vals []interface{}
+	err  error
+	// done is set when the function of the coroutine has returned
+	done bool
+}
+
+// NewState creates the threads of an interpreter; call calls a function
+// value of the engine and returns all its results.
+func NewState(call func(fn interface{}, args []interface{}) ([]interface{}, error)) *State {
+	s := &State{call: call, suspended: make(map[*thread]struct{})}
+	s.Main = &Coroutine{&thread{state: s, status: Running}}
+	s.current = s.Main
+	return s
+}
+
+// New creates a suspended coroutine that runs fn when it is first resumed.
+func (s *State) New(fn interface{}) *Coroutine {
+	s.closeCollected()
+	co := &Coroutine{&thread{state: s, fn: fn, status: Suspended}}
+	runtime.SetFinalizer(co, (*Coroutine).collect)
+	return co
+}
+
+// collect queues the thread of a collected coroutine to be closed.
+// It runs in the goroutine of the finalizers, so it leaves the closing
+// to the running thread.
+func (co *Coroutine) collect() {
+	s := co.state
+	s.mu.Lock()
+	s.collected = append(s.collected, co.thread)
+	s.mu.Unlock()
+}
+
+// closeCollected closes the suspended threads of the collected coroutines,
+// so that their goroutines end. Errors of the __close metamethods are
+// dropped, as nothing can see them.
+func (s *State) closeCollected() {
+	s.mu.Lock()
+	collected := s.collected
+	s.collected = nil
+	s.mu.Unlock()
+	for _, th := range collected {
+		if th.status == Suspended {
+			_ = (&Coroutine{th}).Close()
+		}
+	}
+}
+
+// Current returns the running thread.
+func (s *State) Current() *Coroutine {
+	return s.current
+}
+
+// Yield suspends the running coroutine, making vals the results of the
+// Resume that resumed it, and returns the arguments of the next Resume.
+// It returns ErrClosing when the coroutine is closed instead.
+func (s *State) Yield(vals []interface{}) ([]interface{}, error) {
+	if s.current == s.Main {
+		return nil, ErrYieldOutside
+	}
+	// only the thread is kept while suspended, so that the coroutine
+	// can be collected
+	th := s.current.thread
+	if th.closing {
+		return nil, ErrYieldClosing
+	}
+	th.out <- transfer{vals: vals}
+	t := <-th.in
+	return t.vals, t.err
+}
+
+// CloseAll closes the suspended coroutines except keep, so that their
+// goroutines end. Errors of the __close metamethods are dropped.
+func (s *State) CloseAll(keep *Coroutine) {
+	for th := range s.suspended {
+		if keep == nil || th != keep.thread {
+			_ = (&Coroutine{th}).Close()
+		}
+	}
+}
+
+// Status returns the status of the coroutine.
+func (co *Coroutine) Status() Status {
+	return co.status
+}
+
+// IsMain reports whether the coroutine is the main thread.
+func (co *Coroutine) IsMain() bool {
+	return co == co.state.Main
+}
+
+// Resume runs the coroutine until it yields or returns, passing args
+// to its function or as the results of the yield it is suspended in.
+// It returns the values yielded or returned, or the error that
+// killed the coroutine.
+func (co *Coroutine) Resume(args []interface{}) ([]interface{}, error) {
+	co.state.closeCollected()
+	switch co.status {
+	case Dead:
+		return nil, ErrDead
+	case Running, Normal:
+		return nil, ErrNotSuspended
+	}
+	t := co.transfer(transfer{vals: args})
+	if t.done {
+		co.err = t.err
+		return t.vals, t.err
+	}
+	return t.vals, nil
+}
+
+// Close closes a suspended or dead coroutine: the to-be-closed variables
+// of a suspended one are closed. It returns the error the coroutine died
+// with, or the error of a __close metamethod.
+func (co *Coroutine) Close() error {
+	switch co.status {
+	case Running:
+		return ErrCloseRunning
+	case Normal:
+		return ErrCloseNormal
+	case Dead:
+		err := co.err
+		co.err = nil
+		return err
+	}
+	if co.in == nil {
+		// never started
+		co.status = Dead
+		return nil
+	}
+	co.closing = true
+	t := co.transfer(transfer{err: ErrClosing})
+	if errors.Is(t.err, ErrClosing) {
+		return nil
+	}
+	return t.err
+}
+
+// transfer passes control to the suspended coroutine until it passes it back.
+func (co *Coroutine) transfer(t transfer) transfer {
+	s := co.state
+	prev := s.current
+	prev.status = Normal
+	co.status = Running
+	s.current = co
+	delete(s.suspended, co.thread)
+	if s.Switch != nil {
+		s.Switch(prev, co)
+	}
+
+	if co.in == nil {
+		co.in, co.out = make(chan transfer), make(chan transfer)
+		go co.thread.run(t.vals)
+	} else {
+		co.in <- t
+	}
+	t = <-co.out
+
+	if s.Switch != nil {
+		s.Switch(co, prev)
+	}
+	s.current = prev
+	prev.status = Running
+	if t.done {
+		co.status = Dead
+	} else {
+		co.status = Suspended
+		s.suspended[co.thread] = struct{}{}
+	}
+	return t
+}
+
+// run is the goroutine of the thread.
+func (th *thread) run(args []interface{}) {
+	vals, err := th.state.call(th.fn, args)
+	th.out <- transfer{vals: vals, err: err, done: true}
+}
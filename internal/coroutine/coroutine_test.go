@@ -0,0 +1,155 @@
+package coroutine_test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"lua-interpreter/internal/coroutine"
+)
+
+// goFunction is the function value of the test state.
+type goFunction func(args []interface{}) ([]interface{}, error)
+
+type CoroutineSuite struct {
+	suite.Suite
+	state *coroutine.State
+}
+
+func TestCoroutineSuite(t *testing.T) {
+	suite.Run(t, new(CoroutineSuite))
+}
+
+func (s *CoroutineSuite) SetupTest() {
+	s.state = coroutine.NewState(func(fn interface{}, args []interface{}) ([]interface{}, error) {
+		return fn.(goFunction)(args)
+	})
+}
+
+func (s *CoroutineSuite) TestResumeYield() {
+	co := s.state.New(goFunction(func(args []interface{}) ([]interface{}, error) {
+		s.Equal(coroutine.Running, s.state.Current().Status())
+		s.Equal(coroutine.Normal, s.state.Main.Status())
+		res, err := s.state.Yield([]interface{}{args[0].(int) + 1})
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{res[0].(int) * 2}, nil
+	}))
+	s.Equal(coroutine.Suspended, co.Status())
+
+	res, err := co.Resume([]interface{}{1})
+	s.NoError(err)
+	s.Equal([]interface{}{2}, res)
+	s.Equal(coroutine.Suspended, co.Status())
+	s.Same(s.state.Main, s.state.Current())
+	s.Equal(coroutine.Running, s.state.Main.Status())
+
+	res, err = co.Resume([]interface{}{10})
+	s.NoError(err)
+	s.Equal([]interface{}{20}, res)
+	s.Equal(coroutine.Dead, co.Status())
+
+	_, err = co.Resume(nil)
+	s.ErrorIs(err, coroutine.ErrDead)
+	_, err = s.state.Main.Resume(nil)
+	s.ErrorIs(err, coroutine.ErrNotSuspended)
+	_, err = s.state.Yield(nil)
+	s.ErrorIs(err, coroutine.ErrYieldOutside)
+}
+
+func (s *CoroutineSuite) TestSwitch() {
+	var switches []bool
+	s.state.Switch = func(from, to *coroutine.Coroutine) {
+		switches = append(switches, to.IsMain())
+	}
+	co := s.state.New(goFunction(func(args []interface{}) ([]interface{}, error) {
+		return s.state.Yield(nil)
+	}))
+	_, _ = co.Resume(nil)
+	_, _ = co.Resume(nil)
+	s.Equal([]bool{false, true, false, true}, switches)
+}
+
+func (s *CoroutineSuite) TestError() {
+	boom := errors.New("boom")
+	co := s.state.New(goFunction(func(args []interface{}) ([]interface{}, error) {
+		return nil, boom
+	}))
+	_, err := co.Resume(nil)
+	s.ErrorIs(err, boom)
+	s.Equal(coroutine.Dead, co.Status())
+	s.ErrorIs(co.Close(), boom)
+	s.NoError(co.Close())
+}
+
+func (s *CoroutineSuite) TestClose() {
+	var yieldErr, closeYieldErr error
+	co := s.state.New(goFunction(func(args []interface{}) ([]interface{}, error) {
+		_, yieldErr = s.state.Yield(nil)
+		// the unwinding coroutine cannot yield again
+		_, closeYieldErr = s.state.Yield(nil)
+		return nil, yieldErr
+	}))
+	_, _ = co.Resume(nil)
+	s.NoError(co.Close())
+	s.ErrorIs(yieldErr, coroutine.ErrClosing)
+	s.ErrorIs(closeYieldErr, coroutine.ErrYieldClosing)
+	s.Equal(coroutine.Dead, co.Status())
+
+	// a coroutine that was never resumed does not run
+	fresh := s.state.New(goFunction(func(args []interface{}) ([]interface{}, error) {
+		s.Fail("fresh coroutine ran")
+		return nil, nil
+	}))
+	s.NoError(fresh.Close())
+	s.Equal(coroutine.Dead, fresh.Status())
+
+	s.ErrorIs(s.state.Main.Close(), coroutine.ErrCloseRunning)
+}
+
+func (s *CoroutineSuite) TestCloseAll() {
+	closed := 0
+	newCo := func() *coroutine.Coroutine {
+		co := s.state.New(goFunction(func(args []interface{}) ([]interface{}, error) {
+			_, err := s.state.Yield(nil)
+			closed++
+			return nil, err
+		}))
+		_, _ = co.Resume(nil)
+		return co
+	}
+	a, b, keep := newCo(), newCo(), newCo()
+	s.state.CloseAll(keep)
+	s.Equal(2, closed)
+	s.Equal(coroutine.Dead, a.Status())
+	s.Equal(coroutine.Dead, b.Status())
+	s.Equal(coroutine.Suspended, keep.Status())
+}
+
+func (s *CoroutineSuite) TestCollect() {
+	before := runtime.NumGoroutine()
+	closed := 0
+	for i := 0; i < 100; i++ {
+		co := s.state.New(goFunction(func(args []interface{}) ([]interface{}, error) {
+			_, err := s.state.Yield(nil)
+			closed++
+			return nil, err
+		}))
+		_, _ = co.Resume(nil)
+	}
+	s.GreaterOrEqual(runtime.NumGoroutine(), before+100)
+
+	// the abandoned coroutines are closed by the next one created
+	// once they are collected
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+		s.state.New(nil)
+	}
+	s.Equal(100, closed)
+	s.LessOrEqual(runtime.NumGoroutine(), before)
+}
@@ -1,50 +1,94 @@
 package interpreter
 
 import (
-	"fmt"
-
 	"lua-interpreter/internal/ast"
+	"lua-interpreter/internal/bytecode"
+	"lua-interpreter/internal/compiler"
+	"lua-interpreter/internal/coroutine"
 	"lua-interpreter/internal/lexer"
+	"lua-interpreter/internal/meta"
 	"lua-interpreter/internal/parser"
+	"lua-interpreter/internal/stdlib"
+	"lua-interpreter/internal/vm"
 )
 
+// LuaError is the error returned for errors raised while a script runs.
+// It carries the Lua error value and a traceback.
+type LuaError = meta.LuaError
+
+// MaxCallDepth limits the number of nested calls in the scripts run
+// by the Eval functions; a deeper call raises a "stack overflow" error.
+var MaxCallDepth = meta.DefaultMaxCallDepth
+
+// Eval evaluates a script, naming the chunk after its source
+// like Lua does for strings: [string "..."].
 func Eval(script string) (ast.Value, error) {
-	l := lexer.NewLexer(script)
-	p := parser.New(l)
+	return EvalChunk(script, stdlib.ChunkID(script))
+}
 
-	block, err := p.Parse()
+// EvalChunk evaluates a script with the given chunk name,
+// which is used as a prefix for error messages.
+func EvalChunk(script string, chunk string) (ast.Value, error) {
+	block, err := parse(script, chunk)
 	if err != nil {
-		return nil, fmt.Errorf("error during parsing: %w", err)
+		return nil, err
 	}
 
-	val, err := block.Eval(ast.NewRootContext())
+	ctx := ast.NewRootContext(chunk)
+	ctx.SetParser(parse)
+	ctx.SetMaxCallDepth(MaxCallDepth)
+	val, err := block.Eval(ctx)
+	closeThreads(ctx.Threads(), val)
 	if err != nil {
-		return nil, fmt.Errorf("error during evaluation: %w", err)
+		return nil, mainChunkError(err)
 	}
 	return val, nil
 }
 
-//
-//func EvalWithStackMachine(script string) (ast.Value, error) {
-//	l := lexer.NewLexer(script)
-//	p := parser.New(l)
-//
-//	block, err := p.Parse()
-//	if err != nil {
-//		return nil, fmt.Errorf("error during parsing: %w", err)
-//	}
-//
-//	c := compiler.New()
-//	bc, err := c.Compile(&block)
-//	if err != nil {
-//		return nil, fmt.Errorf("error during compilation: %w", err)
-//	}
-//
-//	stackMachine := vm.NewVM(bc)
-//	result, err := stackMachine.Run()
-//	if err != nil {
-//		return nil, fmt.Errorf("error during execution: %w", err)
-//	}
-//
-//	return result, nil
-//}
+// parse parses the source code of a chunk.
+func parse(script, chunk string) (ast.Block, error) {
+	return parser.New(lexer.NewLexer(script), chunk).Parse()
+}
+
+// compile compiles the source code of a chunk to bytecode.
+func compile(script, chunk string) (bytecode.Bytecode, error) {
+	block, err := parse(script, chunk)
+	if err != nil {
+		return bytecode.Bytecode{}, err
+	}
+	return compiler.New(chunk).Compile(&block)
+}
+
+// closeThreads ends the goroutines of the coroutines left suspended
+// by a script, except the one it returns, which the host may resume.
+func closeThreads(threads *coroutine.State, result interface{}) {
+	keep, _ := result.(*coroutine.Coroutine)
+	threads.CloseAll(keep)
+}
+
+// mainChunkError converts an error that leaves a script to a Lua error,
+// adding the main chunk to its traceback.
+func mainChunkError(err error) *LuaError {
+	luaErr := meta.NewLuaError(err)
+	luaErr.PassCall("[C]", "main chunk")
+	return luaErr
+}
+
+// EvalWithStackMachine compiles a script to bytecode and runs it on the VM.
+func EvalWithStackMachine(script string) (ast.Value, error) {
+	chunk := stdlib.ChunkID(script)
+	bc, err := compile(script, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := vm.NewVM(bc)
+	machine.SetCompiler(compile)
+	machine.SetMaxCallDepth(MaxCallDepth)
+	val, err := machine.Run()
+	closeThreads(machine.Threads(), val)
+	if err != nil {
+		return nil, mainChunkError(err)
+	}
+	return val, nil
+}
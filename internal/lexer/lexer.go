@@ -2,7 +2,6 @@ package lexer
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 	"unicode"
 )
@@ -46,7 +45,6 @@ const (
 	TokenDot
 	TokenDoubleDot
 	TokenTripleDot
-	TokenNot
 	// Keywords
 	TokenKeywordAnd
 	TokenKeywordBreak
@@ -82,7 +80,6 @@ const (
 const (
 	IdentifierStartSymbols = "_abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	IdentifierSymbols      = "_abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	HexNumberSymbols       = ".0123456789abcdefABCDEF"
 )
 
 // for debugging
@@ -247,20 +244,38 @@ type (
 		HasNext() bool
 		NextToken() Token
 	}
+	// Position is the location of a token in the source.
+	// Line and Column are 1-based, Offset is a 0-based byte offset.
+	Position struct {
+		Line   int
+		Column int
+		Offset int
+	}
 	Token struct {
 		Type  TokenType
 		Value string
+		Pos   Position
+		// Float tells float numerals from integer ones
+		Float bool
 	}
 	Lexer struct {
 		input    string
 		position int
+		// line number and offset of the first byte of the current line
+		line      int
+		lineStart int
 	}
 )
 
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 func NewLexer(input string) *Lexer {
 	return &Lexer{
 		input:    input,
 		position: 0,
+		line:     1,
 	}
 }
 
@@ -276,15 +291,35 @@ func (l *Lexer) NextToken() Token {
 	return t
 }
 
+// advance moves the lexer to the offset i, keeping track of line starts.
+func (l *Lexer) advance(i int) {
+	for j := l.position; j < i && j < len(l.input); j++ {
+		if l.input[j] == '\n' {
+			l.line++
+			l.lineStart = j + 1
+		}
+	}
+	l.position = i
+}
+
+func (l *Lexer) currentPosition() Position {
+	return Position{
+		Line:   l.line,
+		Column: l.position - l.lineStart + 1,
+		Offset: l.position,
+	}
+}
+
 func (l *Lexer) nextToken() Token {
 	var token Token
+	pos := l.currentPosition()
 	input := l.input
 	i := l.position
 	if i < len(input) {
 		ch := input[i]
 		switch {
 		case unicode.IsSpace(rune(ch)):
-			token = Token{TokenSpace, string(ch)}
+			token = Token{Type: TokenSpace, Value: string(ch)}
 			i++
 		case strings.ContainsRune(IdentifierStartSymbols, rune(ch)):
 			start := i
@@ -293,280 +328,171 @@ func (l *Lexer) nextToken() Token {
 			}
 			word := input[start:i]
 			if _, ok := keywords[word]; ok {
-				token = Token{keywords[word], word}
+				token = Token{Type: keywords[word], Value: word}
 			} else {
-				token = Token{TokenIdentifier, word}
-			}
-		case unicode.IsDigit(rune(ch)):
-			start := i
-			isHex := false
-			isFloat := false
-			isFailed := false
-			if i+1 < len(input) && input[i] == '0' && input[i+1] == 'x' {
-				isHex = true
-				i += 2
-			}
-			for i < len(input) {
-				if input[i] == '.' {
-					if isFloat {
-						isFailed = true
-						break
-					}
-					isFloat = true
-					i++
-					continue
-				}
-				if isHex && strings.ContainsRune(HexNumberSymbols, rune(input[i])) {
-					i++
-				} else if unicode.IsDigit(rune(input[i])) {
-					i++
-				} else {
-					break
-				}
-			}
-			if isFailed {
-				token = Token{TokenError, input[start:i]}
-			} else {
-				token = Token{TokenNumeral, input[start:i]}
-			}
-		case ch == '"':
-			raw := input[i : i+1]
-			start := i
-			i++
-			for i < len(input) && input[i] != '"' {
-				if input[i] == '\n' {
-					token = Token{TokenError, fmt.Sprintf("Unterminated string literal: %s", input[start:i])}
-					break
-				}
-				if input[i] == '\\' && i+1 < len(input) && input[i+1] == '\'' {
-					i++
-				}
-				if input[i] == '\\' && i+1 < len(input) && input[i+1] == '"' {
-					raw += input[i : i+1]
-					i++
-				}
-				raw += input[i : i+1]
-				i++
-			}
-			if i < len(input) {
-				raw += input[i : i+1]
-				i++
-				parsed, err := strconv.Unquote(raw)
-				if err != nil {
-					token = Token{TokenError, fmt.Sprintf("Error unquoting string %s: %s", raw, err.Error())}
-				} else {
-					token = Token{TokenLiteralString, parsed}
-				}
-			} else {
-				token = Token{TokenError, input[start:i]}
-			}
-		case ch == '\'':
-			raw := input[i : i+1]
-			start := i
-			i++
-			for i < len(input) && input[i] != '\'' {
-				if input[i] == '\n' {
-					token = Token{TokenError, fmt.Sprintf("Unterminated string literal: %s", input[start:i])}
-					break
-				}
-				if input[i] == '\\' {
-					if i+1 < len(input) && input[i+1] == '\'' {
-						i++
-					}
-				}
-				raw += input[i : i+1]
-				i++
-			}
-			if i < len(input) {
-				raw += input[i : i+1]
-				i++
-				converted := `"` + strings.Trim(raw, `'`) + `"`
-				parsed, err := strconv.Unquote(converted)
-				if err != nil {
-					token = Token{TokenError, fmt.Sprintf("Error unquoting string %s: %s", converted, err.Error())}
-				} else {
-					token = Token{TokenLiteralString, parsed}
-				}
-			} else {
-				token = Token{TokenError, input[start:i]}
+				token = Token{Type: TokenIdentifier, Value: word}
 			}
+		case isDigit(ch):
+			token, i = readNumeral(input, i)
+		case ch == '"' || ch == '\'':
+			token, i = readQuotedString(input, i)
 		case ch == '+':
-			token = Token{TokenPlus, string(ch)}
+			token = Token{Type: TokenPlus, Value: string(ch)}
 			i++
 		case ch == '-':
 			if i+1 < len(input) && input[i+1] == '-' {
 				start := i
-				success := false
-				if i+2 < len(input) && input[i+2] == '[' {
-					j := i + 3
-					equalCount := 0
-					for j < len(input) && input[j] == '=' {
-						equalCount++
-						j++
+				if level := longBracketLevel(input, i+2); level >= 0 {
+					_, end, ok := readLongBracket(input, i+2, level)
+					i = end
+					if ok {
+						token = Token{Type: TokenComment, Value: input[start:i]}
+					} else {
+						token = Token{Type: TokenError, Value: fmt.Sprintf("unfinished long comment near '%s'", input[start:i])}
 					}
-					if j < len(input) && input[j] == '[' {
-						success = false
-						i = j
-						for i < len(input) {
-							if input[i] == ']' {
-								count := 0
-								for i+1 < len(input) && input[i+1] == '=' {
-									count++
-									i++
-								}
-								if count == equalCount && i+1 < len(input) && input[i+1] == ']' {
-									i += 2
-									success = true
-									break
-								}
-							}
-							i++
-						}
-					}
-				}
-				if i >= len(input) && !success {
-					token = Token{TokenError, input[start:i]}
-				} else if i > start {
-					token = Token{TokenComment, input[start:i]}
 				} else {
-					for i+1 < len(input) && input[i+1] != '\n' {
-						i++
-					}
-					if i < len(input) {
+					for i < len(input) && input[i] != '\n' {
 						i++
 					}
-					token = Token{TokenComment, input[start:i]}
+					token = Token{Type: TokenComment, Value: input[start:i]}
 				}
 			} else {
-				token = Token{TokenMinus, string(ch)}
+				token = Token{Type: TokenMinus, Value: string(ch)}
 				i++
 			}
 		case ch == '*':
-			token = Token{TokenMult, string(ch)}
+			token = Token{Type: TokenMult, Value: string(ch)}
 			i++
 		case ch == '%':
-			token = Token{TokenMod, string(ch)}
+			token = Token{Type: TokenMod, Value: string(ch)}
 			i++
 		case ch == '^':
-			token = Token{TokenPower, string(ch)}
+			token = Token{Type: TokenPower, Value: string(ch)}
 			i++
 		case ch == '#':
-			token = Token{TokenHash, string(ch)}
+			token = Token{Type: TokenHash, Value: string(ch)}
 			i++
 		case ch == '&':
-			token = Token{TokenBinAnd, string(ch)}
+			token = Token{Type: TokenBinAnd, Value: string(ch)}
 			i++
 		case ch == '|':
-			token = Token{TokenBinOr, string(ch)}
+			token = Token{Type: TokenBinOr, Value: string(ch)}
 			i++
 		case ch == '/':
 			if i+1 < len(input) && input[i+1] == '/' {
-				token = Token{TokenIntDiv, "//"}
+				token = Token{Type: TokenIntDiv, Value: "//"}
 				i += 2
 			} else {
-				token = Token{TokenDiv, string(ch)}
+				token = Token{Type: TokenDiv, Value: string(ch)}
 				i++
 			}
 		case ch == '=':
 			if i+1 < len(input) && input[i+1] == '=' {
-				token = Token{TokenEqual, "=="}
+				token = Token{Type: TokenEqual, Value: "=="}
 				i += 2
 			} else {
-				token = Token{TokenAssign, string(ch)}
+				token = Token{Type: TokenAssign, Value: string(ch)}
 				i++
 			}
 		case ch == '<':
 			if i+1 < len(input) && input[i+1] == '=' {
-				token = Token{TokenLessEqual, "<="}
+				token = Token{Type: TokenLessEqual, Value: "<="}
 				i += 2
 			} else if i+1 < len(input) && input[i+1] == '<' {
-				token = Token{TokenShiftLeft, "<<"}
+				token = Token{Type: TokenShiftLeft, Value: "<<"}
 				i += 2
 				break
 			} else {
-				token = Token{TokenLess, string(ch)}
+				token = Token{Type: TokenLess, Value: string(ch)}
 				i++
 			}
 		case ch == '>':
 			if i+1 < len(input) && input[i+1] == '=' {
-				token = Token{TokenMoreEqual, ">="}
+				token = Token{Type: TokenMoreEqual, Value: ">="}
 				i += 2
 				break
 			} else if i+1 < len(input) && input[i+1] == '>' {
-				token = Token{TokenShiftRight, ">>"}
+				token = Token{Type: TokenShiftRight, Value: ">>"}
 				i += 2
 				break
 			} else {
-				token = Token{TokenMore, string(ch)}
+				token = Token{Type: TokenMore, Value: string(ch)}
 				i++
 			}
 		case ch == '~':
 			if i+1 < len(input) && input[i+1] == '=' {
-				token = Token{TokenNotEqual, "~="}
+				token = Token{Type: TokenNotEqual, Value: "~="}
 				i += 2
 			} else {
-				token = Token{TokenTilde, string(ch)}
+				token = Token{Type: TokenTilde, Value: string(ch)}
 				i++
 			}
 		case ch == ':':
 			if i+1 < len(input) && input[i+1] == ':' {
-				token = Token{TokenDoubleColon, "::"}
+				token = Token{Type: TokenDoubleColon, Value: "::"}
 				i += 2
 			} else {
-				token = Token{TokenColon, string(ch)}
+				token = Token{Type: TokenColon, Value: string(ch)}
 				i++
 			}
 		case ch == ';':
-			token = Token{TokenSemiColon, string(ch)}
+			token = Token{Type: TokenSemiColon, Value: string(ch)}
 			i++
 		case ch == ',':
-			token = Token{TokenComma, string(ch)}
+			token = Token{Type: TokenComma, Value: string(ch)}
 			i++
 		case ch == '.':
 			if i+1 < len(input) && input[i+1] == '.' {
 				if i+2 < len(input) && input[i+2] == '.' {
-					token = Token{TokenTripleDot, "..."}
+					token = Token{Type: TokenTripleDot, Value: "..."}
 					i += 3
 				} else {
-					token = Token{TokenDoubleDot, ".."}
+					token = Token{Type: TokenDoubleDot, Value: ".."}
 					i += 2
 				}
-			} else if i+1 < len(input) && unicode.IsDigit(rune(input[i+1])) {
-				start := i
-				i++
-				for i < len(input) && unicode.IsDigit(rune(input[i])) {
-					i++
-				}
-				token = Token{TokenNumeral, input[start:i]}
+			} else if i+1 < len(input) && isDigit(input[i+1]) {
+				token, i = readNumeral(input, i)
 			} else {
-				token = Token{TokenDot, string(ch)}
+				token = Token{Type: TokenDot, Value: string(ch)}
 				i++
 			}
 		case ch == '(':
-			token = Token{TokenLeftParen, string(ch)}
+			token = Token{Type: TokenLeftParen, Value: string(ch)}
 			i++
 		case ch == ')':
-			token = Token{TokenRightParen, string(ch)}
+			token = Token{Type: TokenRightParen, Value: string(ch)}
 			i++
 		case ch == '{':
-			token = Token{TokenLeftBrace, string(ch)}
+			token = Token{Type: TokenLeftBrace, Value: string(ch)}
 			i++
 		case ch == '}':
-			token = Token{TokenRightBrace, string(ch)}
+			token = Token{Type: TokenRightBrace, Value: string(ch)}
 			i++
 		case ch == '[':
-			token = Token{TokenLeftBracket, string(ch)}
-			i++
+			if level := longBracketLevel(input, i); level >= 0 {
+				start := i
+				str, end, ok := readLongBracket(input, i, level)
+				i = end
+				if ok {
+					token = Token{Type: TokenLiteralString, Value: str}
+				} else {
+					token = Token{Type: TokenError, Value: fmt.Sprintf("unfinished long string near '%s'", input[start:i])}
+				}
+			} else {
+				token = Token{Type: TokenLeftBracket, Value: string(ch)}
+				i++
+			}
 		case ch == ']':
-			token = Token{TokenRightBracket, string(ch)}
+			token = Token{Type: TokenRightBracket, Value: string(ch)}
 			i++
 		default:
-			token = Token{TokenError, string(ch)}
+			token = Token{Type: TokenError, Value: string(ch)}
 			i++
 		}
 	} else {
-		token = Token{TokenEOF, ""}
+		token = Token{Type: TokenEOF, Value: ""}
 	}
-	l.position = i
+	l.advance(i)
+	token.Pos = pos
 	return token
 }
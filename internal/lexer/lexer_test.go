@@ -78,8 +78,11 @@ func (s *LexerSuite) TestNextTokenSingleTokens() {
 		{"0x1A", []lexer.TokenType{lexer.TokenNumeral, lexer.TokenEOF}},
 		{"0x1A.2", []lexer.TokenType{lexer.TokenNumeral, lexer.TokenEOF}},
 		{"123.456", []lexer.TokenType{lexer.TokenNumeral, lexer.TokenEOF}},
-		{"1.2.3", []lexer.TokenType{lexer.TokenError, lexer.TokenNumeral, lexer.TokenEOF}},
-		{"1.2tr", []lexer.TokenType{lexer.TokenNumeral, lexer.TokenIdentifier, lexer.TokenEOF}},
+		{"1.2.3", []lexer.TokenType{lexer.TokenError, lexer.TokenEOF}},
+		{"1.2tr", []lexer.TokenType{lexer.TokenError, lexer.TokenIdentifier, lexer.TokenEOF}},
+		{"1..2", []lexer.TokenType{lexer.TokenError, lexer.TokenEOF}},
+		{"1 .. 2", []lexer.TokenType{lexer.TokenNumeral, lexer.TokenDoubleDot, lexer.TokenNumeral, lexer.TokenEOF}},
+		{"a.b", []lexer.TokenType{lexer.TokenIdentifier, lexer.TokenDot, lexer.TokenIdentifier, lexer.TokenEOF}},
 		{"[", []lexer.TokenType{lexer.TokenLeftBracket, lexer.TokenEOF}},
 		{"]", []lexer.TokenType{lexer.TokenRightBracket, lexer.TokenEOF}},
 		{"{", []lexer.TokenType{lexer.TokenLeftBrace, lexer.TokenEOF}},
@@ -143,3 +146,220 @@ func (s *LexerSuite) TestNextTokenSingleTokens() {
 		}
 	}
 }
+
+func (s *LexerSuite) TestNextTokenPositions() {
+	s.lexer = lexer.NewLexer("local a = 10\n-- comment\n  print(a)")
+	expected := []lexer.Position{
+		{Line: 1, Column: 1, Offset: 0},
+		{Line: 1, Column: 7, Offset: 6},
+		{Line: 1, Column: 9, Offset: 8},
+		{Line: 1, Column: 11, Offset: 10},
+		{Line: 3, Column: 3, Offset: 26},
+		{Line: 3, Column: 8, Offset: 31},
+		{Line: 3, Column: 9, Offset: 32},
+		{Line: 3, Column: 10, Offset: 33},
+		{Line: 3, Column: 11, Offset: 34},
+	}
+	for _, pos := range expected {
+		token := s.lexer.NextToken()
+		s.Equal(pos, token.Pos, "token: %q", token.Value)
+	}
+}
+
+func (s *LexerSuite) TestStringLiterals() {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"plain"`, "plain"},
+		{`'single "quoted"'`, `single "quoted"`},
+		{`"\a\b\f\n\r\t\v\\\"\'"`, "\a\b\f\n\r\t\v\\\"'"},
+		{`"\x41\x62\x7A"`, "Abz"},
+		{`"\65\066\0679"`, "ABC9"},
+		{`"\0"`, "\x00"},
+		{`"\u{48}\u{e9}\u{20AC}\u{1F600}"`, "Hé€\U0001F600"},
+		{`"\u{7FFFFFFF}"`, "\xfd\xbf\xbf\xbf\xbf\xbf"},
+		{"\"line\\\ncontinued\"", "line\ncontinued"},
+		{"\"crlf\\\r\ncontinued\"", "crlf\ncontinued"},
+		{"\"skip \\z   \n\t  spaces\"", "skip spaces"},
+		{"[[long string]]", "long string"},
+		{"[[\nfirst newline skipped]]", "first newline skipped"},
+		{"[[\r\nfirst newline skipped]]", "first newline skipped"},
+		{"[==[with ]] and ]=] inside]==]", "with ]] and ]=] inside"},
+		{"[=[\\n is not an escape]=]", "\\n is not an escape"},
+		{"[[a\r\nb\n\rc\rd]]", "a\nb\nc\nd"},
+	}
+
+	for _, test := range tests {
+		s.lexer = lexer.NewLexer(test.input)
+		token := s.lexer.NextToken()
+		s.Equal(lexer.TokenLiteralString, token.Type, "input: %q, value: %q", test.input, token.Value)
+		s.Equal(test.expected, token.Value, "input: %q", test.input)
+		s.Equal(lexer.TokenEOF, s.lexer.NextToken().Type, "input: %q", test.input)
+	}
+}
+
+func (s *LexerSuite) TestStringLiteralErrors() {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"abc`, `unfinished string near '"abc'`},
+		{"'abc\ndef'", `unfinished string near ''abc'`},
+		{`"\q"`, `invalid escape sequence near '"\q'`},
+		{`"\xg0"`, `hexadecimal digit expected near '"\xg'`},
+		{`"\256"`, `decimal escape too large near '"\256'`},
+		{`"\u{80000000}"`, `UTF-8 value too large near '"\u{80000000'`},
+		{`"\u48"`, `missing '{' in \u{xxxx} near '"\u4'`},
+		{`"\u{48"`, `missing '}' in \u{xxxx} near '"\u{48"'`},
+		{"[==[abc]=]", "unfinished long string near '[==[abc]=]'"},
+	}
+
+	for _, test := range tests {
+		s.lexer = lexer.NewLexer(test.input)
+		token := s.lexer.NextToken()
+		s.Equal(lexer.TokenError, token.Type, "input: %q", test.input)
+		s.Equal(test.expected, token.Value, "input: %q", test.input)
+	}
+}
+
+func (s *LexerSuite) TestLongBracketIsNotIndex() {
+	s.lexer = lexer.NewLexer("t[ [=[k]=] ]")
+	expected := []lexer.TokenType{
+		lexer.TokenIdentifier, lexer.TokenLeftBracket, lexer.TokenLiteralString, lexer.TokenRightBracket, lexer.TokenEOF,
+	}
+	for _, tokenType := range expected {
+		s.Equal(tokenType, s.lexer.NextToken().Type)
+	}
+}
+
+func (s *LexerSuite) TestNumerals() {
+	tests := []struct {
+		input string
+		float bool
+	}{
+		{"3", false},
+		{"345", false},
+		{"0xff", false},
+		{"0XBEBADA", false},
+		{"9223372036854775807", false},
+		{"9223372036854775808", true},
+		{"0xffffffffffffffffff", false},
+		{"3.0", true},
+		{"3.", true},
+		{".5", true},
+		{"3.1416", true},
+		{"314.16e-2", true},
+		{"0.31416E1", true},
+		{"34e1", true},
+		{"1E+10", true},
+		{"0x0.1E", true},
+		{"0xA23p-4", true},
+		{"0X1.921FB54442D18P+1", true},
+		{"0x.8", true},
+	}
+
+	for _, test := range tests {
+		s.lexer = lexer.NewLexer(test.input)
+		token := s.lexer.NextToken()
+		s.Equal(lexer.TokenNumeral, token.Type, "input: %q, value: %q", test.input, token.Value)
+		s.Equal(test.input, token.Value)
+		s.Equal(test.float, token.Float, "input: %q", test.input)
+		s.Equal(lexer.TokenEOF, s.lexer.NextToken().Type, "input: %q", test.input)
+	}
+}
+
+func (s *LexerSuite) TestMalformedNumerals() {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"3..2", "malformed number near '3..2'"},
+		{"0xg", "malformed number near '0xg'"},
+		{"0x", "malformed number near '0x'"},
+		{"1e", "malformed number near '1e'"},
+		{"1e+", "malformed number near '1e+'"},
+		{"0x1p", "malformed number near '0x1p'"},
+		{"3abc", "malformed number near '3abc'"},
+		{"12_000", "malformed number near '12_'"},
+		{"0x1.2.3", "malformed number near '0x1.2.3'"},
+	}
+
+	for _, test := range tests {
+		s.lexer = lexer.NewLexer(test.input)
+		token := s.lexer.NextToken()
+		s.Equal(lexer.TokenError, token.Type, "input: %q", test.input)
+		s.Equal(test.expected, token.Value)
+	}
+}
+
+func (s *LexerSuite) TestParseNumbers() {
+	integers := []struct {
+		input    string
+		expected int64
+	}{
+		{"10", 10},
+		{"-10", -10},
+		{"0x10", 16},
+		{"0xffffffffffffffff", -1},
+		{"0x7fffffffffffffff", 9223372036854775807},
+		{"-9223372036854775808", -9223372036854775808},
+	}
+	for _, test := range integers {
+		value, ok := lexer.ParseInteger(test.input)
+		s.True(ok, "input: %q", test.input)
+		s.Equal(test.expected, value, "input: %q", test.input)
+	}
+	for _, input := range []string{"", "-", "0x", "1.0", "9223372036854775808", "1e2", "0x1p1"} {
+		_, ok := lexer.ParseInteger(input)
+		s.False(ok, "input: %q", input)
+	}
+
+	floats := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5", 1.5},
+		{"-.5", -0.5},
+		{"1e2", 100},
+		{"0x10", 16},
+		{"0x.8", 0.5},
+		{"0xA23p-4", 162.1875},
+		{"9223372036854775808", 9223372036854775808},
+	}
+	for _, test := range floats {
+		value, ok := lexer.ParseFloat(test.input)
+		s.True(ok, "input: %q", test.input)
+		s.Equal(test.expected, value, "input: %q", test.input)
+	}
+	for _, input := range []string{"", ".", "inf", "nan", "1_0", "0x1_0", "1e", "0x", "1.5x"} {
+		_, ok := lexer.ParseFloat(input)
+		s.False(ok, "input: %q", input)
+	}
+}
+
+func (s *LexerSuite) TestStringToNumber() {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"10", int64(10)},
+		{"  -7\t\n", int64(-7)},
+		{"+3", int64(3)},
+		{"0x10", int64(16)},
+		{" 0xff ", int64(255)},
+		{"1.5", 1.5},
+		{"1e2", 100.0},
+		{"\v.5\f", 0.5},
+		{"9223372036854775808", 9223372036854775808.0},
+	}
+	for _, test := range tests {
+		value, ok := lexer.StringToNumber(test.input)
+		s.True(ok, "input: %q", test.input)
+		s.Equal(test.expected, value, "input: %q", test.input)
+	}
+	for _, input := range []string{"", " ", "abc", "10a", "1 0", "0x", "inf", "nan", "1e", " 10"} {
+		_, ok := lexer.StringToNumber(input)
+		s.False(ok, "input: %q", input)
+	}
+}
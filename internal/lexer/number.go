@@ -0,0 +1,156 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// readNumeral reads a numeral starting at i the way the Lua lexer does:
+// it greedily takes hex digits, dots and signed exponents and then checks
+// that the result is a well-formed integer or float.
+func readNumeral(input string, i int) (Token, int) {
+	start := i
+	exponent := "Ee"
+	if strings.HasPrefix(input[i:], "0x") || strings.HasPrefix(input[i:], "0X") {
+		exponent = "Pp"
+		i += 2
+	}
+	for i < len(input) {
+		ch := input[i]
+		if strings.IndexByte(exponent, ch) >= 0 {
+			i++
+			if i < len(input) && (input[i] == '+' || input[i] == '-') {
+				i++
+			}
+		} else if isHexDigit(ch) || ch == '.' {
+			i++
+		} else {
+			break
+		}
+	}
+	// a numeral touching a letter is malformed
+	if i < len(input) && strings.IndexByte(IdentifierStartSymbols, input[i]) >= 0 {
+		i++
+	}
+	numeral := input[start:i]
+	if _, ok := ParseInteger(numeral); ok {
+		return Token{Type: TokenNumeral, Value: numeral}, i
+	}
+	if _, ok := ParseFloat(numeral); ok {
+		return Token{Type: TokenNumeral, Value: numeral, Float: true}, i
+	}
+	return Token{Type: TokenError, Value: fmt.Sprintf("malformed number near '%s'", numeral)}, i
+}
+
+// ParseInteger converts a decimal or hexadecimal integer numeral.
+// Hexadecimal integers wrap around on overflow, decimal ones
+// that do not fit into int64 are rejected and should be read as floats.
+func ParseInteger(s string) (int64, bool) {
+	s, neg := trimSign(s)
+	var value uint64
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		for i := 2; i < len(s); i++ {
+			if !isHexDigit(s[i]) {
+				return 0, false
+			}
+			value = value*16 + uint64(hexValue(s[i]))
+		}
+	} else {
+		if s == "" {
+			return 0, false
+		}
+		for i := 0; i < len(s); i++ {
+			if !isDigit(s[i]) {
+				return 0, false
+			}
+			digit := uint64(s[i] - '0')
+			// the magnitude of math.MinInt64 is accepted only when negated
+			if value > (1<<63-digit)/10 || (!neg && value*10+digit == 1<<63) {
+				return 0, false
+			}
+			value = value*10 + digit
+		}
+	}
+	if neg {
+		value = -value
+	}
+	return int64(value), true
+}
+
+// ParseFloat converts a decimal or hexadecimal float numeral.
+// Unlike strconv.ParseFloat it accepts only the Lua syntax:
+// no inf, nan or underscores, and hexadecimal floats without an exponent.
+func ParseFloat(s string) (float64, bool) {
+	body, _ := trimSign(s)
+	hex := len(body) > 1 && body[0] == '0' && (body[1] == 'x' || body[1] == 'X')
+	if hex {
+		body = body[2:]
+	}
+	exponent, digit := "eE", isDigit
+	if hex {
+		exponent, digit = "pP", isHexDigit
+	}
+
+	i, digits := 0, 0
+	for i < len(body) && digit(body[i]) {
+		i, digits = i+1, digits+1
+	}
+	if i < len(body) && body[i] == '.' {
+		i++
+		for i < len(body) && digit(body[i]) {
+			i, digits = i+1, digits+1
+		}
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	hasExponent := i < len(body) && strings.IndexByte(exponent, body[i]) >= 0
+	if hasExponent {
+		i++
+		if i < len(body) && (body[i] == '+' || body[i] == '-') {
+			i++
+		}
+		if i == len(body) || !isDigit(body[i]) {
+			return 0, false
+		}
+		for i < len(body) && isDigit(body[i]) {
+			i++
+		}
+	}
+	if i != len(body) {
+		return 0, false
+	}
+
+	if hex && !hasExponent {
+		// strconv requires the binary exponent in hexadecimal floats
+		s += "p0"
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false
+	}
+	return value, true
+}
+
+// StringToNumber converts a string to an integer or a float the way Lua
+// coerces strings to numbers: it is a numeral with an optional sign
+// and surrounding whitespace.
+func StringToNumber(s string) (interface{}, bool) {
+	s = strings.Trim(s, " \f\n\r\t\v")
+	if i, ok := ParseInteger(s); ok {
+		return i, true
+	}
+	if f, ok := ParseFloat(s); ok {
+		return f, true
+	}
+	return nil, false
+}
+
+func trimSign(s string) (string, bool) {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		return s[1:], s[0] == '-'
+	}
+	return s, false
+}
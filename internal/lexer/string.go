@@ -0,0 +1,228 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxUTF8Escape is the largest value accepted by the \u{XXX} escape
+	maxUTF8Escape = 0x7FFFFFFF
+	// maxDecimalEscape is the largest value accepted by the \ddd escape
+	maxDecimalEscape = 255
+)
+
+// longBracketLevel returns the level of the opening long bracket [==[ at i
+// (the number of '=' signs) or -1 if there is no long bracket at i.
+func longBracketLevel(input string, i int) int {
+	if i >= len(input) || input[i] != '[' {
+		return -1
+	}
+	j := i + 1
+	for j < len(input) && input[j] == '=' {
+		j++
+	}
+	if j < len(input) && input[j] == '[' {
+		return j - i - 1
+	}
+	return -1
+}
+
+// readLongBracket reads a long bracket [==[ ... ]==] of the given level
+// starting at i and returns its content and the index after the closing bracket.
+// The first newline right after the opening bracket is skipped
+// and every newline sequence is normalized to '\n'.
+func readLongBracket(input string, i, level int) (string, int, bool) {
+	i += level + 2
+	i = skipNewline(input, i)
+	closing := "]" + strings.Repeat("=", level) + "]"
+	var sb strings.Builder
+	for i < len(input) {
+		switch ch := input[i]; {
+		case ch == ']' && strings.HasPrefix(input[i:], closing):
+			return sb.String(), i + len(closing), true
+		case ch == '\n' || ch == '\r':
+			sb.WriteByte('\n')
+			i = skipNewline(input, i)
+		default:
+			sb.WriteByte(ch)
+			i++
+		}
+	}
+	return sb.String(), i, false
+}
+
+// skipNewline skips one newline sequence (\n, \r, \n\r or \r\n) at i.
+func skipNewline(input string, i int) int {
+	if i >= len(input) || (input[i] != '\n' && input[i] != '\r') {
+		return i
+	}
+	if i+1 < len(input) && (input[i+1] == '\n' || input[i+1] == '\r') && input[i+1] != input[i] {
+		return i + 2
+	}
+	return i + 1
+}
+
+// readQuotedString reads a string delimited by the quote at i,
+// decoding the escape sequences of Lua 5.4.
+func readQuotedString(input string, i int) (Token, int) {
+	start := i
+	quote := input[i]
+	i++
+	var sb strings.Builder
+	for {
+		if i >= len(input) {
+			return Token{Type: TokenError, Value: fmt.Sprintf("unfinished string near '%s'", input[start:i])}, i
+		}
+		ch := input[i]
+		switch {
+		case ch == quote:
+			return Token{Type: TokenLiteralString, Value: sb.String()}, i + 1
+		case ch == '\n' || ch == '\r':
+			return Token{Type: TokenError, Value: fmt.Sprintf("unfinished string near '%s'", input[start:i])}, i
+		case ch == '\\':
+			next, err := readEscape(input, i, &sb)
+			if err != "" {
+				return Token{Type: TokenError, Value: fmt.Sprintf("%s near '%s'", err, input[start:next])}, next
+			}
+			i = next
+		default:
+			sb.WriteByte(ch)
+			i++
+		}
+	}
+}
+
+// readEscape decodes the escape sequence starting with the backslash at i
+// into sb and returns the index after it. On failure it returns a description
+// of the error and the index of the offending character.
+func readEscape(input string, i int, sb *strings.Builder) (int, string) {
+	i++ // skip '\'
+	if i >= len(input) {
+		return i, "unfinished string"
+	}
+	switch ch := input[i]; ch {
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\\', '"', '\'':
+		sb.WriteByte(ch)
+	case '\n', '\r':
+		// line continuation
+		sb.WriteByte('\n')
+		return skipNewline(input, i), ""
+	case 'z':
+		i++
+		for i < len(input) && isSpace(input[i]) {
+			i++
+		}
+		return i, ""
+	case 'x':
+		value := 0
+		for k := 1; k <= 2; k++ {
+			if i+k >= len(input) || !isHexDigit(input[i+k]) {
+				return min(i+k+1, len(input)), "hexadecimal digit expected"
+			}
+			value = value*16 + hexValue(input[i+k])
+		}
+		sb.WriteByte(byte(value))
+		return i + 3, ""
+	case 'u':
+		return readUTF8Escape(input, i, sb)
+	default:
+		if !isDigit(ch) {
+			return i + 1, "invalid escape sequence"
+		}
+		value := 0
+		j := i
+		for j < len(input) && j < i+3 && isDigit(input[j]) {
+			value = value*10 + int(input[j]-'0')
+			j++
+		}
+		if value > maxDecimalEscape {
+			return j, "decimal escape too large"
+		}
+		sb.WriteByte(byte(value))
+		return j, ""
+	}
+	return i + 1, ""
+}
+
+// readUTF8Escape decodes \u{XXX} where i points at 'u'.
+func readUTF8Escape(input string, i int, sb *strings.Builder) (int, string) {
+	i++
+	if i >= len(input) || input[i] != '{' {
+		return min(i+1, len(input)), "missing '{' in \\u{xxxx}"
+	}
+	i++
+	if i >= len(input) || !isHexDigit(input[i]) {
+		return min(i+1, len(input)), "hexadecimal digit expected"
+	}
+	var value uint64
+	for i < len(input) && isHexDigit(input[i]) {
+		value = value*16 + uint64(hexValue(input[i]))
+		if value > maxUTF8Escape {
+			return i + 1, "UTF-8 value too large"
+		}
+		i++
+	}
+	if i >= len(input) || input[i] != '}' {
+		return min(i+1, len(input)), "missing '}' in \\u{xxxx}"
+	}
+	sb.WriteString(encodeUTF8(value))
+	return i + 1, ""
+}
+
+// encodeUTF8 encodes values up to 2^31 like Lua does,
+// including surrogates and the 5 and 6 byte sequences.
+func encodeUTF8(value uint64) string {
+	if value < utf8.RuneSelf {
+		return string([]byte{byte(value)})
+	}
+	var buf [6]byte
+	n := 0
+	limit := uint64(0x3f) // maximum that fits in the first byte
+	for value > limit {
+		buf[5-n] = byte(0x80 | (value & 0x3f))
+		n++
+		value >>= 6
+		limit >>= 1
+	}
+	buf[5-n] = byte((^limit << 1) | value)
+	return string(buf[5-n:])
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f' || ch == '\v'
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+func hexValue(ch byte) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10
+	default:
+		return int(ch-'A') + 10
+	}
+}
@@ -0,0 +1,135 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/table"
+)
+
+// A traceback shows the first tracebackHead and the last tracebackTail
+// calls, like in Lua.
+const (
+	tracebackHead = 10
+	tracebackTail = 11
+)
+
+// LuaError is an error raised in Lua: a value thrown by error()
+// or the message of a runtime error, with the traceback of the calls
+// it passed through.
+type LuaError struct {
+	Value     interface{}
+	Traceback string
+	// Level is the number of calls the error still has to leave before
+	// the position of the call is prefixed to a string value, see error().
+	Level int
+	// err is the runtime error the Lua error was made of
+	err error
+	// calls are the lines of the traceback, skipped the number of calls left out
+	calls   []string
+	skipped int
+	// where is the position of the error in the innermost call it has not
+	// left yet, "" while it is not known; prefix is set when the value
+	// waits for it, see Level
+	where  string
+	prefix bool
+}
+
+// located is implemented by the runtime errors that know the position
+// they were raised at, as "chunk:line".
+type located interface {
+	Where() string
+}
+
+// NewLuaError converts an error to a Lua error; the message
+// of a runtime error becomes its value.
+func NewLuaError(err error) *LuaError {
+	var luaErr *LuaError
+	if errors.As(err, &luaErr) {
+		return luaErr
+	}
+	luaErr = &LuaError{Value: err.Error(), err: err}
+	var loc located
+	if errors.As(err, &loc) {
+		luaErr.where = loc.Where()
+	}
+	return luaErr
+}
+
+// ErrorValue returns the Lua value of an error, as pcall catches it.
+// The unwinding of a closed coroutine is not an error, its value is nil.
+func ErrorValue(err error) interface{} {
+	if errors.Is(err, coroutine.ErrClosing) {
+		return nil
+	}
+	return NewLuaError(err).Value
+}
+
+func (e *LuaError) Error() string {
+	switch v := e.Value.(type) {
+	case string:
+		return v
+	case int64, float64:
+		return number.ToString(v)
+	case nil:
+		return "nil"
+	case bool:
+		return "(error object is a boolean value)"
+	case *table.Table:
+		return "(error object is a table value)"
+	default:
+		return "(error object is not a string)"
+	}
+}
+
+func (e *LuaError) Unwrap() error {
+	return e.err
+}
+
+// PassCall records that the error leaves the call of the function
+// described by name, made at where in the calling function: a "chunk:line"
+// position, "[C]" for a call made by a Go function, or "" when the caller
+// locates it later. The call is shown at the position the error had in it,
+// or at [C] if the function is a Go one.
+func (e *LuaError) PassCall(where, name string) {
+	at := e.where
+	if at == "" {
+		at = "[C]"
+	}
+	e.where = ""
+	if e.Level > 0 {
+		e.Level--
+		e.prefix = e.Level == 0
+	}
+	e.Locate(where)
+	e.calls = append(e.calls, fmt.Sprintf("\n\t%s: in %s", at, name))
+	if len(e.calls) > tracebackHead+tracebackTail {
+		e.calls = append(e.calls[:tracebackHead], e.calls[tracebackHead+1:]...)
+		e.skipped++
+	}
+	var b strings.Builder
+	b.WriteString("stack traceback:")
+	for i, call := range e.calls {
+		if i == tracebackHead && e.skipped > 0 {
+			fmt.Fprintf(&b, "\n\t...\t(skipping %d levels)", e.skipped)
+		}
+		b.WriteString(call)
+	}
+	e.Traceback = b.String()
+}
+
+// Locate sets the position of the error in the call it is in, unless it
+// is known already.
+func (e *LuaError) Locate(where string) {
+	if e.where != "" || where == "" {
+		return
+	}
+	e.where = where
+	if s, ok := e.Value.(string); ok && e.prefix && where != "[C]" {
+		e.Value = where + ": " + s
+	}
+	e.prefix = false
+}
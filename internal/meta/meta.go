@@ -0,0 +1,487 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/lexer"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/table"
+)
+
+var (
+	ErrIndexChain    = errors.New("'__index' chain too long; possibly a loop")
+	ErrNewIndexChain = errors.New("'__newindex' chain too long; possibly a loop")
+	ErrProtected     = errors.New("cannot change a protected metatable")
+	ErrToString      = errors.New("'__tostring' must return a string")
+	ErrStackOverflow = errors.New("stack overflow")
+)
+
+// DefaultMaxCallDepth is the default limit of nested calls in a thread.
+// It is lower than in Lua, as the engines nest calls on the Go stack.
+const DefaultMaxCallDepth = 20000
+
+// maxChain limits the length of __index and __newindex chains, like in Lua.
+const maxChain = 2000
+
+var arithEvents = map[number.Op]string{
+	number.OpAdd:  "__add",
+	number.OpSub:  "__sub",
+	number.OpMul:  "__mul",
+	number.OpDiv:  "__div",
+	number.OpIDiv: "__idiv",
+	number.OpMod:  "__mod",
+	number.OpPow:  "__pow",
+	number.OpUnm:  "__unm",
+	number.OpBAnd: "__band",
+	number.OpBOr:  "__bor",
+	number.OpBXor: "__bxor",
+	number.OpShl:  "__shl",
+	number.OpShr:  "__shr",
+	number.OpBNot: "__bnot",
+}
+
+// TypeError is an operation applied to a value of a wrong type.
+type TypeError struct {
+	// Op is the attempted operation, e.g. "index" or "call"
+	Op    string
+	Value interface{}
+	Type  string
+	// Operand is the index of the wrong operand of a binary operator
+	Operand int
+	// VarInfo describes the variable the value was read from,
+	// e.g. "global 'x'"; it is filled in by the engines
+	VarInfo string
+}
+
+func (e *TypeError) Error() string {
+	if e.VarInfo != "" {
+		return fmt.Sprintf("attempt to %s a %s value (%s)", e.Op, e.Type, e.VarInfo)
+	}
+	return fmt.Sprintf("attempt to %s a %s value", e.Op, e.Type)
+}
+
+// AddVarInfo describes the variable a wrong operand was read from in a type
+// error about one of the operands. info returns the description of operand i,
+// or "" if the operand is not a variable. Other errors are returned as is.
+func AddVarInfo(err error, operands []interface{}, info func(i int) string) error {
+	e, ok := err.(*TypeError)
+	if !ok || e.VarInfo != "" || e.Operand >= len(operands) || e.Value != operands[e.Operand] {
+		return err
+	}
+	e.VarInfo = info(e.Operand)
+	return err
+}
+
+// Dispatcher applies the Lua operators to values, calling metamethods
+// when the operands do not support an operator by themselves.
+// Each engine provides the way to call functions and to name types.
+type Dispatcher struct {
+	// Call calls a function value and returns all its results.
+	Call func(fn interface{}, args []interface{}) ([]interface{}, error)
+	// TypeName returns the Lua type of a value.
+	TypeName func(v interface{}) string
+	// Load compiles the source code of a chunk to a function
+	// that runs it; chunk names it in error messages. The _ENV of the
+	// chunk is the value of env, or the global table of the engine if nil.
+	Load func(src, chunk string, env *Env) (interface{}, error)
+	// Strings is the metatable shared by all strings.
+	Strings *table.Table
+	// Threads holds the coroutines of the engine.
+	Threads *coroutine.State
+	// MaxCallDepth limits the number of nested calls in a thread.
+	MaxCallDepth int
+}
+
+// Env is the _ENV upvalue shared by the functions of a chunk.
+// Their global variables are the fields of Value.
+type Env struct {
+	Value interface{}
+}
+
+// GetGlobal reads the global variable name, the field of env, the value
+// of _ENV; kind tells whether _ENV is a "local" or an "upvalue".
+func (d *Dispatcher) GetGlobal(env interface{}, kind, name string) (interface{}, error) {
+	v, err := d.Index(env, name)
+	return v, envInfo(err, env, kind)
+}
+
+// SetGlobal assigns the global variable name in env, the value of _ENV.
+func (d *Dispatcher) SetGlobal(env interface{}, kind, name string, v interface{}) error {
+	return envInfo(d.SetIndex(env, name, v), env, kind)
+}
+
+func envInfo(err error, env interface{}, kind string) error {
+	return AddVarInfo(err, []interface{}{env}, func(int) string { return kind + " '_ENV'" })
+}
+
+// EnterCall counts a call in the running thread. It returns
+// ErrStackOverflow if MaxCallDepth calls are already running;
+// otherwise LeaveCall must be called when the call returns.
+func (d *Dispatcher) EnterCall() error {
+	co := d.Threads.Current()
+	if co.Depth >= d.MaxCallDepth {
+		return ErrStackOverflow
+	}
+	co.Depth++
+	return nil
+}
+
+// LeaveCall counts the return of a call entered with EnterCall.
+func (d *Dispatcher) LeaveCall() {
+	d.Threads.Current().Depth--
+}
+
+// Metatable returns the metatable of a value, or nil if it has none.
+func (d *Dispatcher) Metatable(v interface{}) *table.Table {
+	switch v := v.(type) {
+	case *table.Table:
+		return v.Metatable
+	case string:
+		return d.Strings
+	default:
+		return nil
+	}
+}
+
+// Field returns the raw value of a metamethod or another metatable field.
+func (d *Dispatcher) Field(v interface{}, event string) interface{} {
+	if mt := d.Metatable(v); mt != nil {
+		return mt.Get(event)
+	}
+	return nil
+}
+
+// GetMetatable implements getmetatable: a __metatable field hides the metatable.
+func (d *Dispatcher) GetMetatable(v interface{}) interface{} {
+	mt := d.Metatable(v)
+	if mt == nil {
+		return nil
+	}
+	if protected := mt.Get("__metatable"); protected != nil {
+		return protected
+	}
+	return mt
+}
+
+// SetMetatable implements setmetatable for tables.
+func (d *Dispatcher) SetMetatable(t, mt *table.Table) error {
+	if t.Metatable != nil && t.Metatable.Get("__metatable") != nil {
+		return ErrProtected
+	}
+	t.Metatable = mt
+	return nil
+}
+
+func (d *Dispatcher) isFunction(v interface{}) bool {
+	return d.TypeName(v) == "function"
+}
+
+// CallMeta calls the handler h of the metamethod event, e.g. "__index".
+// The call is recorded in the traceback of an error; the caller locates it.
+func (d *Dispatcher) CallMeta(event string, h interface{}, args []interface{}) ([]interface{}, error) {
+	res, err := d.Call(h, args)
+	if err != nil {
+		luaErr := NewLuaError(err)
+		luaErr.PassCall("", fmt.Sprintf("metamethod '%s'", strings.TrimPrefix(event, "__")))
+		return nil, luaErr
+	}
+	return res, nil
+}
+
+// Callback calls a function passed to a Go function, like the comparator
+// of table.sort. The call is recorded in the traceback of an error.
+func (d *Dispatcher) Callback(fn interface{}, args []interface{}) ([]interface{}, error) {
+	res, err := d.Call(fn, args)
+	if err != nil {
+		luaErr := NewLuaError(err)
+		luaErr.PassCall("[C]", "function <?>")
+		return nil, luaErr
+	}
+	return res, nil
+}
+
+// call1 calls the handler h of the metamethod event and returns its first result.
+func (d *Dispatcher) call1(event string, h interface{}, args ...interface{}) (interface{}, error) {
+	res, err := d.CallMeta(event, h, args)
+	if err != nil || len(res) == 0 {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// Index implements obj[key] with the __index metamethod.
+func (d *Dispatcher) Index(obj, key interface{}) (interface{}, error) {
+	for i := 0; i < maxChain; i++ {
+		var h interface{}
+		if t, ok := obj.(*table.Table); ok {
+			if v := t.Get(key); v != nil {
+				return v, nil
+			}
+			if h = d.Field(t, "__index"); h == nil {
+				return nil, nil
+			}
+		} else if h = d.Field(obj, "__index"); h == nil {
+			return nil, &TypeError{Op: "index", Value: obj, Type: d.TypeName(obj)}
+		}
+		if d.isFunction(h) {
+			return d.call1("__index", h, obj, key)
+		}
+		obj = h
+	}
+	return nil, ErrIndexChain
+}
+
+// SetIndex implements obj[key] = val with the __newindex metamethod.
+func (d *Dispatcher) SetIndex(obj, key, val interface{}) error {
+	for i := 0; i < maxChain; i++ {
+		var h interface{}
+		if t, ok := obj.(*table.Table); ok {
+			if t.Get(key) != nil {
+				return t.Set(key, val)
+			}
+			if h = d.Field(t, "__newindex"); h == nil {
+				return t.Set(key, val)
+			}
+		} else if h = d.Field(obj, "__newindex"); h == nil {
+			return &TypeError{Op: "index", Value: obj, Type: d.TypeName(obj)}
+		}
+		if d.isFunction(h) {
+			_, err := d.CallMeta("__newindex", h, []interface{}{obj, key, val})
+			return err
+		}
+		obj = h
+	}
+	return ErrNewIndexChain
+}
+
+// Arith applies an arithmetic or bitwise operator. Strings convertible
+// to numbers are converted, otherwise the metamethods of the operands
+// are tried when they are not numbers. For unary operators pass
+// the operand as b as well.
+func (d *Dispatcher) Arith(op number.Op, a, b interface{}) (interface{}, error) {
+	res, err := number.Arith(op, a, b)
+	if err == nil || !(errors.Is(err, number.ErrNotNumber) || errors.Is(err, number.ErrNoIntegerRep)) {
+		return res, err
+	}
+	if errors.Is(err, number.ErrNotNumber) {
+		x, okX := ToNumber(a)
+		y, okY := ToNumber(b)
+		if okX && okY {
+			return number.Arith(op, x, y)
+		}
+	}
+	event := arithEvents[op]
+	h := d.Field(a, event)
+	if h == nil {
+		h = d.Field(b, event)
+	}
+	if h == nil {
+		if errors.Is(err, number.ErrNotNumber) {
+			return nil, d.arithError(op, a, b)
+		}
+		return nil, err
+	}
+	return d.call1(event, h, a, b)
+}
+
+// arithError is the error of an operator applied to a non-number:
+// the first operand is blamed unless it is convertible to a number.
+func (d *Dispatcher) arithError(op number.Op, a, b interface{}) error {
+	e := &TypeError{Op: "perform arithmetic on", Value: a}
+	if _, ok := ToNumber(a); ok {
+		e.Value, e.Operand = b, 1
+	}
+	if op.IsBitwise() {
+		e.Op = "perform bitwise operation on"
+	}
+	e.Type = d.TypeName(e.Value)
+	return e
+}
+
+// ToNumber converts a number or a string holding a numeral to a number.
+func ToNumber(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case int64, float64:
+		return v, true
+	case string:
+		return lexer.StringToNumber(v)
+	default:
+		return nil, false
+	}
+}
+
+// Equal implements ==; __eq is only tried for two different tables.
+func (d *Dispatcher) Equal(a, b interface{}) (bool, error) {
+	if number.IsNumber(a) && number.IsNumber(b) {
+		return number.Equal(a, b), nil
+	}
+	if a == b {
+		return true, nil
+	}
+	ta, okA := a.(*table.Table)
+	tb, okB := b.(*table.Table)
+	if !okA || !okB {
+		return false, nil
+	}
+	h := d.Field(ta, "__eq")
+	if h == nil {
+		h = d.Field(tb, "__eq")
+	}
+	if h == nil {
+		return false, nil
+	}
+	res, err := d.call1("__eq", h, a, b)
+	return Truthy(res), err
+}
+
+// LessThan implements <, comparing numbers, strings or values with __lt.
+func (d *Dispatcher) LessThan(a, b interface{}) (bool, error) {
+	if number.IsNumber(a) && number.IsNumber(b) {
+		return number.LessThan(a, b), nil
+	}
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			return sa < sb, nil
+		}
+	}
+	return d.compare("__lt", a, b)
+}
+
+// LessEqual implements <=, comparing numbers, strings or values with __le.
+func (d *Dispatcher) LessEqual(a, b interface{}) (bool, error) {
+	if number.IsNumber(a) && number.IsNumber(b) {
+		return number.LessEqual(a, b), nil
+	}
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			return sa <= sb, nil
+		}
+	}
+	return d.compare("__le", a, b)
+}
+
+func (d *Dispatcher) compare(event string, a, b interface{}) (bool, error) {
+	h := d.Field(a, event)
+	if h == nil {
+		h = d.Field(b, event)
+	}
+	if h == nil {
+		ta, tb := d.TypeName(a), d.TypeName(b)
+		if ta == tb {
+			return false, fmt.Errorf("attempt to compare two %s values", ta)
+		}
+		return false, fmt.Errorf("attempt to compare %s with %s", ta, tb)
+	}
+	res, err := d.call1(event, h, a, b)
+	return Truthy(res), err
+}
+
+// Concat implements .. for strings and numbers, or with __concat.
+func (d *Dispatcher) Concat(a, b interface{}) (interface{}, error) {
+	sa, okA := concatOperand(a)
+	sb, okB := concatOperand(b)
+	if okA && okB {
+		return sa + sb, nil
+	}
+	h := d.Field(a, "__concat")
+	if h == nil {
+		h = d.Field(b, "__concat")
+	}
+	if h == nil {
+		bad, operand := a, 0
+		if okA {
+			bad, operand = b, 1
+		}
+		return nil, &TypeError{Op: "concatenate", Value: bad, Type: d.TypeName(bad), Operand: operand}
+	}
+	return d.call1("__concat", h, a, b)
+}
+
+func concatOperand(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int64, float64:
+		return number.ToString(v), true
+	default:
+		return "", false
+	}
+}
+
+// Len implements the # operator with __len.
+func (d *Dispatcher) Len(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return int64(len(s)), nil
+	}
+	if h := d.Field(v, "__len"); h != nil {
+		return d.call1("__len", h, v)
+	}
+	if t, ok := v.(*table.Table); ok {
+		return t.Length(), nil
+	}
+	return nil, &TypeError{Op: "get length of", Value: v, Type: d.TypeName(v)}
+}
+
+// CallHandler returns the __call metamethod of a value that is not a function.
+func (d *Dispatcher) CallHandler(v interface{}) (interface{}, bool) {
+	h := d.Field(v, "__call")
+	if h == nil || !d.isFunction(h) {
+		return nil, false
+	}
+	return h, true
+}
+
+// Closable reports whether a value can be assigned to a to-be-closed variable:
+// nil and false, or a value with a __close metamethod.
+func (d *Dispatcher) Closable(v interface{}) bool {
+	return !Truthy(v) || d.Field(v, "__close") != nil
+}
+
+// Close calls the __close metamethod of a to-be-closed value
+// with the error that ends its scope, or nil.
+func (d *Dispatcher) Close(v, errValue interface{}) error {
+	if !Truthy(v) {
+		return nil
+	}
+	_, err := d.CallMeta("__close", d.Field(v, "__close"), []interface{}{v, errValue})
+	return err
+}
+
+// ToString converts a value for tostring and print, honoring __tostring
+// and __name; raw formats values without a metatable.
+func (d *Dispatcher) ToString(v interface{}, raw func(interface{}) string) (string, error) {
+	if h := d.Field(v, "__tostring"); h != nil {
+		res, err := d.call1("__tostring", h, v)
+		if err != nil {
+			return "", err
+		}
+		switch res := res.(type) {
+		case string:
+			return res, nil
+		case int64, float64:
+			return number.ToString(res), nil
+		}
+		return "", ErrToString
+	}
+	if name, ok := d.Field(v, "__name").(string); ok {
+		if t, ok := v.(*table.Table); ok {
+			return fmt.Sprintf("%s: %p", name, t), nil
+		}
+	}
+	return raw(v), nil
+}
+
+// Truthy reports whether a value counts as true: anything but nil and false.
+func Truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	default:
+		return true
+	}
+}
@@ -0,0 +1,267 @@
+package meta_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/table"
+)
+
+// goFunction is the function value of the test dispatcher.
+type goFunction func(args []interface{}) []interface{}
+
+type MetaSuite struct {
+	suite.Suite
+	d *meta.Dispatcher
+}
+
+func TestMetaSuite(t *testing.T) {
+	suite.Run(t, new(MetaSuite))
+}
+
+func (s *MetaSuite) SetupTest() {
+	s.d = &meta.Dispatcher{
+		Call: func(fn interface{}, args []interface{}) ([]interface{}, error) {
+			return fn.(goFunction)(args), nil
+		},
+		TypeName: func(v interface{}) string {
+			switch v.(type) {
+			case nil:
+				return "nil"
+			case goFunction:
+				return "function"
+			case *table.Table:
+				return "table"
+			case string:
+				return "string"
+			default:
+				return "number"
+			}
+		},
+	}
+}
+
+// withMeta creates a table with a metatable holding the given fields.
+func withMeta(fields map[string]interface{}) *table.Table {
+	mt := table.New(0, len(fields))
+	for k, v := range fields {
+		_ = mt.Set(k, v)
+	}
+	t := table.New(0, 0)
+	t.Metatable = mt
+	return t
+}
+
+func (s *MetaSuite) TestIndex() {
+	base := table.New(0, 1)
+	_ = base.Set("a", int64(1))
+	t := withMeta(map[string]interface{}{"__index": base})
+	v, err := s.d.Index(t, "a")
+	s.NoError(err)
+	s.Equal(int64(1), v)
+
+	fn := withMeta(map[string]interface{}{"__index": goFunction(func(args []interface{}) []interface{} {
+		return []interface{}{args[1].(string) + "!"}
+	})})
+	v, err = s.d.Index(fn, "b")
+	s.NoError(err)
+	s.Equal("b!", v)
+
+	_, err = s.d.Index(int64(1), "a")
+	s.EqualError(err, "attempt to index a number value")
+
+	loop := table.New(0, 1)
+	_ = loop.Set("__index", loop)
+	loop.Metatable = loop
+	_, err = s.d.Index(loop, "x")
+	s.ErrorIs(err, meta.ErrIndexChain)
+}
+
+func (s *MetaSuite) TestSetIndex() {
+	store := table.New(0, 0)
+	t := withMeta(map[string]interface{}{"__newindex": store})
+	s.NoError(s.d.SetIndex(t, "a", int64(1)))
+	s.Nil(t.Get("a"))
+	s.Equal(int64(1), store.Get("a"))
+
+	// an existing key is assigned without __newindex
+	_ = t.Set("b", int64(1))
+	s.NoError(s.d.SetIndex(t, "b", int64(2)))
+	s.Equal(int64(2), t.Get("b"))
+}
+
+func (s *MetaSuite) TestArith() {
+	v, err := s.d.Arith(number.OpAdd, int64(1), int64(2))
+	s.NoError(err)
+	s.Equal(int64(3), v)
+
+	t := withMeta(map[string]interface{}{"__add": goFunction(func(args []interface{}) []interface{} {
+		return []interface{}{"added"}
+	})})
+	v, err = s.d.Arith(number.OpAdd, int64(1), t)
+	s.NoError(err)
+	s.Equal("added", v)
+
+	_, err = s.d.Arith(number.OpSub, int64(1), t)
+	var typeErr *meta.TypeError
+	s.Require().ErrorAs(err, &typeErr)
+	s.Equal(1, typeErr.Operand)
+	s.EqualError(err, "attempt to perform arithmetic on a table value")
+
+	_, err = s.d.Arith(number.OpBAnd, nil, int64(1))
+	s.EqualError(err, "attempt to perform bitwise operation on a nil value")
+	_, err = s.d.Arith(number.OpBAnd, 1.5, int64(1))
+	s.ErrorIs(err, number.ErrNoIntegerRep)
+}
+
+func (s *MetaSuite) TestAddVarInfo() {
+	_, err := s.d.Concat("a", nil)
+	err = meta.AddVarInfo(err, []interface{}{"a", nil}, func(i int) string {
+		return []string{"local 's'", "global 'x'"}[i]
+	})
+	s.EqualError(err, "attempt to concatenate a nil value (global 'x')")
+
+	// the value is not an operand, e.g. it was found by __index
+	t := withMeta(map[string]interface{}{"__index": int64(1)})
+	_, err = s.d.Index(t, "a")
+	err = meta.AddVarInfo(err, []interface{}{t}, func(int) string { return "local 't'" })
+	s.EqualError(err, "attempt to index a number value")
+}
+
+func (s *MetaSuite) TestEnv() {
+	env := table.New(0, 0)
+	s.NoError(s.d.SetGlobal(env, "upvalue", "x", int64(1)))
+	v, err := s.d.GetGlobal(env, "upvalue", "x")
+	s.NoError(err)
+	s.Equal(int64(1), v)
+
+	_, err = s.d.GetGlobal(nil, "upvalue", "x")
+	s.EqualError(err, "attempt to index a nil value (upvalue '_ENV')")
+	err = s.d.SetGlobal(nil, "local", "x", int64(1))
+	s.EqualError(err, "attempt to index a nil value (local '_ENV')")
+}
+
+func (s *MetaSuite) TestCompare() {
+	calls := 0
+	eq := goFunction(func(args []interface{}) []interface{} {
+		calls++
+		return []interface{}{true}
+	})
+	a := withMeta(map[string]interface{}{"__eq": eq})
+	b := withMeta(map[string]interface{}{"__eq": eq})
+
+	res, err := s.d.Equal(a, a)
+	s.NoError(err)
+	s.True(res)
+	s.Equal(0, calls)
+	res, err = s.d.Equal(a, b)
+	s.NoError(err)
+	s.True(res)
+	s.Equal(1, calls)
+	res, err = s.d.Equal(a, int64(1))
+	s.NoError(err)
+	s.False(res)
+
+	res, err = s.d.LessThan("a", "b")
+	s.NoError(err)
+	s.True(res)
+	_, err = s.d.LessThan(a, b)
+	s.EqualError(err, "attempt to compare two table values")
+	_, err = s.d.LessEqual(int64(1), "b")
+	s.EqualError(err, "attempt to compare number with string")
+}
+
+func (s *MetaSuite) TestMetatableProtection() {
+	t := withMeta(map[string]interface{}{"__metatable": "locked"})
+	s.Equal("locked", s.d.GetMetatable(t))
+	s.ErrorIs(s.d.SetMetatable(t, nil), meta.ErrProtected)
+	s.Nil(s.d.GetMetatable(table.New(0, 0)))
+}
+
+func (s *MetaSuite) TestToString() {
+	raw := func(interface{}) string { return "raw" }
+	str, err := s.d.ToString(table.New(0, 0), raw)
+	s.NoError(err)
+	s.Equal("raw", str)
+
+	t := withMeta(map[string]interface{}{"__tostring": goFunction(func(args []interface{}) []interface{} {
+		return []interface{}{"custom"}
+	})})
+	str, err = s.d.ToString(t, raw)
+	s.NoError(err)
+	s.Equal("custom", str)
+
+	// like in Lua, a number is converted to a string
+	num := withMeta(map[string]interface{}{"__tostring": goFunction(func(args []interface{}) []interface{} {
+		return []interface{}{int64(42)}
+	})})
+	str, err = s.d.ToString(num, raw)
+	s.NoError(err)
+	s.Equal("42", str)
+
+	bad := withMeta(map[string]interface{}{"__tostring": goFunction(func(args []interface{}) []interface{} {
+		return []interface{}{true}
+	})})
+	_, err = s.d.ToString(bad, raw)
+	s.ErrorIs(err, meta.ErrToString)
+}
+
+func (s *MetaSuite) TestLuaError() {
+	err := &meta.LuaError{Value: "boom", Level: 2}
+	err.PassCall("chunk:3", "function 'error'")
+	s.Equal("boom", err.Value)
+	err.PassCall("chunk:7", "function 'f'")
+	s.Equal("chunk:7: boom", err.Value)
+	err.PassCall("chunk:9", "function 'g'")
+	s.Equal("chunk:7: boom", err.Value)
+	s.Equal("stack traceback:\n\t[C]: in function 'error'\n\tchunk:3: in function 'f'\n\tchunk:7: in function 'g'", err.Traceback)
+
+	// a metamethod call is located by its caller
+	err = &meta.LuaError{Value: "boom", Level: 2}
+	err.PassCall("chunk:1", "function 'error'")
+	err.PassCall("", "metamethod 'index'")
+	s.Equal("boom", err.Value)
+	err.Locate("chunk:4")
+	err.Locate("chunk:5")
+	s.Equal("chunk:4: boom", err.Value)
+	err.PassCall("chunk:6", "function 'f'")
+	s.Equal("stack traceback:\n\t[C]: in function 'error'\n\tchunk:1: in metamethod 'index'\n\tchunk:4: in function 'f'", err.Traceback)
+
+	runtimeErr := errors.New("attempt to index a nil value")
+	luaErr := meta.NewLuaError(runtimeErr)
+	s.Equal("attempt to index a nil value", luaErr.Value)
+	s.ErrorIs(luaErr, runtimeErr)
+	s.Same(luaErr, meta.NewLuaError(luaErr))
+
+	s.EqualError(&meta.LuaError{Value: table.New(0, 0)}, "(error object is a table value)")
+	s.EqualError(&meta.LuaError{Value: 1.5}, "1.5")
+
+	// a long traceback shows the first 10 and the last 11 calls
+	deep := &meta.LuaError{Value: "deep"}
+	for i := 1; i <= 30; i++ {
+		deep.PassCall(fmt.Sprintf("chunk:%d", i), "function 'f'")
+	}
+	lines := strings.Split(deep.Traceback, "\n\t")
+	s.Len(lines, 23)
+	s.Equal("chunk:9: in function 'f'", lines[10])
+	s.Equal("...\t(skipping 9 levels)", lines[11])
+	s.Equal("chunk:19: in function 'f'", lines[12])
+	s.Equal("chunk:29: in function 'f'", lines[22])
+}
+
+func (s *MetaSuite) TestCallDepth() {
+	s.d.Threads = coroutine.NewState(s.d.Call)
+	s.d.MaxCallDepth = 2
+	s.NoError(s.d.EnterCall())
+	s.NoError(s.d.EnterCall())
+	s.ErrorIs(s.d.EnterCall(), meta.ErrStackOverflow)
+	s.d.LeaveCall()
+	s.NoError(s.d.EnterCall())
+}
@@ -0,0 +1,186 @@
+package number
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	ErrNotNumber             = errors.New("attempt to perform arithmetic on a non-number value")
+	ErrNoIntegerRep          = errors.New("number has no integer representation")
+	ErrIntegerDivisionByZero = errors.New("attempt to perform 'n//0'")
+	ErrIntegerModuloByZero   = errors.New("attempt to perform 'n%0'")
+)
+
+// Op is an arithmetic or bitwise operator.
+type Op int
+
+const (
+	OpAdd Op = iota
+	OpSub
+	OpMul
+	OpDiv
+	OpIDiv
+	OpMod
+	OpPow
+	OpUnm
+	OpBAnd
+	OpBOr
+	OpBXor
+	OpShl
+	OpShr
+	OpBNot
+)
+
+// IsBitwise reports whether op is a bitwise operator.
+func (op Op) IsBitwise() bool {
+	return op >= OpBAnd
+}
+
+// Arith applies op to two numbers following Lua 5.4 rules:
+// integer operands give integer results (except for / and ^),
+// mixed operands are converted to floats, and bitwise operators
+// require numbers with an exact integer representation.
+// Unary operators use only a; pass the operand as b as well.
+func Arith(op Op, a, b interface{}) (interface{}, error) {
+	switch {
+	case op.IsBitwise():
+		if !IsNumber(a) || !IsNumber(b) {
+			return nil, ErrNotNumber
+		}
+		x, okX := ToInteger(a)
+		y, okY := ToInteger(b)
+		if !okX || !okY {
+			return nil, ErrNoIntegerRep
+		}
+		return bitwise(op, x, y), nil
+	case op == OpDiv || op == OpPow:
+		// always performed on floats
+	default:
+		if x, ok := a.(int64); ok {
+			if y, ok := b.(int64); ok {
+				return integerArith(op, x, y)
+			}
+		}
+	}
+	x, okX := ToFloat(a)
+	y, okY := ToFloat(b)
+	if !okX || !okY {
+		return nil, ErrNotNumber
+	}
+	return floatArith(op, x, y), nil
+}
+
+func integerArith(op Op, x, y int64) (interface{}, error) {
+	switch op {
+	case OpAdd:
+		return x + y, nil
+	case OpSub:
+		return x - y, nil
+	case OpMul:
+		return x * y, nil
+	case OpIDiv:
+		if y == 0 {
+			return nil, ErrIntegerDivisionByZero
+		}
+		return FloorDiv(x, y), nil
+	case OpMod:
+		if y == 0 {
+			return nil, ErrIntegerModuloByZero
+		}
+		return Mod(x, y), nil
+	case OpUnm:
+		return -x, nil
+	default:
+		return floatArith(op, float64(x), float64(y)), nil
+	}
+}
+
+func floatArith(op Op, x, y float64) float64 {
+	switch op {
+	case OpAdd:
+		return x + y
+	case OpSub:
+		return x - y
+	case OpMul:
+		return x * y
+	case OpDiv:
+		return x / y
+	case OpIDiv:
+		return math.Floor(x / y)
+	case OpMod:
+		return FloatMod(x, y)
+	case OpPow:
+		return math.Pow(x, y)
+	case OpUnm:
+		return -x
+	default:
+		return math.NaN()
+	}
+}
+
+func bitwise(op Op, x, y int64) int64 {
+	switch op {
+	case OpBAnd:
+		return x & y
+	case OpBOr:
+		return x | y
+	case OpBXor:
+		return x ^ y
+	case OpShl:
+		return ShiftLeft(x, y)
+	case OpShr:
+		return ShiftLeft(x, -y)
+	default: // OpBNot
+		return ^x
+	}
+}
+
+// FloorDiv is the integer division rounded towards minus infinity.
+// y must not be zero.
+func FloorDiv(x, y int64) int64 {
+	if y == -1 {
+		// avoid the overflow of math.MinInt64 / -1
+		return -x
+	}
+	q := x / y
+	if x%y != 0 && (x^y) < 0 {
+		q--
+	}
+	return q
+}
+
+// Mod is the integer modulo with the sign of the divisor.
+// y must not be zero.
+func Mod(x, y int64) int64 {
+	if y == -1 {
+		return 0
+	}
+	r := x % y
+	if r != 0 && (r^y) < 0 {
+		r += y
+	}
+	return r
+}
+
+// FloatMod is the float modulo with the sign of the divisor.
+func FloatMod(x, y float64) float64 {
+	m := math.Mod(x, y)
+	if (m > 0 && y < 0) || (m < 0 && y > 0) {
+		m += y
+	}
+	return m
+}
+
+// ShiftLeft shifts x logically by y bits, to the right if y is negative.
+// Shifts by 64 or more bits give zero.
+func ShiftLeft(x, y int64) int64 {
+	switch {
+	case y <= -64 || y >= 64:
+		return 0
+	case y >= 0:
+		return int64(uint64(x) << uint64(y))
+	default:
+		return int64(uint64(x) >> uint64(-y))
+	}
+}
@@ -0,0 +1,157 @@
+package number
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrForStepZero = errors.New("'for' step is zero")
+
+// ForLoop holds the control values of a numeric for loop.
+// Like in Lua 5.4, the loop runs on integers when both the initial value
+// and the step are integers, and on floats otherwise; an integer loop
+// precomputes its iteration count, so it never overflows.
+type ForLoop struct {
+	isInteger bool
+	started   bool
+	done      bool
+	// integer loop
+	index int64
+	step  int64
+	count uint64
+	// float loop
+	findex float64
+	flimit float64
+	fstep  float64
+}
+
+// NewForLoop prepares a loop from its initial value, limit and step.
+func NewForLoop(init, limit, step interface{}) (*ForLoop, error) {
+	i, okInit := init.(int64)
+	s, okStep := step.(int64)
+	if okInit && okStep {
+		return newIntegerLoop(i, limit, s)
+	}
+
+	flimit, ok := ToFloat(limit)
+	if !ok {
+		return nil, forError("limit")
+	}
+	fstep, ok := ToFloat(step)
+	if !ok {
+		return nil, forError("step")
+	}
+	finit, ok := ToFloat(init)
+	if !ok {
+		return nil, forError("initial value")
+	}
+	if fstep == 0 {
+		return nil, ErrForStepZero
+	}
+	loop := &ForLoop{findex: finit, flimit: flimit, fstep: fstep}
+	if fstep > 0 {
+		loop.done = !(finit <= flimit)
+	} else {
+		loop.done = !(flimit <= finit)
+	}
+	return loop, nil
+}
+
+func newIntegerLoop(init int64, limit interface{}, step int64) (*ForLoop, error) {
+	if step == 0 {
+		return nil, ErrForStepZero
+	}
+	loop := &ForLoop{isInteger: true, index: init, step: step}
+	lim, skip, err := integerLimit(init, limit, step)
+	if err != nil {
+		return nil, err
+	}
+	if skip {
+		loop.done = true
+		return loop, nil
+	}
+	if step > 0 {
+		loop.count = uint64(lim) - uint64(init)
+		if step != 1 {
+			loop.count /= uint64(step)
+		}
+	} else {
+		loop.count = uint64(init) - uint64(lim)
+		// step+1 avoids negating math.MinInt64
+		loop.count /= uint64(-(step + 1)) + 1
+	}
+	return loop, nil
+}
+
+// integerLimit converts the limit of an integer loop to an integer,
+// clipping float limits, and reports whether the loop must be skipped.
+func integerLimit(init int64, limit interface{}, step int64) (int64, bool, error) {
+	var lim int64
+	switch l := limit.(type) {
+	case int64:
+		lim = l
+	case float64:
+		var f float64
+		if step < 0 {
+			f = math.Ceil(l)
+		} else {
+			f = math.Floor(l)
+		}
+		if i, ok := FloatToInteger(f); ok {
+			lim = i
+		} else if l > 0 {
+			// the limit is beyond the integer range
+			if step < 0 {
+				return 0, true, nil
+			}
+			lim = math.MaxInt64
+		} else {
+			// the limit is below the integer range or NaN
+			if step > 0 {
+				return 0, true, nil
+			}
+			lim = math.MinInt64
+		}
+	default:
+		return 0, false, forError("limit")
+	}
+	if step > 0 {
+		return lim, init > lim, nil
+	}
+	return lim, init < lim, nil
+}
+
+// Next returns the value of the control variable for the next iteration
+// or false when the loop is over.
+func (l *ForLoop) Next() (interface{}, bool) {
+	if l.done {
+		return nil, false
+	}
+	if !l.started {
+		l.started = true
+		if l.isInteger {
+			return l.index, true
+		}
+		return l.findex, true
+	}
+	if l.isInteger {
+		if l.count == 0 {
+			l.done = true
+			return nil, false
+		}
+		l.count--
+		l.index += l.step
+		return l.index, true
+	}
+	l.findex += l.fstep
+	if (l.fstep > 0 && l.findex <= l.flimit) || (l.fstep < 0 && l.flimit <= l.findex) {
+		return l.findex, true
+	}
+	l.done = true
+	return nil, false
+}
+
+func forError(what string) error {
+	return fmt.Errorf("'for' %s must be a number", what)
+}
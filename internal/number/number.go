@@ -0,0 +1,226 @@
+// Package number implements the Lua 5.4 number model shared by the
+// tree-walking interpreter and the VM.
+// Integers are int64 with wraparound arithmetic, floats are float64.
+package number
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minIntegerFloat is -2^63, the smallest float that converts to an integer
+	minIntegerFloat = -9223372036854775808.0
+	// maxIntegerFloat is 2^63, the smallest float too large for an integer
+	maxIntegerFloat = 9223372036854775808.0
+)
+
+// IsNumber reports whether v is an integer or a float.
+func IsNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// Type returns "integer", "float" or "" if v is not a number, like math.type.
+func Type(v interface{}) string {
+	switch v.(type) {
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	default:
+		return ""
+	}
+}
+
+// ToFloat converts an integer or a float to a float.
+func ToFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// ToInteger converts an integer or a float with an exact integer value
+// to an integer.
+func ToInteger(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return FloatToInteger(n)
+	default:
+		return 0, false
+	}
+}
+
+// FloatToInteger converts f to an integer if it has an exact integer value
+// in the range of int64.
+func FloatToInteger(f float64) (int64, bool) {
+	if math.Floor(f) != f || f < minIntegerFloat || f >= maxIntegerFloat {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// ToString formats a number the way Lua converts numbers to strings:
+// integers with %d and floats with %.14g, keeping a ".0" suffix
+// on floats that look like integers.
+func ToString(v interface{}) string {
+	switch n := v.(type) {
+	case int64:
+		return strconv.FormatInt(n, 10)
+	case float64:
+		return formatFloat(n)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	s := fmt.Sprintf("%.14g", f)
+	if strings.Trim(s, "-0123456789") == "" {
+		s += ".0"
+	}
+	return s
+}
+
+// Equal compares two numbers by their mathematical values,
+// so that 1 == 1.0.
+func Equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x == y
+		case float64:
+			i, ok := FloatToInteger(y)
+			return ok && i == x
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			i, ok := FloatToInteger(x)
+			return ok && i == y
+		case float64:
+			return x == y
+		}
+	}
+	return false
+}
+
+// LessThan reports whether a < b for two numbers, comparing integers
+// with floats exactly instead of converting them to floats.
+func LessThan(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x < y
+		case float64:
+			return intLessFloat(x, y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return floatLessInt(x, y)
+		case float64:
+			return x < y
+		}
+	}
+	return false
+}
+
+// LessEqual reports whether a <= b for two numbers.
+func LessEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x <= y
+		case float64:
+			return intLessEqualFloat(x, y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return floatLessEqualInt(x, y)
+		case float64:
+			return x <= y
+		}
+	}
+	return false
+}
+
+// i < f <=> i < ceil(f)
+func intLessFloat(i int64, f float64) bool {
+	switch {
+	case math.IsNaN(f):
+		return false
+	case f >= maxIntegerFloat:
+		return true
+	case f <= minIntegerFloat:
+		return false
+	}
+	return i < int64(math.Ceil(f))
+}
+
+// i <= f <=> i <= floor(f)
+func intLessEqualFloat(i int64, f float64) bool {
+	switch {
+	case math.IsNaN(f):
+		return false
+	case f >= maxIntegerFloat:
+		return true
+	case f < minIntegerFloat:
+		return false
+	}
+	return i <= int64(math.Floor(f))
+}
+
+// f < i <=> floor(f) < i
+func floatLessInt(f float64, i int64) bool {
+	switch {
+	case math.IsNaN(f):
+		return false
+	case f >= maxIntegerFloat:
+		return false
+	case f < minIntegerFloat:
+		return true
+	}
+	return int64(math.Floor(f)) < i
+}
+
+// f <= i <=> ceil(f) <= i
+func floatLessEqualInt(f float64, i int64) bool {
+	switch {
+	case math.IsNaN(f):
+		return false
+	case f >= maxIntegerFloat:
+		return false
+	case f < minIntegerFloat:
+		return true
+	}
+	return int64(math.Ceil(f)) <= i
+}
@@ -0,0 +1,193 @@
+package number_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"lua-interpreter/internal/number"
+)
+
+type NumberSuite struct {
+	suite.Suite
+}
+
+func TestNumberSuite(t *testing.T) {
+	suite.Run(t, new(NumberSuite))
+}
+
+func (s *NumberSuite) TestArith() {
+	tests := []struct {
+		op       number.Op
+		a, b     interface{}
+		expected interface{}
+	}{
+		{number.OpAdd, int64(1), int64(2), int64(3)},
+		{number.OpAdd, int64(math.MaxInt64), int64(1), int64(math.MinInt64)},
+		{number.OpMul, int64(math.MinInt64), int64(-1), int64(math.MinInt64)},
+		{number.OpAdd, int64(1), 2.0, 3.0},
+		{number.OpSub, 1.5, int64(1), 0.5},
+		{number.OpDiv, int64(6), int64(3), 2.0},
+		{number.OpPow, int64(2), int64(10), 1024.0},
+		{number.OpIDiv, int64(7), int64(2), int64(3)},
+		{number.OpIDiv, int64(-7), int64(2), int64(-4)},
+		{number.OpIDiv, int64(7), int64(-2), int64(-4)},
+		{number.OpIDiv, int64(math.MinInt64), int64(-1), int64(math.MinInt64)},
+		{number.OpIDiv, 7.0, int64(2), 3.0},
+		{number.OpIDiv, -7.5, 2.0, -4.0},
+		{number.OpMod, int64(7), int64(3), int64(1)},
+		{number.OpMod, int64(-7), int64(3), int64(2)},
+		{number.OpMod, int64(7), int64(-3), int64(-2)},
+		{number.OpMod, int64(math.MinInt64), int64(-1), int64(0)},
+		{number.OpMod, 5.5, int64(2), 1.5},
+		{number.OpMod, -5.5, int64(2), 0.5},
+		{number.OpMod, 5.5, int64(-2), -0.5},
+		{number.OpUnm, int64(math.MinInt64), int64(math.MinInt64), int64(math.MinInt64)},
+		{number.OpUnm, 2.5, 2.5, -2.5},
+		{number.OpBAnd, int64(0xF0), 255.0, int64(0xF0)},
+		{number.OpBOr, int64(1) << 60, int64(1), int64(1)<<60 | 1},
+		{number.OpBXor, int64(5), int64(3), int64(6)},
+		{number.OpShl, int64(1), int64(63), int64(math.MinInt64)},
+		{number.OpShl, int64(1), int64(64), int64(0)},
+		{number.OpShr, int64(-1), int64(60), int64(15)},
+		{number.OpShr, int64(2), int64(-1), int64(4)},
+		{number.OpBNot, int64(0), int64(0), int64(-1)},
+	}
+
+	for _, test := range tests {
+		res, err := number.Arith(test.op, test.a, test.b)
+		s.NoError(err)
+		s.Equal(test.expected, res, "op %d on %v and %v", test.op, test.a, test.b)
+	}
+}
+
+func (s *NumberSuite) TestArithErrors() {
+	tests := []struct {
+		op       number.Op
+		a, b     interface{}
+		expected error
+	}{
+		{number.OpIDiv, int64(1), int64(0), number.ErrIntegerDivisionByZero},
+		{number.OpMod, int64(1), int64(0), number.ErrIntegerModuloByZero},
+		{number.OpBAnd, 1.5, int64(1), number.ErrNoIntegerRep},
+		{number.OpShl, int64(1), math.Inf(1), number.ErrNoIntegerRep},
+		{number.OpAdd, "1", int64(1), number.ErrNotNumber},
+		{number.OpBOr, nil, int64(1), number.ErrNotNumber},
+	}
+
+	for _, test := range tests {
+		_, err := number.Arith(test.op, test.a, test.b)
+		s.ErrorIs(err, test.expected)
+	}
+
+	// float division by zero follows IEEE 754
+	res, err := number.Arith(number.OpIDiv, 1.0, int64(0))
+	s.NoError(err)
+	s.Equal(math.Inf(1), res)
+	res, err = number.Arith(number.OpMod, int64(1), 0.0)
+	s.NoError(err)
+	s.True(math.IsNaN(res.(float64)))
+}
+
+func (s *NumberSuite) TestToString() {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{int64(3), "3"},
+		{3.0, "3.0"},
+		{-0.0 * -1, "0.0"},
+		{math.Copysign(0, -1), "-0.0"},
+		{3.5, "3.5"},
+		{1e15, "1e+15"},
+		{2.0 * (1 << 53), "1.8014398509482e+16"},
+		{0.1, "0.1"},
+		{1 / 3.0, "0.33333333333333"},
+		{int64(math.MinInt64), "-9223372036854775808"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+		{math.NaN(), "nan"},
+	}
+
+	for _, test := range tests {
+		s.Equal(test.expected, number.ToString(test.value))
+	}
+}
+
+func (s *NumberSuite) TestConversions() {
+	i, ok := number.ToInteger(3.0)
+	s.True(ok)
+	s.Equal(int64(3), i)
+
+	for _, f := range []float64{3.5, math.Inf(1), math.NaN(), 9223372036854775808.0} {
+		_, ok = number.ToInteger(f)
+		s.False(ok, "value: %v", f)
+	}
+	i, ok = number.ToInteger(-9223372036854775808.0)
+	s.True(ok)
+	s.Equal(int64(math.MinInt64), i)
+
+	s.Equal("integer", number.Type(int64(1)))
+	s.Equal("float", number.Type(1.0))
+	s.Equal("", number.Type("1"))
+}
+
+func (s *NumberSuite) TestCompare() {
+	// 2^53 + 1 is not representable as a float
+	big := int64(1)<<53 + 1
+	s.False(number.Equal(big, float64(big)))
+	s.True(number.LessThan(float64(big), big))
+	s.True(number.LessEqual(float64(big), big))
+	s.False(number.LessThan(big, float64(big)))
+	s.True(number.Equal(int64(1), 1.0))
+	s.False(number.Equal(int64(1), 1.5))
+
+	s.True(number.LessThan(int64(math.MaxInt64), 9223372036854775808.0))
+	s.False(number.LessThan(int64(math.MinInt64), -9223372036854775808.0))
+	s.True(number.LessEqual(int64(math.MinInt64), -9223372036854775808.0))
+	s.True(number.LessThan(int64(1), 1.5))
+	s.False(number.LessThan(1.5, int64(1)))
+	s.True(number.LessEqual(math.Inf(-1), int64(math.MinInt64)))
+
+	nan := math.NaN()
+	s.False(number.LessThan(int64(1), nan))
+	s.False(number.LessEqual(int64(1), nan))
+	s.False(number.LessThan(nan, int64(1)))
+	s.False(number.LessEqual(nan, int64(1)))
+}
+
+func (s *NumberSuite) TestForLoop() {
+	collect := func(init, limit, step interface{}) []interface{} {
+		loop, err := number.NewForLoop(init, limit, step)
+		s.Require().NoError(err)
+		var values []interface{}
+		for v, ok := loop.Next(); ok; v, ok = loop.Next() {
+			values = append(values, v)
+		}
+		return values
+	}
+
+	s.Equal([]interface{}{int64(1), int64(2), int64(3)}, collect(int64(1), int64(3), int64(1)))
+	s.Equal([]interface{}{int64(10), int64(7), int64(4), int64(1)}, collect(int64(10), int64(1), int64(-3)))
+	s.Equal([]interface{}{int64(1), int64(2)}, collect(int64(1), 2.9, int64(1)))
+	s.Equal([]interface{}{1.0, 1.5, 2.0}, collect(1.0, int64(2), 0.5))
+	s.Nil(collect(int64(3), int64(1), int64(1)))
+	s.Nil(collect(int64(1), math.NaN(), int64(1)))
+
+	// loops near the integer limits do not overflow
+	maxInt := int64(math.MaxInt64)
+	s.Equal([]interface{}{maxInt - 1, maxInt}, collect(maxInt-1, maxInt, int64(1)))
+	s.Equal([]interface{}{maxInt - 2, maxInt}, collect(maxInt-2, math.Inf(1), int64(2)))
+	minInt := int64(math.MinInt64)
+	s.Equal([]interface{}{minInt + 1, minInt}, collect(minInt+1, minInt, int64(-1)))
+
+	_, err := number.NewForLoop(int64(1), int64(10), int64(0))
+	s.ErrorIs(err, number.ErrForStepZero)
+	_, err = number.NewForLoop(int64(1), "x", int64(1))
+	s.EqualError(err, "'for' limit must be a number")
+	_, err = number.NewForLoop(nil, int64(1), 1.0)
+	s.EqualError(err, "'for' initial value must be a number")
+	_, err = number.NewForLoop(1.0, int64(1), nil)
+	s.EqualError(err, "'for' step must be a number")
+}
@@ -1,10 +1,9 @@
 package optimizer
 
 import (
-	"math"
-
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/lexer"
+	"lua-interpreter/internal/number"
 )
 
 type (
@@ -45,11 +44,10 @@ func OptimizeBinary(opType lexer.TokenType) BinaryOptimizer {
 	case lexer.TokenKeywordAnd:
 		return optimizeAnd
 	case lexer.TokenPlus, lexer.TokenMinus, lexer.TokenMult, lexer.TokenDiv,
-		lexer.TokenIntDiv, lexer.TokenMod, lexer.TokenPower:
-		return optimizeArithmetic(opType)
-	case lexer.TokenBinAnd, lexer.TokenBinOr, lexer.TokenTilde,
+		lexer.TokenIntDiv, lexer.TokenMod, lexer.TokenPower,
+		lexer.TokenBinAnd, lexer.TokenBinOr, lexer.TokenTilde,
 		lexer.TokenShiftRight, lexer.TokenShiftLeft:
-		return optimizeBitOperations(opType)
+		return foldBinary(opType)
 	default:
 		return noBinaryOptimizer
 	}
@@ -63,7 +61,7 @@ func OptimizeUnary(opType lexer.TokenType) UnaryOptimizer {
 	switch opType {
 	case lexer.TokenMinus:
 		return optimizeUnaryMinus
-	case lexer.TokenNot:
+	case lexer.TokenKeywordNot:
 		return optimizeNot
 	case lexer.TokenTilde:
 		return optimizeBitNot
@@ -72,39 +70,40 @@ func OptimizeUnary(opType lexer.TokenType) UnaryOptimizer {
 	}
 }
 
-func optimizeBitOperations(opType lexer.TokenType) BinaryOptimizer {
+var binaryOperations = map[lexer.TokenType]number.Op{
+	lexer.TokenPlus:       number.OpAdd,
+	lexer.TokenMinus:      number.OpSub,
+	lexer.TokenMult:       number.OpMul,
+	lexer.TokenDiv:        number.OpDiv,
+	lexer.TokenIntDiv:     number.OpIDiv,
+	lexer.TokenMod:        number.OpMod,
+	lexer.TokenPower:      number.OpPow,
+	lexer.TokenBinAnd:     number.OpBAnd,
+	lexer.TokenBinOr:      number.OpBOr,
+	lexer.TokenTilde:      number.OpBXor,
+	lexer.TokenShiftLeft:  number.OpShl,
+	lexer.TokenShiftRight: number.OpShr,
+}
+
+// foldBinary folds an operation on two numerals. Operations that fail,
+// like bitwise operations on floats, are left to be raised at runtime
+// with the position of the expression.
+func foldBinary(opType lexer.TokenType) BinaryOptimizer {
 	return func(exp *ast.BinaryOperatorExpression) ast.Expression {
 		first, okFst := exp.Left.(*ast.NumeralExpression)
 		second, okSnd := exp.Right.(*ast.NumeralExpression)
-		if okFst && okSnd {
-			if math.Trunc(first.Value) != first.Value || math.Trunc(second.Value) != second.Value {
-				// todo: return an error instead of panic
-				panic("one of the operands has a non-integer value in bit operation")
-			}
-			a := int64(first.Value)
-			b := int64(second.Value)
-			switch opType {
-			case lexer.TokenBinAnd:
-				return &ast.NumeralExpression{Value: float64(a & b)}
-			case lexer.TokenBinOr:
-				return &ast.NumeralExpression{Value: float64(a | b)}
-			case lexer.TokenShiftLeft:
-				if b >= 0 {
-					return &ast.NumeralExpression{Value: float64(a << uint64(b))}
-				} else {
-					return &ast.NumeralExpression{Value: float64(a >> uint64(-b))}
-				}
-			case lexer.TokenShiftRight:
-				if b >= 0 {
-					return &ast.NumeralExpression{Value: float64(a >> uint64(b))}
-				} else {
-					return &ast.NumeralExpression{Value: float64(a << uint64(-b))}
-				}
-			default:
-				return exp
-			}
+		if !okFst || !okSnd {
+			return exp
 		}
-		return exp
+		op := binaryOperations[opType]
+		if (op == number.OpDiv || op == number.OpIDiv || op == number.OpMod) && number.Equal(second.Value, int64(0)) {
+			return exp
+		}
+		val, err := number.Arith(op, first.Value, second.Value)
+		if err != nil {
+			return exp
+		}
+		return &ast.NumeralExpression{Node: exp.Node, Value: val}
 	}
 }
 
@@ -130,71 +129,32 @@ func optimizeAnd(expr *ast.BinaryOperatorExpression) ast.Expression {
 	return expr
 }
 
-func optimizeArithmetic(opType lexer.TokenType) BinaryOptimizer {
-	return func(exp *ast.BinaryOperatorExpression) ast.Expression {
-		first, okFst := exp.Left.(*ast.NumeralExpression)
-		second, okSnd := exp.Right.(*ast.NumeralExpression)
-		if okFst && okSnd {
-			a := first.Value
-			b := second.Value
-			switch opType {
-			case lexer.TokenPlus:
-				return &ast.NumeralExpression{Value: a + b}
-			case lexer.TokenMinus:
-				return &ast.NumeralExpression{Value: a - b}
-			case lexer.TokenMult:
-				return &ast.NumeralExpression{Value: a * b}
-			case lexer.TokenDiv:
-				if b != 0 {
-					return &ast.NumeralExpression{Value: a / b}
-				}
-			case lexer.TokenIntDiv:
-				if b != 0 {
-					return &ast.NumeralExpression{Value: math.Floor(a / b)}
-				}
-			case lexer.TokenMod:
-				if b != 0 {
-					return &ast.NumeralExpression{Value: a - math.Floor(a/b)*b}
-				}
-			case lexer.TokenPower:
-				return &ast.NumeralExpression{Value: math.Pow(a, b)}
-			default:
-				return exp
-			}
-		}
-		return exp
-	}
-}
-
 func optimizeNot(exp *ast.UnaryOperatorExpression) ast.Expression {
 	if isTrue(exp.Expression) {
-		return &ast.BooleanExpression{Value: false}
+		return &ast.BooleanExpression{Node: exp.Node, Value: false}
 	} else if isFalse(exp.Expression) {
-		return &ast.BooleanExpression{Value: true}
+		return &ast.BooleanExpression{Node: exp.Node, Value: true}
 	}
 	return exp
 }
 
 func optimizeBitNot(exp *ast.UnaryOperatorExpression) ast.Expression {
-	switch e := exp.Expression.(type) {
-	case *ast.NumeralExpression:
-		if math.Trunc(e.Value) != e.Value {
-			// todo: return an error instead of panic
-			panic("operand has a non-integer value in bitwise NOT operation")
-		}
-		n := int64(e.Value)
-		return &ast.NumeralExpression{Value: float64(^n)}
-	default:
-		return exp
-	}
-	return exp
+	return foldUnary(number.OpBNot, exp)
 }
 
 func optimizeUnaryMinus(exp *ast.UnaryOperatorExpression) ast.Expression {
-	switch e := exp.Expression.(type) {
-	case *ast.NumeralExpression:
-		return &ast.NumeralExpression{Value: -e.Value}
-	default:
+	return foldUnary(number.OpUnm, exp)
+}
+
+func foldUnary(op number.Op, exp *ast.UnaryOperatorExpression) ast.Expression {
+	e, ok := exp.Expression.(*ast.NumeralExpression)
+	if !ok {
+		return exp
+	}
+	val, err := number.Arith(op, e.Value, e.Value)
+	if err != nil {
+		// not foldable: the error is raised at runtime with the position of the expression
 		return exp
 	}
+	return &ast.NumeralExpression{Node: exp.Node, Value: val}
 }
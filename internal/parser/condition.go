@@ -1,13 +1,12 @@
 package parser
 
 import (
-	"errors"
-
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/lexer"
 )
 
 func (p *Parser) parseIfStatement() (*ast.If, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	var (
 		exps   []ast.Expression
@@ -20,7 +19,7 @@ func (p *Parser) parseIfStatement() (*ast.If, error) {
 		}
 		exps = append(exps, exp)
 		if p.currentToken.Type != lexer.TokenKeywordThen {
-			return nil, errors.New("missing 'then' keyword")
+			return nil, p.errorf("missing 'then' keyword")
 		}
 		p.currentToken = p.lexer.NextToken()
 		block, err := p.parseBlock()
@@ -39,7 +38,7 @@ func (p *Parser) parseIfStatement() (*ast.If, error) {
 			}
 			blocks = append(blocks, block)
 			if p.currentToken.Type != lexer.TokenKeywordEnd {
-				return nil, errors.New("missing 'end' keyword")
+				return nil, p.errorf("missing 'end' keyword")
 			}
 			p.currentToken = p.lexer.NextToken()
 			break
@@ -47,8 +46,8 @@ func (p *Parser) parseIfStatement() (*ast.If, error) {
 			p.currentToken = p.lexer.NextToken()
 			break
 		} else {
-			return nil, errors.New("missing 'elseif', 'else' or 'end' keyword")
+			return nil, p.errorf("missing 'elseif', 'else' or 'end' keyword")
 		}
 	}
-	return &ast.If{Exps: exps, Blocks: blocks}, nil
+	return &ast.If{Node: node, Exps: exps, Blocks: blocks}, nil
 }
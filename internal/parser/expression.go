@@ -3,7 +3,6 @@ package parser
 import (
 	"errors"
 	"fmt"
-	"strconv"
 
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/lexer"
@@ -17,7 +16,7 @@ var (
 var (
 	UnaryOperators = []lexer.TokenType{
 		lexer.TokenMinus,
-		lexer.TokenNot,
+		lexer.TokenKeywordNot,
 		lexer.TokenTilde,
 		lexer.TokenHash,
 	}
@@ -82,32 +81,37 @@ func (p *Parser) parseExpression() (ast.Expression, error) {
 //	| functiondef | prefixexp | tableconstructor
 //	| opunary exp | exp binop exp
 func (p *Parser) parseExpressionBase() (ast.Expression, error) {
+	node := p.node()
 	switch p.currentToken.Type {
 	case lexer.TokenKeywordNil:
 		p.currentToken = p.lexer.NextToken()
-		return &ast.NilExpression{}, nil
+		return &ast.NilExpression{Node: node}, nil
 	case lexer.TokenKeywordFalse:
 		p.currentToken = p.lexer.NextToken()
-		return &ast.BooleanExpression{Value: false}, nil
+		return &ast.BooleanExpression{Node: node, Value: false}, nil
 	case lexer.TokenKeywordTrue:
 		p.currentToken = p.lexer.NextToken()
-		return &ast.BooleanExpression{Value: true}, nil
+		return &ast.BooleanExpression{Node: node, Value: true}, nil
 	case lexer.TokenNumeral:
-		str := p.currentToken.Value
+		token := p.currentToken
 		p.currentToken = p.lexer.NextToken()
-		// todo: parse hexadecimal numbers
-		num, err := strconv.ParseFloat(str, 64)
-		if err != nil {
-			return nil, err
+		if !token.Float {
+			if num, ok := lexer.ParseInteger(token.Value); ok {
+				return &ast.NumeralExpression{Node: node, Value: num}, nil
+			}
 		}
-		return &ast.NumeralExpression{Value: num}, nil
+		num, ok := lexer.ParseFloat(token.Value)
+		if !ok {
+			return nil, p.errorAt(node.Pos, fmt.Errorf("malformed number near '%s'", token.Value))
+		}
+		return &ast.NumeralExpression{Node: node, Value: num}, nil
 	case lexer.TokenLiteralString:
 		str := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
-		return &ast.LiteralString{Value: str}, nil
+		return &ast.LiteralString{Node: node, Value: str}, nil
 	case lexer.TokenTripleDot:
 		p.currentToken = p.lexer.NextToken()
-		return &ast.VarArgExpression{}, nil
+		return &ast.VarArgExpression{Node: node}, nil
 	case lexer.TokenKeywordFunction:
 		return p.parseFunctionDefinition()
 	case lexer.TokenIdentifier, lexer.TokenLeftParen:
@@ -115,36 +119,38 @@ func (p *Parser) parseExpressionBase() (ast.Expression, error) {
 	case lexer.TokenLeftBrace:
 		return p.parseTableConstructor()
 	default:
-		return nil, fmt.Errorf("unexpected token: [%s] %s", p.currentToken.Type, p.currentToken.Value)
+		return nil, p.unexpected()
 	}
 }
 
+// tableconstructor ::= ‘{’ [fieldlist] ‘}’
+// fieldlist ::= field {fieldsep field} [fieldsep]
+// fieldsep ::= ‘,’ | ‘;’
 func (p *Parser) parseTableConstructor() (*ast.TableConstructorExpression, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	var fields []ast.Field
 	for p.currentToken.Type != lexer.TokenRightBrace {
-		if p.currentToken.Type == lexer.TokenComma {
-			p.currentToken = p.lexer.NextToken()
-			continue
-		}
 		field, err := p.parseField()
 		if err != nil {
 			return nil, err
 		}
 		fields = append(fields, field)
-		if p.currentToken.Type == lexer.TokenRightBrace {
-			p.currentToken = p.lexer.NextToken()
+		if p.currentToken.Type != lexer.TokenComma && p.currentToken.Type != lexer.TokenSemiColon {
 			break
-		} else if p.currentToken.Type != lexer.TokenComma {
-			return nil, errors.New("expected ',' or '}'")
 		}
 		p.currentToken = p.lexer.NextToken()
 	}
-	return &ast.TableConstructorExpression{Fields: fields}, nil
+	if p.currentToken.Type != lexer.TokenRightBrace {
+		return nil, p.errorf("expected ',' or '}'")
+	}
+	p.currentToken = p.lexer.NextToken()
+	return &ast.TableConstructorExpression{Node: node, Fields: fields}, nil
 }
 
 // field ::= ‘[’ exp ‘]’ ‘=’ exp | Name ‘=’ exp | exp
 func (p *Parser) parseField() (ast.Field, error) {
+	node := p.node()
 	switch p.currentToken.Type {
 	case lexer.TokenLeftBracket:
 		p.currentToken = p.lexer.NextToken()
@@ -153,27 +159,28 @@ func (p *Parser) parseField() (ast.Field, error) {
 			return nil, err
 		}
 		if p.currentToken.Type != lexer.TokenRightBracket {
-			return nil, errors.New("missing ']'")
+			return nil, p.errorf("missing ']'")
 		}
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenAssign {
-			return nil, errors.New("missing '='")
+			return nil, p.errorf("missing '='")
 		}
 		p.currentToken = p.lexer.NextToken()
 		value, err := p.parseExpression()
 		if err != nil {
 			return nil, err
 		}
-		return &ast.ExpToExpField{Key: key, Value: value}, nil
+		return &ast.ExpToExpField{Node: node, Key: key, Value: value}, nil
 	case lexer.TokenIdentifier:
 		name := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenAssign {
-			exp, err := p.parsePrefixExpressionTail(&ast.NameVar{Name: name})
+			exp, err := p.parsePrefixExpressionTail(&ast.NameVar{Node: node, Name: name})
 			if err != nil {
 				return nil, err
 			}
 			return &ast.ExpressionField{
+				Node:  node,
 				Value: exp,
 			}, nil
 		}
@@ -182,13 +189,13 @@ func (p *Parser) parseField() (ast.Field, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &ast.NameField{Name: name, Value: value}, nil
+		return &ast.NameField{Node: node, Name: name, Value: value}, nil
 	default:
 		exp, err := p.parseExpression()
 		if err != nil {
 			return nil, err
 		}
-		return &ast.ExpressionField{Value: exp}, nil
+		return &ast.ExpressionField{Node: node, Value: exp}, nil
 	}
 }
 
@@ -207,6 +214,7 @@ func (p *Parser) parseBinaryExp(next func() (ast.Expression, error), tokenTypes
 			}
 			exp = optimizer.OptimizeBinary(op.Type)(
 				&ast.BinaryOperatorExpression{
+					Node:     ast.Node{Pos: positionOf(exp)},
 					Operator: op,
 					Left:     exp,
 					Right:    right,
@@ -228,6 +236,7 @@ func (p *Parser) parseUnaryExp(next func() (ast.Expression, error), tokenTypes .
 			}
 			return optimizer.OptimizeUnary(op.Type)(
 				&ast.UnaryOperatorExpression{
+					Node:       ast.Node{Pos: op.Pos},
 					Operator:   op,
 					Expression: exp,
 				},
@@ -245,3 +254,11 @@ func isOneOfTypes(tokenType lexer.TokenType, tokenTypes []lexer.TokenType) bool
 	}
 	return false
 }
+
+// positionOf returns the position of the first token of an expression.
+func positionOf(exp ast.Expression) lexer.Position {
+	if n, ok := exp.(ast.Positioned); ok {
+		return n.Position()
+	}
+	return lexer.Position{}
+}
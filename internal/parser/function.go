@@ -1,31 +1,36 @@
 package parser
 
 import (
-	"errors"
-
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/lexer"
 )
 
 func (p *Parser) parseFunction() (*ast.Function, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	if p.currentToken.Type != lexer.TokenIdentifier {
-		return nil, errors.New("missing identifier")
+		return nil, p.errorf("missing identifier")
 	}
 	name, err := p.parseFunctionName()
 	if err != nil {
 		return nil, err
 	}
+	if len(name.PrefixNames) == 0 {
+		// function f() assigns the variable f
+		if err := p.checkAssignable(&ast.NameVar{Node: node, Name: name.Name}); err != nil {
+			return nil, err
+		}
+	}
 	body, err := p.parseFunctionBody()
 	if err != nil {
 		return nil, err
 	}
-	return &ast.Function{FunctionName: name, FuncBody: body}, nil
+	return &ast.Function{Node: node, FunctionName: name, FuncBody: body}, nil
 }
 
 func (p *Parser) parseFunctionName() (ast.FunctionName, error) {
 	if p.currentToken.Type != lexer.TokenIdentifier {
-		return ast.FunctionName{}, errors.New("missing identifier")
+		return ast.FunctionName{}, p.errorf("missing identifier")
 	}
 	isMethod := false
 	lastName := p.currentToken.Value
@@ -35,7 +40,7 @@ func (p *Parser) parseFunctionName() (ast.FunctionName, error) {
 		names = append(names, lastName)
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenIdentifier {
-			return ast.FunctionName{}, errors.New("missing identifier")
+			return ast.FunctionName{}, p.errorf("missing identifier")
 		}
 		lastName = p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
@@ -45,7 +50,7 @@ func (p *Parser) parseFunctionName() (ast.FunctionName, error) {
 		names = append(names, lastName)
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenIdentifier {
-			return ast.FunctionName{}, errors.New("missing identifier")
+			return ast.FunctionName{}, p.errorf("missing identifier")
 		}
 		lastName = p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
@@ -55,23 +60,32 @@ func (p *Parser) parseFunctionName() (ast.FunctionName, error) {
 
 func (p *Parser) parseFunctionBody() (ast.FunctionBody, error) {
 	if p.currentToken.Type != lexer.TokenLeftParen {
-		return ast.FunctionBody{}, errors.New("missing '('")
+		return ast.FunctionBody{}, p.errorf("missing '('")
 	}
 	p.currentToken = p.lexer.NextToken()
 	parList, err := p.parseParameterList()
 	if err != nil {
 		return ast.FunctionBody{}, err
 	}
+	defer p.enterFunction()()
+	p.openScope()
+	defer p.closeScope()
+	for _, name := range parList.Names {
+		p.declareLocal(name, "")
+	}
 	if p.currentToken.Type != lexer.TokenRightParen {
-		return ast.FunctionBody{}, errors.New("missing ')'")
+		return ast.FunctionBody{}, p.errorf("missing ')'")
 	}
 	p.currentToken = p.lexer.NextToken()
 	block, err := p.parseBlock()
 	if err != nil {
 		return ast.FunctionBody{}, err
 	}
+	if err := p.checkGotos(); err != nil {
+		return ast.FunctionBody{}, err
+	}
 	if p.currentToken.Type != lexer.TokenKeywordEnd {
-		return ast.FunctionBody{}, errors.New("missing 'end' keyword")
+		return ast.FunctionBody{}, p.errorf("missing 'end' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
 	return ast.FunctionBody{ParameterList: parList, Block: block}, nil
@@ -79,14 +93,15 @@ func (p *Parser) parseFunctionBody() (ast.FunctionBody, error) {
 
 func (p *Parser) parseFunctionDefinition() (*ast.FunctionDefinition, error) {
 	if p.currentToken.Type != lexer.TokenKeywordFunction {
-		return nil, errors.New("missing 'function' keyword")
+		return nil, p.errorf("missing 'function' keyword")
 	}
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	body, err := p.parseFunctionBody()
 	if err != nil {
 		return nil, err
 	}
-	return &ast.FunctionDefinition{FunctionBody: body}, nil
+	return &ast.FunctionDefinition{Node: node, FunctionBody: body}, nil
 }
 
 // parlist ::= namelist [‘,’ ‘...’] | ‘...’
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"fmt"
+
+	"lua-interpreter/internal/ast"
+)
+
+// declareLabels declares labels that follow each other in the current block.
+// Labels at the end of a block are outside the scope of its locals,
+// so that a goto can skip them to leave the block.
+func (p *Parser) declareLabels(labels []*ast.Label, atEnd bool) error {
+	for _, l := range labels {
+		if err := p.declareLabel(l, atEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declareLabel makes a label visible and resolves the pending gotos
+// of the current block that jump to it.
+func (p *Parser) declareLabel(l *ast.Label, atEnd bool) error {
+	for _, prev := range p.labels[p.fn.nLabels:] {
+		if prev.name == l.Name {
+			return p.errorAt(l.Pos, fmt.Errorf("label '%s' already defined on line %d", l.Name, prev.pos.Line))
+		}
+	}
+	b := p.blocks[len(p.blocks)-1]
+	nLocals := len(p.locals)
+	if atEnd {
+		nLocals = b.nLocals
+	}
+	p.labels = append(p.labels, labelDesc{name: l.Name, pos: l.Pos, nLocals: nLocals})
+
+	pending := p.gotos[:b.nGotos]
+	for _, g := range p.gotos[b.nGotos:] {
+		if g.name != l.Name {
+			pending = append(pending, g)
+			continue
+		}
+		if g.nLocals < nLocals {
+			return p.errorAt(g.pos, fmt.Errorf("<goto %s> at line %d jumps into the scope of local '%s'",
+				g.name, g.pos.Line, p.locals[g.nLocals].name))
+		}
+	}
+	p.gotos = pending
+	return nil
+}
+
+// declareGoto resolves a goto to a visible label, a backward jump,
+// or keeps it pending until its label is declared.
+func (p *Parser) declareGoto(g *ast.Goto) {
+	for _, l := range p.labels[p.fn.nLabels:] {
+		if l.name == g.Name {
+			return
+		}
+	}
+	p.gotos = append(p.gotos, gotoDesc{name: g.Name, pos: g.Pos, nLocals: len(p.locals)})
+}
+
+// checkGotos reports a goto of the current function left without a label.
+func (p *Parser) checkGotos() error {
+	if len(p.gotos) > p.fn.nGotos {
+		g := p.gotos[p.fn.nGotos]
+		return p.errorAt(g.pos, fmt.Errorf("no visible label '%s' for <goto> at line %d", g.name, g.pos.Line))
+	}
+	return nil
+}
+
+// enterFunction starts the labels and gotos of a function body
+// and returns the function that restores the enclosing function.
+func (p *Parser) enterFunction() func() {
+	outer := p.fn
+	p.fn = funcScope{nLabels: len(p.labels), nGotos: len(p.gotos)}
+	return func() {
+		p.fn = outer
+	}
+}
+
+// parseLoopBlock parses the body of a loop, where break is allowed.
+func (p *Parser) parseLoopBlock() (ast.Block, error) {
+	p.fn.loops++
+	defer func() { p.fn.loops-- }()
+	return p.parseBlock()
+}
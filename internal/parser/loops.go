@@ -1,61 +1,62 @@
 package parser
 
 import (
-	"errors"
-
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/lexer"
 )
 
 func (p *Parser) parseWhileStatement() (*ast.While, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	exp, err := p.parseExpression()
 	if err != nil {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenKeywordDo {
-		return nil, errors.New("missing 'do' keyword")
+		return nil, p.errorf("missing 'do' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
-	block, err := p.parseBlock()
+	block, err := p.parseLoopBlock()
 	if err != nil {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenKeywordEnd {
-		return nil, errors.New("missing 'end' keyword")
+		return nil, p.errorf("missing 'end' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
-	return &ast.While{Exp: exp, Block: block}, nil
+	return &ast.While{Node: node, Exp: exp, Block: block}, nil
 }
 
 func (p *Parser) parseRepeatStatement() (*ast.Repeat, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
-	block, err := p.parseBlock()
+	block, err := p.parseLoopBlock()
 	if err != nil {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenKeywordUntil {
-		return nil, errors.New("missing 'until' keyword")
+		return nil, p.errorf("missing 'until' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
 	exp, err := p.parseExpression()
 	if err != nil {
 		return nil, err
 	}
-	return &ast.Repeat{Block: block, Exp: exp}, nil
+	return &ast.Repeat{Node: node, Block: block, Exp: exp}, nil
 }
 
 func (p *Parser) parseForStatement() (ast.Statement, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	if p.currentToken.Type != lexer.TokenIdentifier {
-		return nil, errors.New("missing identifier")
+		return nil, p.errorf("missing identifier")
 	}
 	name := p.currentToken.Value
 	p.currentToken = p.lexer.NextToken()
 	names := []string{name}
 	switch p.currentToken.Type {
 	case lexer.TokenAssign:
-		return p.parseForStatementWithName(name)
+		return p.parseForStatementWithName(node, name)
 	case lexer.TokenComma:
 		p.currentToken = p.lexer.NextToken()
 		nl, err := p.parseNameList()
@@ -65,15 +66,15 @@ func (p *Parser) parseForStatement() (ast.Statement, error) {
 		names = append(names, nl...)
 		fallthrough
 	case lexer.TokenKeywordIn:
-		return p.parseForInWithNames(names)
+		return p.parseForInWithNames(node, names)
 	default:
-		return nil, errors.New("expected '=' or 'in' keyword")
+		return nil, p.errorf("expected '=' or 'in' keyword")
 	}
 }
 
-func (p *Parser) parseForStatementWithName(name string) (*ast.For, error) {
+func (p *Parser) parseForStatementWithName(node ast.Node, name string) (*ast.For, error) {
 	if p.currentToken.Type != lexer.TokenAssign {
-		return nil, errors.New("missing '='")
+		return nil, p.errorf("missing '='")
 	}
 	p.currentToken = p.lexer.NextToken()
 	init, err := p.parseExpression()
@@ -81,7 +82,7 @@ func (p *Parser) parseForStatementWithName(name string) (*ast.For, error) {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenComma {
-		return nil, errors.New("missing ','")
+		return nil, p.errorf("missing ','")
 	}
 	p.currentToken = p.lexer.NextToken()
 	limit, err := p.parseExpression()
@@ -98,23 +99,26 @@ func (p *Parser) parseForStatementWithName(name string) (*ast.For, error) {
 		step = stepExp
 	}
 	if p.currentToken.Type != lexer.TokenKeywordDo {
-		return nil, errors.New("missing 'do' keyword")
+		return nil, p.errorf("missing 'do' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
-	block, err := p.parseBlock()
+	p.openScope()
+	p.declareLocal(name, "")
+	block, err := p.parseLoopBlock()
+	p.closeScope()
 	if err != nil {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenKeywordEnd {
-		return nil, errors.New("missing 'end' keyword")
+		return nil, p.errorf("missing 'end' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
-	return &ast.For{Name: name, Init: init, Limit: limit, Step: step, Block: block}, nil
+	return &ast.For{Node: node, Name: name, Init: init, Limit: limit, Step: step, Block: block}, nil
 }
 
-func (p *Parser) parseForInWithNames(names []string) (*ast.ForIn, error) {
+func (p *Parser) parseForInWithNames(node ast.Node, names []string) (*ast.ForIn, error) {
 	if p.currentToken.Type != lexer.TokenKeywordIn {
-		return nil, errors.New("missing 'in' keyword")
+		return nil, p.errorf("missing 'in' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
 	exps, err := p.parseExpressionList()
@@ -122,16 +126,21 @@ func (p *Parser) parseForInWithNames(names []string) (*ast.ForIn, error) {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenKeywordDo {
-		return nil, errors.New("missing 'do' keyword")
+		return nil, p.errorf("missing 'do' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
-	block, err := p.parseBlock()
+	p.openScope()
+	for _, name := range names {
+		p.declareLocal(name, "")
+	}
+	block, err := p.parseLoopBlock()
+	p.closeScope()
 	if err != nil {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenKeywordEnd {
-		return nil, errors.New("missing 'end' keyword")
+		return nil, p.errorf("missing 'end' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
-	return &ast.ForIn{Names: names, Exps: exps, Block: block}, nil
+	return &ast.ForIn{Node: node, Names: names, Exps: exps, Block: block}, nil
 }
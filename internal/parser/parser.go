@@ -2,6 +2,8 @@
 package parser
 
 import (
+	"fmt"
+
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/lexer"
 )
@@ -16,21 +18,68 @@ type (
 	Parser struct {
 		lexer        scanner
 		currentToken lexer.Token
+		// chunk is the name of the parsed chunk used in error messages
+		chunk string
+		// locals are the local variables in scope, innermost last,
+		// and blocks holds the open blocks, innermost last
+		locals []localVar
+		blocks []blockScope
+		// labels are the visible labels and gotos the goto statements
+		// waiting for their label, in the order they were parsed
+		labels []labelDesc
+		gotos  []gotoDesc
+		// fn is where the current function starts
+		fn funcScope
+	}
+	localVar struct {
+		name   string
+		attrib string
+	}
+	// blockScope is where a block starts in the locals, labels and gotos.
+	blockScope struct {
+		nLocals, nLabels, nGotos int
+	}
+	// funcScope is where a function starts in the labels and gotos;
+	// loops is the number of loops of the function around the current statement.
+	funcScope struct {
+		nLabels, nGotos int
+		loops           int
+	}
+	labelDesc struct {
+		name string
+		pos  lexer.Position
+		// nLocals is the number of locals in scope at the label
+		nLocals int
+	}
+	gotoDesc struct {
+		name    string
+		pos     lexer.Position
+		nLocals int
 	}
 )
 
-func New(lexer scanner) *Parser {
+func New(lexer scanner, chunk string) *Parser {
 	return &Parser{
 		lexer: lexer,
+		chunk: chunk,
 	}
 }
 
 func (p *Parser) Parse() (ast.Block, error) {
 	p.currentToken = p.lexer.NextToken()
-	return p.parseBlock()
+	block, err := p.parseBlock()
+	if err != nil {
+		return ast.Block{}, err
+	}
+	if err := p.checkGotos(); err != nil {
+		return ast.Block{}, err
+	}
+	return block, nil
 }
 
 func (p *Parser) parseBlock() (b ast.Block, err error) {
+	p.openScope()
+	defer p.closeScope()
 	b.Statements, err = p.parseStatements()
 	if err != nil {
 		return ast.Block{}, err
@@ -44,15 +93,32 @@ func (p *Parser) parseBlock() (b ast.Block, err error) {
 
 func (p *Parser) parseStatements() ([]ast.Statement, error) {
 	var statements []ast.Statement
+	// labels are declared before the next statement that is not a label or ';',
+	// when it is known whether they end the block
+	var labels []*ast.Label
 	for p.currentToken.Type != lexer.TokenEOF && p.currentToken.Type != lexer.TokenKeywordReturn &&
 		p.currentToken.Type != lexer.TokenKeywordEnd && p.currentToken.Type != lexer.TokenKeywordElse &&
 		p.currentToken.Type != lexer.TokenKeywordElseIf && p.currentToken.Type != lexer.TokenKeywordUntil {
+		if p.currentToken.Type != lexer.TokenSemiColon && p.currentToken.Type != lexer.TokenDoubleColon {
+			if err := p.declareLabels(labels, false); err != nil {
+				return nil, err
+			}
+			labels = nil
+		}
 		stat, err := p.parseStatement()
 		if err != nil {
 			return nil, err
 		}
+		if label, ok := stat.(*ast.Label); ok {
+			labels = append(labels, label)
+		}
 		statements = append(statements, stat)
 	}
+	// the locals of a repeat block are still in scope in its condition
+	atEnd := p.currentToken.Type != lexer.TokenKeywordReturn && p.currentToken.Type != lexer.TokenKeywordUntil
+	if err := p.declareLabels(labels, atEnd); err != nil {
+		return nil, err
+	}
 	return statements, nil
 }
 
@@ -60,12 +126,14 @@ func (p *Parser) parseReturnStatement() (*ast.ReturnStatement, error) {
 	if p.currentToken.Type != lexer.TokenKeywordReturn {
 		return nil, nil
 	}
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	expressions, err := p.parseExpressionList()
 	if err != nil {
 		return nil, err
 	}
 	return &ast.ReturnStatement{
+		Node:        node,
 		Expressions: expressions,
 	}, nil
 }
@@ -89,3 +157,70 @@ func (p *Parser) parseExpressionList() (exps []ast.Expression, err error) {
 	}
 	return exps, nil
 }
+
+// openScope starts a scope for local variables and labels.
+func (p *Parser) openScope() {
+	p.blocks = append(p.blocks, blockScope{nLocals: len(p.locals), nLabels: len(p.labels), nGotos: len(p.gotos)})
+}
+
+// closeScope drops the local variables and labels of the innermost scope.
+// Its pending gotos are left to the enclosing scope, outside the scope
+// of the dropped locals.
+func (p *Parser) closeScope() {
+	b := p.blocks[len(p.blocks)-1]
+	for i := b.nGotos; i < len(p.gotos); i++ {
+		p.gotos[i].nLocals = min(p.gotos[i].nLocals, b.nLocals)
+	}
+	p.locals = p.locals[:b.nLocals]
+	p.labels = p.labels[:b.nLabels]
+	p.blocks = p.blocks[:len(p.blocks)-1]
+}
+
+// declareLocal brings a local variable into scope.
+func (p *Parser) declareLocal(name, attrib string) {
+	p.locals = append(p.locals, localVar{name: name, attrib: attrib})
+}
+
+// checkAssignable rejects assignments to const and to-be-closed variables.
+func (p *Parser) checkAssignable(v ast.Var) error {
+	nameVar, ok := v.(*ast.NameVar)
+	if !ok {
+		return nil
+	}
+	for i := len(p.locals) - 1; i >= 0; i-- {
+		if p.locals[i].name == nameVar.Name {
+			if p.locals[i].attrib != "" {
+				return p.errorAt(nameVar.Pos, fmt.Errorf("attempt to assign to const variable '%s'", nameVar.Name))
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// node returns the syntax tree node position of the current token.
+func (p *Parser) node() ast.Node {
+	return ast.Node{Pos: p.currentToken.Pos}
+}
+
+// errorf returns an error positioned at the current token.
+func (p *Parser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.currentToken.Pos, fmt.Errorf(format, args...))
+}
+
+func (p *Parser) errorAt(pos lexer.Position, err error) error {
+	return ast.NewError(p.chunk, pos, err)
+}
+
+// unexpected reports the current token as unexpected.
+// Lexical errors carry their own description in the token value.
+func (p *Parser) unexpected() error {
+	switch p.currentToken.Type {
+	case lexer.TokenError:
+		return p.errorf("%s", p.currentToken.Value)
+	case lexer.TokenEOF:
+		return p.errorf("unexpected token: <eof>")
+	default:
+		return p.errorf("unexpected token: %s", p.currentToken.Value)
+	}
+}
@@ -27,7 +27,7 @@ func (s *ParserSuite) SetupTest() {
 	ctrl := gomock.NewController(s.T())
 	s.scanner = mock.NewMockscanner(ctrl)
 
-	s.parser = parser.New(s.scanner)
+	s.parser = parser.New(s.scanner, "test")
 }
 
 func (s *ParserSuite) TestParseLocalVarDeclaration() {
@@ -46,7 +46,7 @@ func (s *ParserSuite) TestParseLocalVarDeclaration() {
 	s.Len(localVarDecl.Exps, 1)
 	s.Equal("a", localVarDecl.Vars[0])
 	s.IsType(&ast.NumeralExpression{}, localVarDecl.Exps[0])
-	s.Equal(10.0, localVarDecl.Exps[0].(*ast.NumeralExpression).Value)
+	s.Equal(int64(10), localVarDecl.Exps[0].(*ast.NumeralExpression).Value)
 }
 
 func (s *ParserSuite) TestParseFunctionCall() {
@@ -76,7 +76,24 @@ func (s *ParserSuite) TestParseReturnStatement() {
 	s.NotNil(block.ReturnStatement)
 	s.Len(block.ReturnStatement.Expressions, 1)
 	s.IsType(&ast.NumeralExpression{}, block.ReturnStatement.Expressions[0])
-	s.Equal(42.0, block.ReturnStatement.Expressions[0].(*ast.NumeralExpression).Value)
+	s.Equal(int64(42), block.ReturnStatement.Expressions[0].(*ast.NumeralExpression).Value)
+}
+
+func (s *ParserSuite) TestParseParenthesizedCall() {
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenKeywordReturn, Value: "return"}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenLeftParen, Value: "("}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenIdentifier, Value: "f"}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenLeftParen, Value: "("}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenRightParen, Value: ")"}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenRightParen, Value: ")"}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenEOF, Value: ""}).Times(1)
+
+	block, err := s.parser.Parse()
+	s.NoError(err)
+	s.NotNil(block.ReturnStatement)
+	s.Len(block.ReturnStatement.Expressions, 1)
+	s.IsType(&ast.ParenExpression{}, block.ReturnStatement.Expressions[0])
+	s.IsType(&ast.FunctionCall{}, block.ReturnStatement.Expressions[0].(*ast.ParenExpression).Exp)
 }
 
 func (s *ParserSuite) TestParseFunction() {
@@ -122,7 +139,7 @@ func (s *ParserSuite) TestParseTableConstructor() {
 	field := tableExpr.Fields[0].(*ast.NameField)
 	s.Equal("key", field.Name)
 	s.IsType(&ast.NumeralExpression{}, field.Value)
-	s.Equal(42.0, field.Value.(*ast.NumeralExpression).Value)
+	s.Equal(int64(42), field.Value.(*ast.NumeralExpression).Value)
 }
 
 func (s *ParserSuite) TestParseArithmeticOptimizations() {
@@ -145,7 +162,7 @@ func (s *ParserSuite) TestParseArithmeticOptimizations() {
 	s.Equal("result", assignment.Vars[0].(*ast.NameVar).Name)
 	s.Len(assignment.Exps, 1)
 	s.IsType(&ast.NumeralExpression{}, assignment.Exps[0])
-	s.Equal(11.0, assignment.Exps[0].(*ast.NumeralExpression).Value)
+	s.Equal(int64(11), assignment.Exps[0].(*ast.NumeralExpression).Value)
 }
 
 func (s *ParserSuite) TestParseIfStatement() {
@@ -174,7 +191,7 @@ func (s *ParserSuite) TestParseIfStatement() {
 	s.Equal("x", binaryExpr.Left.(*ast.NameVar).Name)
 	s.Equal("==", binaryExpr.Operator.Value)
 	s.IsType(&ast.NumeralExpression{}, binaryExpr.Right)
-	s.Equal(float64(10), binaryExpr.Right.(*ast.NumeralExpression).Value)
+	s.Equal(int64(10), binaryExpr.Right.(*ast.NumeralExpression).Value)
 	s.Len(ifStmt.Blocks[0].Statements, 1)
 }
 
@@ -202,7 +219,7 @@ func (s *ParserSuite) TestParseWhileLoop() {
 	s.Equal("x", binaryExpr.Left.(*ast.NameVar).Name)
 	s.Equal("<", binaryExpr.Operator.Value)
 	s.IsType(&ast.NumeralExpression{}, binaryExpr.Right)
-	s.Equal(float64(10), binaryExpr.Right.(*ast.NumeralExpression).Value)
+	s.Equal(int64(10), binaryExpr.Right.(*ast.NumeralExpression).Value)
 	s.Len(whileStmt.Block.Statements, 1)
 	assignment := whileStmt.Block.Statements[0]
 	s.IsType(&ast.Assignment{}, assignment)
@@ -216,7 +233,7 @@ func (s *ParserSuite) TestParseWhileLoop() {
 	s.Equal("x", binaryExp.Left.(*ast.NameVar).Name)
 	s.Equal("+", binaryExp.Operator.Value)
 	s.IsType(&ast.NumeralExpression{}, binaryExp.Right)
-	s.Equal(float64(1), binaryExp.Right.(*ast.NumeralExpression).Value)
+	s.Equal(int64(1), binaryExp.Right.(*ast.NumeralExpression).Value)
 }
 
 func (s *ParserSuite) TestParseForLoop() {
@@ -241,9 +258,9 @@ func (s *ParserSuite) TestParseForLoop() {
 	forStmt := block.Statements[0].(*ast.For)
 	s.Equal("i", forStmt.Name)
 	s.IsType(&ast.NumeralExpression{}, forStmt.Init)
-	s.Equal(float64(1), forStmt.Init.(*ast.NumeralExpression).Value)
+	s.Equal(int64(1), forStmt.Init.(*ast.NumeralExpression).Value)
 	s.IsType(&ast.NumeralExpression{}, forStmt.Limit)
-	s.Equal(float64(10), forStmt.Limit.(*ast.NumeralExpression).Value)
+	s.Equal(int64(10), forStmt.Limit.(*ast.NumeralExpression).Value)
 	stat := forStmt.Block.Statements[0]
 	s.IsType(&ast.FunctionCall{}, stat)
 	funcCall := stat.(*ast.FunctionCall)
@@ -314,12 +331,58 @@ func (s *ParserSuite) TestParseLabel() {
 func (s *ParserSuite) TestParseGoto() {
 	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenKeywordGoTo, Value: "goto"}).Times(1)
 	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenIdentifier, Value: "myLabel"}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenDoubleColon, Value: "::"}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenIdentifier, Value: "myLabel"}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenDoubleColon, Value: "::"}).Times(1)
 	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenEOF, Value: ""}).Times(1)
 
 	block, err := s.parser.Parse()
 	s.NoError(err)
-	s.Len(block.Statements, 1)
+	s.Len(block.Statements, 2)
 	s.IsType(&ast.Goto{}, block.Statements[0])
 	gotoStmt := block.Statements[0].(*ast.Goto)
 	s.Equal("myLabel", gotoStmt.Name)
 }
+
+func (s *ParserSuite) TestParseErrorPosition() {
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenKeywordWhile, Value: "while", Pos: lexer.Position{Line: 3, Column: 1, Offset: 20},
+	}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenIdentifier, Value: "x", Pos: lexer.Position{Line: 3, Column: 7, Offset: 26},
+	}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenKeywordThen, Value: "then", Pos: lexer.Position{Line: 3, Column: 9, Offset: 28},
+	}).Times(1)
+
+	_, err := s.parser.Parse()
+	s.Error(err)
+	s.Equal("test:3:9: missing 'do' keyword", err.Error())
+}
+
+func (s *ParserSuite) TestParseNodePositions() {
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenIdentifier, Value: "x", Pos: lexer.Position{Line: 2, Column: 5, Offset: 10},
+	}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenAssign, Value: "=", Pos: lexer.Position{Line: 2, Column: 7, Offset: 12},
+	}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenIdentifier, Value: "y", Pos: lexer.Position{Line: 2, Column: 9, Offset: 14},
+	}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenPlus, Value: "+", Pos: lexer.Position{Line: 2, Column: 11, Offset: 16},
+	}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{
+		Type: lexer.TokenNumeral, Value: "1", Pos: lexer.Position{Line: 2, Column: 13, Offset: 18},
+	}).Times(1)
+	s.scanner.EXPECT().NextToken().Return(lexer.Token{Type: lexer.TokenEOF, Value: ""}).Times(1)
+
+	block, err := s.parser.Parse()
+	s.NoError(err)
+	assignment := block.Statements[0].(*ast.Assignment)
+	s.Equal(lexer.Position{Line: 2, Column: 5, Offset: 10}, assignment.Position())
+	binaryExp := assignment.Exps[0].(*ast.BinaryOperatorExpression)
+	s.Equal(lexer.Position{Line: 2, Column: 9, Offset: 14}, binaryExp.Position())
+	s.Equal(lexer.Position{Line: 2, Column: 13, Offset: 18}, binaryExp.Right.(*ast.NumeralExpression).Position())
+}
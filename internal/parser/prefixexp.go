@@ -61,6 +61,7 @@ func (p *Parser) parsePrefixExpressionTail(head ast.PrefixExpression) (prefix as
 }
 
 func (p *Parser) parsePrefixExpressionStep(prefix ast.PrefixExpression) (ast.PrefixExpression, error) {
+	node := ast.Node{Pos: positionOf(prefix)}
 	switch p.currentToken.Type {
 	case lexer.TokenLeftBracket:
 		p.currentToken = p.lexer.NextToken()
@@ -69,18 +70,18 @@ func (p *Parser) parsePrefixExpressionStep(prefix ast.PrefixExpression) (ast.Pre
 			return nil, err
 		}
 		if p.currentToken.Type != lexer.TokenRightBracket {
-			return nil, errors.New("missing ']'")
+			return nil, p.errorf("missing ']'")
 		}
 		p.currentToken = p.lexer.NextToken()
-		return &ast.IndexedVar{PrefixExp: prefix, Exp: exp}, nil
+		return &ast.IndexedVar{Node: node, PrefixExp: prefix, Exp: exp}, nil
 	case lexer.TokenDot:
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenIdentifier {
-			return nil, errors.New("missing identifier after '.'")
+			return nil, p.errorf("missing identifier after '.'")
 		}
 		name := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
-		return &ast.MemberVar{PrefixExp: prefix, Name: name}, nil
+		return &ast.MemberVar{Node: node, PrefixExp: prefix, Name: name}, nil
 	case lexer.TokenLeftParen, lexer.TokenColon, lexer.TokenLiteralString, lexer.TokenLeftBrace:
 		funcCall, err := p.parseFunctionCallPostfix(prefix)
 		if err != nil {
@@ -115,13 +116,16 @@ func (p *Parser) parseFunctionCall() (ast.PrefixExpression, error) {
 // This function is called when we already have a prefix expression
 // and we want to parse the function call postfix (args or ':' Name args)
 func (p *Parser) parseFunctionCallPostfix(prefixExp ast.PrefixExpression) (*ast.FunctionCall, error) {
+	node := ast.Node{Pos: positionOf(prefixExp)}
 	if p.currentToken.Type == lexer.TokenLiteralString {
 		str := p.currentToken.Value
+		strNode := p.node()
 		p.currentToken = p.lexer.NextToken()
 		return &ast.FunctionCall{
+			Node:      node,
 			PrefixExp: prefixExp,
 			Name:      "",
-			Args:      &ast.LiteralString{Value: str},
+			Args:      &ast.LiteralString{Node: strNode, Value: str},
 		}, nil
 	} else if p.currentToken.Type == lexer.TokenLeftBrace {
 		args, err := p.parseTableConstructor()
@@ -129,6 +133,7 @@ func (p *Parser) parseFunctionCallPostfix(prefixExp ast.PrefixExpression) (*ast.
 			return nil, err
 		}
 		return &ast.FunctionCall{
+			Node:      node,
 			PrefixExp: prefixExp,
 			Name:      "",
 			Args:      args,
@@ -136,7 +141,7 @@ func (p *Parser) parseFunctionCallPostfix(prefixExp ast.PrefixExpression) (*ast.
 	} else if p.currentToken.Type == lexer.TokenColon {
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenIdentifier {
-			return nil, errors.New("missing identifier after ':'")
+			return nil, p.errorf("missing identifier after ':'")
 		}
 		name := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
@@ -145,6 +150,7 @@ func (p *Parser) parseFunctionCallPostfix(prefixExp ast.PrefixExpression) (*ast.
 			return nil, err
 		}
 		return &ast.FunctionCall{
+			Node:      node,
 			PrefixExp: prefixExp,
 			Name:      name,
 			Args:      args,
@@ -155,21 +161,23 @@ func (p *Parser) parseFunctionCallPostfix(prefixExp ast.PrefixExpression) (*ast.
 			return nil, err
 		}
 		return &ast.FunctionCall{
+			Node:      node,
 			PrefixExp: prefixExp,
 			Name:      "",
 			Args:      args,
 		}, nil
 	}
-	return nil, ErrNoFunctionCallPostfix
+	return nil, p.errorAt(p.currentToken.Pos, ErrNoFunctionCallPostfix)
 }
 
 // prefixexp ::= var | ‘(’ exp ‘)’
 func (p *Parser) parsePrefixExpressionHead() (ast.PrefixExpression, error) {
+	node := p.node()
 	switch p.currentToken.Type {
 	case lexer.TokenIdentifier:
 		name := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
-		return &ast.NameVar{Name: name}, nil
+		return &ast.NameVar{Node: node, Name: name}, nil
 	case lexer.TokenLeftParen:
 		p.currentToken = p.lexer.NextToken()
 		exp, err := p.parseExpression()
@@ -177,12 +185,16 @@ func (p *Parser) parsePrefixExpressionHead() (ast.PrefixExpression, error) {
 			return nil, err
 		}
 		if p.currentToken.Type != lexer.TokenRightParen {
-			return nil, errors.New("missing ')'")
+			return nil, p.errorf("missing ')'")
 		}
 		p.currentToken = p.lexer.NextToken()
+		if _, ok := exp.(ast.MultiValuer); ok {
+			// parentheses truncate a multi-valued expression to one value
+			return &ast.ParenExpression{Node: node, Exp: exp}, nil
+		}
 		return exp.(ast.PrefixExpression), nil
 	default:
-		return nil, fmt.Errorf("unexpected token: %s", p.currentToken.Type)
+		return nil, p.unexpected()
 	}
 }
 
@@ -199,18 +211,19 @@ func (p *Parser) parseArgs() (ast.Args, error) {
 			return nil, err
 		}
 		if p.currentToken.Type != lexer.TokenRightParen {
-			return nil, errors.New("missing ')'")
+			return nil, p.errorf("missing ')'")
 		}
 		p.currentToken = p.lexer.NextToken()
 		return explist, nil
 	} else if p.currentToken.Type == lexer.TokenLeftBrace {
 		return p.parseTableConstructor()
 	} else if p.currentToken.Type == lexer.TokenLiteralString {
+		node := p.node()
 		str := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
-		return &ast.LiteralString{Value: str}, nil
+		return &ast.LiteralString{Node: node, Value: str}, nil
 	}
-	return nil, fmt.Errorf("unexpected token: %s", p.currentToken.Type)
+	return nil, p.unexpected()
 }
 
 // varlist ‘=’ explist
@@ -220,10 +233,11 @@ func (p *Parser) parseArgs() (ast.Args, error) {
 // prefixexp ::= var  // for this case prefixexp is a Var
 // args ::= ‘(’ [explist] ‘)’ | tableconstructor | LiteralString
 func (p *Parser) parseAssignmentOrFunctionCall() (ast.Statement, error) {
+	node := p.node()
 	name := p.currentToken.Value
 	p.currentToken = p.lexer.NextToken()
 
-	prefix, err := p.parsePrefixExpressionTail(&ast.NameVar{Name: name})
+	prefix, err := p.parsePrefixExpressionTail(&ast.NameVar{Node: node, Name: name})
 	if err != nil {
 		return nil, err
 	}
@@ -241,15 +255,20 @@ func (p *Parser) parseAssignmentOrFunctionCall() (ast.Statement, error) {
 			vars = append(vars, vl...)
 		}
 		if p.currentToken.Type != lexer.TokenAssign {
-			return nil, errors.New("missing '='")
+			return nil, p.errorf("missing '='")
+		}
+		for _, v := range vars {
+			if err := p.checkAssignable(v); err != nil {
+				return nil, err
+			}
 		}
 		p.currentToken = p.lexer.NextToken()
 		exps, err := p.parseExpressionList()
 		if err != nil {
 			return nil, err
 		}
-		return &ast.Assignment{Vars: vars, Exps: exps}, nil
+		return &ast.Assignment{Node: node, Vars: vars, Exps: exps}, nil
 	default:
-		return nil, fmt.Errorf("unexpected type: %T", prefix)
+		return nil, p.errorAt(node.Pos, fmt.Errorf("unexpected type: %T", prefix))
 	}
 }
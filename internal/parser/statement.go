@@ -1,26 +1,32 @@
 package parser
 
 import (
-	"errors"
-	"fmt"
-
 	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/lexer"
 )
 
 func (p *Parser) parseStatement() (ast.Statement, error) {
+	node := p.node()
 	switch p.currentToken.Type {
 	case lexer.TokenSemiColon:
 		p.currentToken = p.lexer.NextToken()
-		return &ast.EmptyStatement{}, nil
+		return &ast.EmptyStatement{Node: node}, nil
 	case lexer.TokenKeywordBreak:
+		if p.fn.loops == 0 {
+			return nil, p.errorf("break outside a loop at line %d", node.Pos.Line)
+		}
 		p.currentToken = p.lexer.NextToken()
-		return &ast.Break{}, nil
+		return &ast.Break{Node: node}, nil
 	case lexer.TokenKeywordGoTo:
 		p.currentToken = p.lexer.NextToken()
+		if p.currentToken.Type != lexer.TokenIdentifier {
+			return nil, p.errorf("missing label name after 'goto'")
+		}
 		name := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
-		return &ast.Goto{Name: name}, nil
+		stmt := &ast.Goto{Node: node, Name: name}
+		p.declareGoto(stmt)
+		return stmt, nil
 	case lexer.TokenKeywordDo:
 		return p.parseDoStatement()
 	case lexer.TokenKeywordWhile:
@@ -42,21 +48,21 @@ func (p *Parser) parseStatement() (ast.Statement, error) {
 	case lexer.TokenIdentifier:
 		return p.parseAssignmentOrFunctionCall()
 	default:
-		return nil, fmt.Errorf("unexpected token: %s", p.currentToken.Type)
+		return nil, p.unexpected()
 	}
 }
 
 func (p *Parser) parseNameList() ([]string, error) {
 	var names []string
 	if p.currentToken.Type != lexer.TokenIdentifier {
-		return nil, errors.New("missing identifier")
+		return nil, p.errorf("missing identifier")
 	}
 	names = append(names, p.currentToken.Value)
 	p.currentToken = p.lexer.NextToken()
 	for p.currentToken.Type == lexer.TokenComma {
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenIdentifier {
-			return nil, errors.New("missing identifier")
+			return nil, p.errorf("missing identifier")
 		}
 		names = append(names, p.currentToken.Value)
 		p.currentToken = p.lexer.NextToken()
@@ -67,81 +73,144 @@ func (p *Parser) parseNameList() ([]string, error) {
 // varlist ::= var { ',' var }
 func (p *Parser) parseVarList() ([]ast.Var, error) {
 	var vars []ast.Var
-	if p.currentToken.Type != lexer.TokenIdentifier {
-		return nil, errors.New("missing identifier")
-	}
-	name := p.currentToken.Value
-	p.currentToken = p.lexer.NextToken()
-	vars = append(vars, &ast.NameVar{Name: name})
-	for p.currentToken.Type == lexer.TokenComma {
-		p.currentToken = p.lexer.NextToken()
-		if p.currentToken.Type != lexer.TokenIdentifier {
-			return nil, errors.New("missing identifier")
+	for {
+		v, err := p.parseVar()
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, v)
+		if p.currentToken.Type != lexer.TokenComma {
+			return vars, nil
 		}
-		name = p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
-		vars = append(vars, &ast.NameVar{Name: name})
 	}
-	return vars, nil
+}
+
+// var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
+func (p *Parser) parseVar() (ast.Var, error) {
+	if p.currentToken.Type != lexer.TokenIdentifier && p.currentToken.Type != lexer.TokenLeftParen {
+		return nil, p.errorf("missing identifier")
+	}
+	prefix, err := p.parsePrefixExpression()
+	if err != nil {
+		return nil, err
+	}
+	switch v := prefix.(type) {
+	case *ast.NameVar, *ast.IndexedVar, *ast.MemberVar:
+		return v.(ast.Var), nil
+	default:
+		// a call or an expression in parentheses cannot be assigned
+		return nil, p.unexpected()
+	}
 }
 
 func (p *Parser) parseLabel() (*ast.Label, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	if p.currentToken.Type != lexer.TokenIdentifier {
-		return nil, errors.New("missing identifier")
+		return nil, p.errorf("missing identifier")
 	}
 	name := p.currentToken.Value
 	p.currentToken = p.lexer.NextToken()
 	if p.currentToken.Type != lexer.TokenDoubleColon {
-		return nil, errors.New("missing '::'")
+		return nil, p.errorf("missing '::'")
 	}
 	p.currentToken = p.lexer.NextToken()
-	return &ast.Label{Name: name}, nil
+	return &ast.Label{Node: node, Name: name}, nil
 }
 
 func (p *Parser) parseLocalDeclaration() (ast.Statement, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	switch p.currentToken.Type {
 	case lexer.TokenKeywordFunction:
 		p.currentToken = p.lexer.NextToken()
 		if p.currentToken.Type != lexer.TokenIdentifier {
-			return nil, errors.New("missing Name for local function")
+			return nil, p.errorf("missing Name for local function")
 		}
 		name := p.currentToken.Value
 		p.currentToken = p.lexer.NextToken()
+		// the function can refer to itself
+		p.declareLocal(name, "")
 		body, err := p.parseFunctionBody()
 		if err != nil {
 			return nil, err
 		}
-		return &ast.LocalFunction{Name: name, FunctionBody: body}, nil
+		return &ast.LocalFunction{Node: node, Name: name, FunctionBody: body}, nil
 	case lexer.TokenIdentifier:
-		names, err := p.parseNameList()
+		names, attribs, err := p.parseAttNameList()
 		if err != nil {
 			return nil, err
 		}
-		if p.currentToken.Type != lexer.TokenAssign {
-			return &ast.LocalVarDeclaration{Vars: names}, nil
+		var exps []ast.Expression
+		if p.currentToken.Type == lexer.TokenAssign {
+			p.currentToken = p.lexer.NextToken()
+			exps, err = p.parseExpressionList()
+			if err != nil {
+				return nil, err
+			}
 		}
-		p.currentToken = p.lexer.NextToken()
-		exps, err := p.parseExpressionList()
-		if err != nil {
-			return nil, err
+		// the variables are in scope only after the declaration
+		for i, name := range names {
+			p.declareLocal(name, attribs[i])
 		}
-		return &ast.LocalVarDeclaration{Vars: names, Exps: exps}, nil
+		return &ast.LocalVarDeclaration{Node: node, Vars: names, Attribs: attribs, Exps: exps}, nil
 	default:
-		return nil, errors.New("missing identifier or function")
+		return nil, p.errorf("missing identifier or function")
+	}
+}
+
+// attnamelist ::=  Name attrib {‘,’ Name attrib}
+// attrib ::= [‘<’ Name ‘>’]
+func (p *Parser) parseAttNameList() ([]string, []string, error) {
+	var names, attribs []string
+	closing := false
+	for {
+		if p.currentToken.Type != lexer.TokenIdentifier {
+			return nil, nil, p.errorf("missing identifier")
+		}
+		names = append(names, p.currentToken.Value)
+		p.currentToken = p.lexer.NextToken()
+		attrib := ""
+		if p.currentToken.Type == lexer.TokenLess {
+			p.currentToken = p.lexer.NextToken()
+			if p.currentToken.Type != lexer.TokenIdentifier {
+				return nil, nil, p.errorf("missing attribute name")
+			}
+			attrib = p.currentToken.Value
+			if attrib != "const" && attrib != "close" {
+				return nil, nil, p.errorf("unknown attribute '%s'", attrib)
+			}
+			if attrib == "close" {
+				if closing {
+					return nil, nil, p.errorf("multiple to-be-closed variables in local list")
+				}
+				closing = true
+			}
+			p.currentToken = p.lexer.NextToken()
+			if p.currentToken.Type != lexer.TokenMore {
+				return nil, nil, p.errorf("missing '>'")
+			}
+			p.currentToken = p.lexer.NextToken()
+		}
+		attribs = append(attribs, attrib)
+		if p.currentToken.Type != lexer.TokenComma {
+			return names, attribs, nil
+		}
+		p.currentToken = p.lexer.NextToken()
 	}
 }
 
 func (p *Parser) parseDoStatement() (*ast.Do, error) {
+	node := p.node()
 	p.currentToken = p.lexer.NextToken()
 	block, err := p.parseBlock()
 	if err != nil {
 		return nil, err
 	}
 	if p.currentToken.Type != lexer.TokenKeywordEnd {
-		return nil, errors.New("missing 'end' keyword")
+		return nil, p.errorf("missing 'end' keyword")
 	}
 	p.currentToken = p.lexer.NextToken()
-	return &ast.Do{Block: block}, nil
+	return &ast.Do{Node: node, Block: block}, nil
 }
@@ -0,0 +1,482 @@
+// Package pattern implements Lua patterns, the matching language of
+// string.find, string.match, string.gmatch and string.gsub.
+//
+// The matcher follows PUC-Lua: patterns are interpreted while matching,
+// by a backtracking recursion over the pattern, so a malformed part of
+// a pattern is only reported when the matcher reaches it.
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrEndsWithEscape  = errors.New("malformed pattern (ends with '%')")
+	ErrMissingBracket  = errors.New("malformed pattern (missing ']')")
+	ErrBalanceArgs     = errors.New("malformed pattern (missing arguments to '%b')")
+	ErrFrontierBracket = errors.New("missing '[' after '%f' in pattern")
+	ErrInvalidCapture  = errors.New("invalid pattern capture")
+	ErrUnfinished      = errors.New("unfinished capture")
+	ErrTooManyCaptures = errors.New("too many captures")
+	ErrTooComplex      = errors.New("pattern too complex")
+)
+
+const (
+	// MaxCaptures is the maximum number of captures of a pattern.
+	MaxCaptures = 32
+	// maxDepth limits the recursion of the matcher.
+	maxDepth = 200
+	// specials are the characters that make a pattern more than a plain string
+	specials = "^$*+?.([%-"
+)
+
+// Lengths of captures that have no text.
+const (
+	unfinished = -1
+	position   = -2
+)
+
+// CaptureIndexError is a back-reference or a replacement
+// that refers to a capture that does not exist.
+type CaptureIndexError struct {
+	Index int
+}
+
+func (e *CaptureIndexError) Error() string {
+	return fmt.Sprintf("invalid capture index %%%d", e.Index)
+}
+
+type capture struct {
+	start, len int
+}
+
+// Match is a match of a pattern in a subject string.
+type Match struct {
+	// Start and End are the byte offsets of the matched substring.
+	Start, End int
+
+	src      string
+	captures []capture
+}
+
+// NumCaptures returns the number of captures of the pattern.
+func (m *Match) NumCaptures() int {
+	return len(m.captures)
+}
+
+// Capture returns capture i, counting from 0: the captured string,
+// or the 1-based int64 position of a position capture. If the pattern
+// has no captures, capture 0 is the whole match.
+func (m *Match) Capture(i int) (interface{}, error) {
+	if i >= len(m.captures) {
+		if i != 0 {
+			return nil, &CaptureIndexError{Index: i + 1}
+		}
+		return m.src[m.Start:m.End], nil
+	}
+	c := m.captures[i]
+	switch c.len {
+	case unfinished:
+		return nil, ErrUnfinished
+	case position:
+		return int64(c.start + 1), nil
+	default:
+		return m.src[c.start : c.start+c.len], nil
+	}
+}
+
+// Captures returns all the captures; if whole is set and the pattern
+// has no captures, it returns the whole match.
+func (m *Match) Captures(whole bool) ([]interface{}, error) {
+	n := len(m.captures)
+	if n == 0 && whole {
+		n = 1
+	}
+	res := make([]interface{}, n)
+	for i := range res {
+		c, err := m.Capture(i)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = c
+	}
+	return res, nil
+}
+
+// HasSpecials reports whether p has characters with a special meaning;
+// string.find matches a pattern without them as a plain string.
+func HasSpecials(p string) bool {
+	return strings.ContainsAny(p, specials)
+}
+
+// MatchAt matches the pattern p at byte offset i of s, with no special
+// meaning for a leading '^'. It returns nil if p does not match there.
+func MatchAt(s, p string, i int) (*Match, error) {
+	ms := &matcher{src: s, pat: p, depth: maxDepth}
+	end, err := ms.match(i, 0)
+	if err != nil || end < 0 {
+		return nil, err
+	}
+	return &Match{Start: i, End: end, src: s, captures: ms.captures}, nil
+}
+
+// Find returns the first match of the pattern p in s at or after byte
+// offset init, or nil if there is none. A leading '^' anchors the match
+// at init.
+func Find(s, p string, init int) (*Match, error) {
+	anchor := strings.HasPrefix(p, "^")
+	if anchor {
+		p = p[1:]
+	}
+	for i := init; i <= len(s); i++ {
+		m, err := MatchAt(s, p, i)
+		if err != nil || m != nil {
+			return m, err
+		}
+		if anchor {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// matcher is the state of matching a pattern at one position.
+type matcher struct {
+	src, pat string
+	depth    int
+	captures []capture
+}
+
+// patByte returns the pattern byte at p, or 0 past the end of the pattern,
+// where C reads the terminating zero.
+func (ms *matcher) patByte(p int) byte {
+	if p < len(ms.pat) {
+		return ms.pat[p]
+	}
+	return 0
+}
+
+// srcByte returns the subject byte at s, or 0 past the end of the subject.
+func (ms *matcher) srcByte(s int) byte {
+	if s < len(ms.src) {
+		return ms.src[s]
+	}
+	return 0
+}
+
+// match matches the pattern from offset p at subject offset s.
+// It returns the end of the match, or -1 if it does not match.
+func (ms *matcher) match(s, p int) (int, error) {
+	if ms.depth == 0 {
+		return -1, ErrTooComplex
+	}
+	ms.depth--
+	defer func() { ms.depth++ }()
+
+	for p < len(ms.pat) {
+		switch ms.pat[p] {
+		case '(':
+			if ms.patByte(p+1) == ')' {
+				return ms.startCapture(s, p+2, position)
+			}
+			return ms.startCapture(s, p+1, unfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s, nil
+				}
+				return -1, nil
+			}
+		case '%':
+			switch c := ms.patByte(p + 1); {
+			case c == 'b':
+				var err error
+				if s, err = ms.matchBalance(s, p+2); err != nil || s < 0 {
+					return -1, err
+				}
+				p += 4
+				continue
+			case c == 'f':
+				p += 2
+				if ms.patByte(p) != '[' {
+					return -1, ErrFrontierBracket
+				}
+				ep, err := ms.classEnd(p)
+				if err != nil {
+					return -1, err
+				}
+				var prev byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if !ms.matchBracketClass(prev, p, ep-1) && ms.matchBracketClass(ms.srcByte(s), p, ep-1) {
+					p = ep
+					continue
+				}
+				return -1, nil
+			case '0' <= c && c <= '9':
+				var err error
+				if s, err = ms.matchCapture(s, c); err != nil || s < 0 {
+					return -1, err
+				}
+				p += 2
+				continue
+			}
+		}
+
+		// a single character class, possibly followed by a repetition
+		ep, err := ms.classEnd(p)
+		if err != nil {
+			return -1, err
+		}
+		rep := ms.patByte(ep)
+		if !ms.singleMatch(s, p, ep) {
+			if rep == '*' || rep == '?' || rep == '-' {
+				// the class may match zero times
+				p = ep + 1
+				continue
+			}
+			return -1, nil
+		}
+		switch rep {
+		case '?':
+			res, err := ms.match(s+1, ep+1)
+			if err != nil || res >= 0 {
+				return res, err
+			}
+			p = ep + 1
+		case '+':
+			return ms.maxExpand(s+1, p, ep)
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		default:
+			s++
+			p = ep
+		}
+	}
+	return s, nil
+}
+
+// classEnd returns the end of the single character class at p.
+func (ms *matcher) classEnd(p int) (int, error) {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case '%':
+		if p == len(ms.pat) {
+			return 0, ErrEndsWithEscape
+		}
+		return p + 1, nil
+	case '[':
+		if ms.patByte(p) == '^' {
+			p++
+		}
+		// look for a ']', skipping escapes such as '%]'
+		for {
+			if p >= len(ms.pat) {
+				return 0, ErrMissingBracket
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' && p < len(ms.pat) {
+				p++
+			}
+			if ms.patByte(p) == ']' {
+				return p + 1, nil
+			}
+		}
+	default:
+		return p, nil
+	}
+}
+
+// singleMatch reports whether the subject byte at s matches
+// the character class from p to ep.
+func (ms *matcher) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+// matchBracketClass reports whether c matches the set from the '['
+// at p to the ']' at ec.
+func (ms *matcher) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%':
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		case ms.pat[p+1] == '-' && p+2 < ec:
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		case ms.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+// matchClass reports whether c belongs to the class %cl; an upper-case
+// class letter is the complement, and other characters match themselves.
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = '0' <= c && c <= '9'
+	case 'g':
+		res = 32 < c && c < 127
+	case 'l':
+		res = 'a' <= c && c <= 'z'
+	case 'p':
+		res = 32 < c && c < 127 && !isAlpha(c) && !('0' <= c && c <= '9')
+	case 's':
+		res = c == ' ' || ('\t' <= c && c <= '\r')
+	case 'u':
+		res = 'A' <= c && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || ('0' <= c && c <= '9')
+	case 'x':
+		res = ('0' <= c && c <= '9') || ('a' <= c|0x20 && c|0x20 <= 'f')
+	default:
+		return cl == c
+	}
+	if 'A' <= cl && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c|0x20 && c|0x20 <= 'z'
+}
+
+// matchBalance matches %bxy at s, where p is the offset of x.
+func (ms *matcher) matchBalance(s, p int) (int, error) {
+	if p+1 >= len(ms.pat) {
+		return -1, ErrBalanceArgs
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1, nil
+	}
+	open, closing := ms.pat[p], ms.pat[p+1]
+	depth := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case closing:
+			if depth--; depth == 0 {
+				return s + 1, nil
+			}
+		case open:
+			depth++
+		}
+	}
+	return -1, nil
+}
+
+// maxExpand matches the class from p to ep as many times as possible,
+// backing off until the rest of the pattern matches.
+func (ms *matcher) maxExpand(s, p, ep int) (int, error) {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		res, err := ms.match(s+i, ep+1)
+		if err != nil || res >= 0 {
+			return res, err
+		}
+	}
+	return -1, nil
+}
+
+// minExpand matches the class from p to ep as few times as possible.
+func (ms *matcher) minExpand(s, p, ep int) (int, error) {
+	for {
+		res, err := ms.match(s, ep+1)
+		if err != nil || res >= 0 {
+			return res, err
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1, nil
+		}
+		s++
+	}
+}
+
+func (ms *matcher) startCapture(s, p, what int) (int, error) {
+	if len(ms.captures) >= MaxCaptures {
+		return -1, ErrTooManyCaptures
+	}
+	ms.captures = append(ms.captures, capture{start: s, len: what})
+	res, err := ms.match(s, p)
+	if err == nil && res < 0 {
+		ms.captures = ms.captures[:len(ms.captures)-1]
+	}
+	return res, err
+}
+
+func (ms *matcher) endCapture(s, p int) (int, error) {
+	l := -1
+	for i := len(ms.captures) - 1; i >= 0; i-- {
+		if ms.captures[i].len == unfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		return -1, ErrInvalidCapture
+	}
+	ms.captures[l].len = s - ms.captures[l].start
+	res, err := ms.match(s, p)
+	if err == nil && res < 0 {
+		ms.captures[l].len = unfinished
+	}
+	return res, err
+}
+
+// matchCapture matches the back-reference %c at s.
+func (ms *matcher) matchCapture(s int, c byte) (int, error) {
+	l := int(c) - '1'
+	if l < 0 || l >= len(ms.captures) || ms.captures[l].len == unfinished {
+		return -1, &CaptureIndexError{Index: l + 1}
+	}
+	cp := ms.captures[l]
+	// a position capture has no text, and like in Lua it never matches
+	if cp.len == position {
+		return -1, nil
+	}
+	if strings.HasPrefix(ms.src[s:], ms.src[cp.start:cp.start+cp.len]) {
+		return s + cp.len, nil
+	}
+	return -1, nil
+}
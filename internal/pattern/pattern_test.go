@@ -0,0 +1,133 @@
+package pattern_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"lua-interpreter/internal/pattern"
+)
+
+type PatternSuite struct {
+	suite.Suite
+}
+
+func TestPatternSuite(t *testing.T) {
+	suite.Run(t, new(PatternSuite))
+}
+
+func (s *PatternSuite) TestFind() {
+	tests := []struct {
+		src, pat   string
+		init       int
+		start, end int
+		captures   []interface{}
+	}{
+		{"hello world", "o w", 0, 4, 7, []interface{}{}},
+		{"hello", "l+", 0, 2, 4, []interface{}{}},
+		{"hello", ".-l", 0, 0, 3, []interface{}{}},
+		{"hello", "l*", 0, 0, 0, []interface{}{}},
+		{"hello", "x?h", 0, 0, 1, []interface{}{}},
+		{"hello", "^h", 0, 0, 1, []interface{}{}},
+		{"hello", "o$", 0, 4, 5, []interface{}{}},
+		{"a$b", "$b", 0, 1, 3, []interface{}{}},
+		{"hello", "l", 3, 3, 4, []interface{}{}},
+		{"key = val", "(%w+)%s*=%s*(%w+)", 0, 0, 9, []interface{}{"key", "val"}},
+		{"hello", "()ll()", 0, 2, 4, []interface{}{int64(3), int64(5)}},
+		{"f(a(b)c)d", "%b()", 0, 1, 8, []interface{}{}},
+		{"THE (quick) fox", "%f[%a]%a+", 1, 5, 10, []interface{}{}},
+		{"say 'hi' now", "(['\"])(.-)%1", 0, 4, 8, []interface{}{"'", "hi"}},
+		{"x]", "[]]", 0, 1, 2, []interface{}{}},
+		{"a-b", "[%-]", 0, 1, 2, []interface{}{}},
+		{"abc", "[^a]+", 0, 1, 3, []interface{}{}},
+		{"AbC1 ", "%u%l%L%d%s", 0, 0, 5, []interface{}{}},
+		{"x1_", "%W", 0, 2, 3, []interface{}{}},
+		{"a.b", "%p", 0, 1, 2, []interface{}{}},
+		{"0xfF", "%x+$", 0, 2, 4, []interface{}{}},
+	}
+	for _, test := range tests {
+		m, err := pattern.Find(test.src, test.pat, test.init)
+		s.Require().NoError(err, test.pat)
+		s.Require().NotNil(m, test.pat)
+		s.Equal(test.start, m.Start, test.pat)
+		s.Equal(test.end, m.End, test.pat)
+		caps, err := m.Captures(false)
+		s.NoError(err, test.pat)
+		s.Equal(test.captures, caps, test.pat)
+	}
+
+	for _, p := range []string{"^e", "x", "h$", "%d", "[b-z]+$x"} {
+		m, err := pattern.Find("hello", p, 0)
+		s.NoError(err, p)
+		s.Nil(m, p)
+	}
+}
+
+func (s *PatternSuite) TestMatchAt() {
+	// a leading '^' has no special meaning
+	m, err := pattern.MatchAt("^a", "^a", 0)
+	s.NoError(err)
+	s.Equal(2, m.End)
+
+	m, err = pattern.MatchAt("hello", "l+", 2)
+	s.NoError(err)
+	s.Equal(4, m.End)
+	caps, err := m.Captures(true)
+	s.NoError(err)
+	s.Equal([]interface{}{"ll"}, caps)
+
+	m, err = pattern.MatchAt("hello", "l+", 1)
+	s.NoError(err)
+	s.Nil(m)
+}
+
+func (s *PatternSuite) TestErrors() {
+	tests := []struct {
+		pat      string
+		expected string
+	}{
+		{"%", "malformed pattern (ends with '%')"},
+		{"[a", "malformed pattern (missing ']')"},
+		{"[a%", "malformed pattern (missing ']')"},
+		{"%b", "malformed pattern (missing arguments to '%b')"},
+		{"%fa", "missing '[' after '%f' in pattern"},
+		{"a)", "invalid pattern capture"},
+		{"%0", "invalid capture index %0"},
+		{"%1", "invalid capture index %1"},
+		{"(a%1)", "invalid capture index %1"},
+		{"(a", "unfinished capture"},
+	}
+	for _, test := range tests {
+		m, err := pattern.Find("a", test.pat, 0)
+		if err == nil {
+			_, err = m.Captures(false)
+		}
+		s.EqualError(err, test.expected, test.pat)
+	}
+
+	_, err := pattern.Find("a", strings.Repeat("(", pattern.MaxCaptures+1), 0)
+	s.ErrorIs(err, pattern.ErrTooManyCaptures)
+
+	_, err = pattern.Find(strings.Repeat("a", 300), strings.Repeat("a?", 300)+strings.Repeat("a", 300), 0)
+	s.ErrorIs(err, pattern.ErrTooComplex)
+
+	// an error is only found when the matcher reaches it
+	m, err := pattern.Find("abc", "x[", 0)
+	s.NoError(err)
+	s.Nil(m)
+}
+
+func (s *PatternSuite) TestCapture() {
+	m, err := pattern.Find("hello", "l+", 0)
+	s.Require().NoError(err)
+	s.Equal(0, m.NumCaptures())
+	c, err := m.Capture(0)
+	s.NoError(err)
+	s.Equal("ll", c)
+	_, err = m.Capture(1)
+	s.EqualError(err, "invalid capture index %2")
+
+	s.True(pattern.HasSpecials("a.b"))
+	s.False(pattern.HasSpecials("a)b"))
+}
@@ -0,0 +1,423 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/table"
+)
+
+var setMetatable = &Function{
+	Name: "setmetatable",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, err := CheckTable(d, "setmetatable", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		var mt *table.Table
+		if v := arg(args, 1); v != nil {
+			var ok bool
+			if mt, ok = v.(*table.Table); !ok {
+				return nil, ArgError("setmetatable", 1, "nil or table expected")
+			}
+		}
+		if err := d.SetMetatable(t, mt); err != nil {
+			return nil, err
+		}
+		return []interface{}{t}, nil
+	},
+}
+
+var getMetatable = &Function{
+	Name: "getmetatable",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		return []interface{}{d.GetMetatable(arg(args, 0))}, nil
+	},
+}
+
+var next = &Function{
+	Name: "next",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, err := CheckTable(d, "next", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		k, v, err := t.Next(arg(args, 1))
+		if err != nil {
+			return nil, err
+		}
+		if k == nil {
+			return []interface{}{nil}, nil
+		}
+		return []interface{}{k, v}, nil
+	},
+}
+
+// pairs returns next, or the results of the __pairs metamethod.
+var pairs = &Function{
+	Name: "pairs",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if len(args) == 0 {
+			return nil, TypeError(d, "pairs", args, 0, "table")
+		}
+		if h := d.Field(args[0], "__pairs"); h != nil {
+			res, err := d.CallMeta("__pairs", h, args[:1])
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, nil, nil, nil)
+			return res[:3], nil
+		}
+		t, err := CheckTable(d, "pairs", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{next, t, nil}, nil
+	},
+}
+
+// ipairsIter returns the next index and value until the first nil value.
+var ipairsIter = &Function{
+	Name: "ipairs_iterator",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		i, _ := arg(args, 1).(int64)
+		i++
+		v, err := d.Index(arg(args, 0), i)
+		if err != nil || v == nil {
+			return []interface{}{nil}, err
+		}
+		return []interface{}{i, v}, nil
+	},
+}
+
+var ipairs = &Function{
+	Name: "ipairs",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if len(args) == 0 {
+			return nil, ArgError("ipairs", 0, "table expected, got no value")
+		}
+		return []interface{}{ipairsIter, args[0], int64(0)}, nil
+	},
+}
+
+// luaError raises its argument as the error value. At level 1 the position
+// of the error call is prefixed to a message, at level 2 the position
+// of the call of the function that called error, and so on.
+var luaError = &Function{
+	Name: "error",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		level := int64(1)
+		if len(args) > 1 {
+			var ok bool
+			if level, ok = number.ToInteger(args[1]); !ok {
+				return nil, TypeError(d, "error", args, 1, "number")
+			}
+		}
+		return nil, &meta.LuaError{Value: arg(args, 0), Level: int(level)}
+	},
+}
+
+// pcall calls a function in protected mode: it returns false
+// and the error value instead of raising an error.
+var pcall = &Function{
+	Name: "pcall",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if len(args) == 0 {
+			return nil, ArgError("pcall", 0, "value expected")
+		}
+		res, err := d.Callback(args[0], args[1:])
+		if errors.Is(err, coroutine.ErrClosing) {
+			return nil, err
+		}
+		if err != nil {
+			return []interface{}{false, meta.ErrorValue(err)}, nil
+		}
+		return append([]interface{}{true}, res...), nil
+	},
+}
+
+// xpcall is pcall with a message handler that gets the error value
+// and returns the value that xpcall returns.
+var xpcall = &Function{
+	Name: "xpcall",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if len(args) < 2 {
+			return nil, ArgError("xpcall", 1, "value expected")
+		}
+		res, err := d.Callback(args[0], args[2:])
+		if err == nil {
+			return append([]interface{}{true}, res...), nil
+		}
+		if errors.Is(err, coroutine.ErrClosing) {
+			return nil, err
+		}
+		res, err = d.Callback(args[1], []interface{}{meta.ErrorValue(err)})
+		if err != nil {
+			return []interface{}{false, meta.ErrorValue(err)}, nil
+		}
+		return []interface{}{false, arg(res, 0)}, nil
+	},
+}
+
+// assert raises an error if its first argument is false or nil,
+// otherwise it returns all its arguments.
+var assert = &Function{
+	Name: "assert",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if len(args) == 0 {
+			return nil, ArgError("assert", 0, "value expected")
+		}
+		if meta.Truthy(args[0]) {
+			return args, nil
+		}
+		if len(args) > 1 {
+			return nil, &meta.LuaError{Value: args[1]}
+		}
+		return nil, &meta.LuaError{Value: "assertion failed!", Level: 1}
+	},
+}
+
+var luaType = &Function{
+	Name: "type",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		v, err := checkAny("type", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{d.TypeName(v)}, nil
+	},
+}
+
+// tostring converts a value to a string with __tostring or __name,
+// printing other tables, functions and threads with their address.
+var tostring = &Function{
+	Name: "tostring",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		v, err := checkAny("tostring", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		s, err := toString(d, v)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{s}, nil
+	},
+}
+
+// tonumber converts a number or a numeral to a number, or an integer
+// written in a base from 2 to 36 to an integer. It returns nil
+// for a value that cannot be converted.
+var tonumber = &Function{
+	Name: "tonumber",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if arg(args, 1) == nil {
+			v, err := checkAny("tonumber", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			n, _ := meta.ToNumber(v)
+			return []interface{}{n}, nil
+		}
+		base, err := checkInteger(d, "tonumber", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, TypeError(d, "tonumber", args, 0, "string")
+		}
+		if base < 2 || base > 36 {
+			return nil, ArgError("tonumber", 1, "base out of range")
+		}
+		if n, ok := parseInteger(s, base); ok {
+			return []interface{}{n}, nil
+		}
+		return []interface{}{nil}, nil
+	},
+}
+
+// parseInteger parses an integer in the given base, with an optional
+// minus sign and surrounding spaces. Like Lua, it wraps around on overflow.
+func parseInteger(s string, base int64) (int64, bool) {
+	s = strings.Trim(s, " \f\n\r\t\v")
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	if s == "" {
+		return 0, false
+	}
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		var digit int64
+		switch {
+		case '0' <= c && c <= '9':
+			digit = int64(c - '0')
+		case 'a' <= c && c <= 'z':
+			digit = int64(c-'a') + 10
+		case 'A' <= c && c <= 'Z':
+			digit = int64(c-'A') + 10
+		default:
+			return 0, false
+		}
+		if digit >= base {
+			return 0, false
+		}
+		n = n*uint64(base) + uint64(digit)
+	}
+	if neg {
+		n = -n
+	}
+	return int64(n), true
+}
+
+// luaSelect returns its arguments after the n-th one, counting
+// from the end for a negative n, or their number for '#'.
+var luaSelect = &Function{
+	Name: "select",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		n := int64(len(args))
+		if s, ok := arg(args, 0).(string); ok && strings.HasPrefix(s, "#") {
+			return []interface{}{n - 1}, nil
+		}
+		i, err := checkInteger(d, "select", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			i += n
+		} else if i > n {
+			i = n
+		}
+		if i < 1 {
+			return nil, ArgError("select", 0, "index out of range")
+		}
+		return args[i:], nil
+	},
+}
+
+var rawget = &Function{
+	Name: "rawget",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, err := CheckTable(d, "rawget", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		k, err := checkAny("rawget", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{t.Get(k)}, nil
+	},
+}
+
+var rawset = &Function{
+	Name: "rawset",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, err := CheckTable(d, "rawset", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		k, err := checkAny("rawset", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := checkAny("rawset", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.Set(k, v); err != nil {
+			return nil, err
+		}
+		return []interface{}{t}, nil
+	},
+}
+
+// rawequal compares two values without calling __eq.
+var rawequal = &Function{
+	Name: "rawequal",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		a, err := checkAny("rawequal", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := checkAny("rawequal", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if number.IsNumber(a) && number.IsNumber(b) {
+			return []interface{}{number.Equal(a, b)}, nil
+		}
+		return []interface{}{a == b}, nil
+	},
+}
+
+// rawlen returns the length of a table or a string without calling __len.
+var rawlen = &Function{
+	Name: "rawlen",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		switch v := arg(args, 0).(type) {
+		case *table.Table:
+			return []interface{}{v.Length()}, nil
+		case string:
+			return []interface{}{int64(len(v))}, nil
+		}
+		return nil, TypeError(d, "rawlen", args, 0, "table or string")
+	},
+}
+
+// newCollectGarbage returns collectgarbage. Memory is managed by the Go
+// runtime: "collect" and "step" run a collection and "count" returns
+// the size of the Go heap in kilobytes, while the other options only
+// keep the settings that they return.
+func newCollectGarbage() *Function {
+	running := true
+	mode := "incremental"
+	params := map[string]int64{"setpause": 200, "setstepmul": 100}
+	return &Function{
+		Name: "collectgarbage",
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			opt, err := optString(d, "collectgarbage", args, 0, "collect")
+			if err != nil {
+				return nil, err
+			}
+			switch opt {
+			case "collect":
+				runtime.GC()
+				return []interface{}{int64(0)}, nil
+			case "step":
+				runtime.GC()
+				return []interface{}{true}, nil
+			case "count":
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				return []interface{}{float64(m.HeapAlloc) / 1024}, nil
+			case "stop", "restart":
+				running = opt == "restart"
+				return []interface{}{int64(0)}, nil
+			case "isrunning":
+				return []interface{}{running}, nil
+			case "incremental", "generational":
+				prev := mode
+				mode = opt
+				return []interface{}{prev}, nil
+			case "setpause", "setstepmul":
+				v, err := optInteger(d, "collectgarbage", args, 1, 0)
+				if err != nil {
+					return nil, err
+				}
+				prev := params[opt]
+				params[opt] = v
+				return []interface{}{prev}, nil
+			}
+			return nil, ArgError("collectgarbage", 0, fmt.Sprintf("invalid option '%s'", opt))
+		},
+	}
+}
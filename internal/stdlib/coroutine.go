@@ -0,0 +1,150 @@
+package stdlib
+
+import (
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/table"
+)
+
+func newCoroutineLib() *table.Table {
+	lib := table.New(0, 8)
+	_ = lib.Set("create", coCreate)
+	_ = lib.Set("resume", coResume)
+	_ = lib.Set("yield", coYield)
+	_ = lib.Set("status", coStatus)
+	_ = lib.Set("running", coRunning)
+	_ = lib.Set("isyieldable", coIsYieldable)
+	_ = lib.Set("wrap", coWrap)
+	_ = lib.Set("close", coClose)
+	return lib
+}
+
+// checkFunction returns the i-th argument that must be a function.
+func checkFunction(d *meta.Dispatcher, fname string, args []interface{}, i int) (interface{}, error) {
+	if i < len(args) && d.TypeName(args[i]) == "function" {
+		return args[i], nil
+	}
+	return nil, TypeError(d, fname, args, i, "function")
+}
+
+// checkCoroutine returns the i-th argument that must be a coroutine.
+func checkCoroutine(d *meta.Dispatcher, fname string, args []interface{}, i int) (*coroutine.Coroutine, error) {
+	if i < len(args) {
+		if co, ok := args[i].(*coroutine.Coroutine); ok {
+			return co, nil
+		}
+	}
+	return nil, TypeError(d, fname, args, i, "coroutine")
+}
+
+var coCreate = &Function{
+	Name: "create",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		fn, err := checkFunction(d, "create", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{d.Threads.New(fn)}, nil
+	},
+}
+
+// coResume returns true and the values yielded or returned by the coroutine,
+// or false and the error value.
+var coResume = &Function{
+	Name: "resume",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		co, err := checkCoroutine(d, "resume", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		res, err := co.Resume(args[1:])
+		if err != nil {
+			return []interface{}{false, meta.ErrorValue(err)}, nil
+		}
+		return append([]interface{}{true}, res...), nil
+	},
+}
+
+var coYield = &Function{
+	Name: "yield",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		return d.Threads.Yield(args)
+	},
+}
+
+var coStatus = &Function{
+	Name: "status",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		co, err := checkCoroutine(d, "status", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{co.Status().String()}, nil
+	},
+}
+
+// coRunning returns the running coroutine and whether it is the main one.
+var coRunning = &Function{
+	Name: "running",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		co := d.Threads.Current()
+		return []interface{}{co, co.IsMain()}, nil
+	},
+}
+
+var coIsYieldable = &Function{
+	Name: "isyieldable",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		co := d.Threads.Current()
+		if len(args) > 0 {
+			var err error
+			if co, err = checkCoroutine(d, "isyieldable", args, 0); err != nil {
+				return nil, err
+			}
+		}
+		return []interface{}{!co.IsMain()}, nil
+	},
+}
+
+// coWrap returns a function that resumes a new coroutine and returns
+// its values, raising its errors with the position of the call.
+var coWrap = &Function{
+	Name: "wrap",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		fn, err := checkFunction(d, "wrap", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		co := d.Threads.New(fn)
+		return []interface{}{&Function{
+			Name: "wrap",
+			Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+				res, err := co.Resume(args)
+				if err != nil {
+					return nil, &meta.LuaError{Value: meta.ErrorValue(err), Level: 1}
+				}
+				return res, nil
+			},
+		}}, nil
+	},
+}
+
+// coClose closes a suspended or dead coroutine, returning true
+// or false and the error it died with.
+var coClose = &Function{
+	Name: "close",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		co, err := checkCoroutine(d, "close", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		switch co.Status() {
+		case coroutine.Running, coroutine.Normal:
+			return nil, co.Close()
+		}
+		if err := co.Close(); err != nil {
+			return []interface{}{false, meta.ErrorValue(err)}, nil
+		}
+		return []interface{}{true}, nil
+	},
+}
@@ -0,0 +1,85 @@
+package stdlib_test
+
+import "runtime"
+
+func (s *StdlibSuite) TestCoroutines() {
+	// the coroutine functions use globals, so that the VM can run them
+	const defs = `
+function gen(n)
+  local i = 0
+  while i < n do
+    i = i + 1
+    coroutine.yield(i)
+  end
+  return "done"
+end
+`
+	s.run(defs, []scriptTest{
+		// values pass both ways between resume and yield
+		{"local co = coroutine.create(gen)\nlocal _, a = coroutine.resume(co, 2)\nlocal _, b = coroutine.resume(co)\nlocal ok, c = coroutine.resume(co)\nreturn ok and a * 10 + b == 12 and c == 'done'", true},
+		{"local co = coroutine.create(function(a, b) local c, d = coroutine.yield(a + b) return c * d end)\nlocal _, x = coroutine.resume(co, 1, 2)\nlocal _, y = coroutine.resume(co, 3, 4)\nreturn x * 100 + y", int64(312)},
+		{"local co = coroutine.create(function() coroutine.yield(1, 2, 3) end)\nreturn #{coroutine.resume(co)}", int64(4)},
+		// yields from nested calls and from inside pcall
+		{"function inner() coroutine.yield('deep') end\nlocal co = coroutine.create(function() inner() end)\nlocal _, v = coroutine.resume(co)\nreturn v", "deep"},
+		{"local co = coroutine.create(function() local ok, v = pcall(coroutine.yield, 'p') return ok, v end)\nlocal _, a = coroutine.resume(co)\nlocal _, ok, b = coroutine.resume(co, 'back')\nreturn ok and a .. b", "pback"},
+		// statuses
+		{"local co = coroutine.create(gen)\nlocal a = coroutine.status(co)\ncoroutine.resume(co, 1)\nlocal b = coroutine.status(co)\ncoroutine.resume(co)\nreturn a .. ' ' .. b .. ' ' .. coroutine.status(co)", "suspended suspended dead"},
+		{"co = coroutine.create(function() return coroutine.status(co) end)\nlocal _, st = coroutine.resume(co)\nreturn st", "running"},
+		{"outer = coroutine.create(function() local inner = coroutine.create(function() return coroutine.status(outer) end)\nlocal _, st = coroutine.resume(inner) return st end)\nlocal _, st = coroutine.resume(outer)\nreturn st", "normal"},
+		{"local co, main = coroutine.running()\nreturn main and coroutine.status(co) == 'running' and not coroutine.isyieldable()", true},
+		{"local co = coroutine.create(function() return coroutine.isyieldable() end)\nlocal _, y = coroutine.resume(co)\nreturn y", true},
+		// errors are returned by resume
+		{"local co = coroutine.create(function() error('boom') end)\nlocal ok, e = coroutine.resume(co)\nreturn not ok and e", `[string "..."]:10: boom`},
+		{"local co = coroutine.create(function() error({}) end)\nlocal ok, e = coroutine.resume(co)\nreturn not ok and coroutine.status(co)", "dead"},
+		{"local co = coroutine.create(gen)\ncoroutine.resume(co, 0)\nlocal ok, e = coroutine.resume(co)\nreturn not ok and e", "cannot resume dead coroutine"},
+		{"local co = coroutine.running()\nlocal ok, e = coroutine.resume(co)\nreturn not ok and e", "cannot resume non-suspended coroutine"},
+		{"local ok, e = pcall(coroutine.yield, 1)\nreturn not ok and e", "attempt to yield from outside a coroutine"},
+		// wrap
+		{"local w = coroutine.wrap(gen)\nreturn w(3) + w() + w()", int64(6)},
+		{"local w = coroutine.wrap(function() error('boom') end)\nlocal ok, e = pcall(w)\nreturn not ok and e", `[string "..."]:10: boom`},
+		{"w = coroutine.wrap(function() error('boom') end)\nlocal ok, e = pcall(function() w() end)\nreturn not ok and e", `[string "..."]:11: [string "..."]:10: boom`},
+		// close
+		{"local co = coroutine.create(gen)\ncoroutine.resume(co, 5)\nlocal ok = coroutine.close(co)\nreturn ok and coroutine.status(co)", "dead"},
+		{"local co = coroutine.create(gen)\nreturn coroutine.close(co) and coroutine.status(co)", "dead"},
+		{"local co = coroutine.create(function() error('boom') end)\ncoroutine.resume(co)\nlocal ok, e = coroutine.close(co)\nreturn not ok and e", `[string "..."]:10: boom`},
+		{"log = ''\nlocal co = coroutine.create(function()\nlocal x <close> = setmetatable({}, {__close = function(_, e) log = log .. 'closed ' .. (e == nil and 'nil' or e) end})\npcall(coroutine.yield)\nlog = 'unreachable'\nend)\ncoroutine.resume(co)\ncoroutine.close(co)\nreturn log", "closed nil"},
+		{"local ok, e = pcall(coroutine.close, coroutine.running())\nreturn not ok and e", "cannot close a running coroutine"},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"coroutine.create(1)", "bad argument #1 to 'create' (function expected, got number)"},
+		{"coroutine.status({})", "bad argument #1 to 'status' (coroutine expected, got table)"},
+	})
+}
+
+// TestCoroutineCollect checks that the goroutines of the coroutines
+// a script abandons end, closing their to-be-closed variables.
+func (s *StdlibSuite) TestCoroutineCollect() {
+	const script = `
+closed = 0
+mt = {__close = function() closed = closed + 1 end}
+function abandon(n)
+  for i = 1, n do
+    local gen = coroutine.wrap(function()
+      local x <close> = setmetatable({}, mt)
+      coroutine.yield()
+    end)
+    gen()
+  end
+end
+abandon(100)
+local tries = 0
+while closed < 100 and tries < 100 do
+  collectgarbage()
+  coroutine.create(print)
+  tries = tries + 1
+end
+return closed`
+	for name, eval := range engines {
+		before := runtime.NumGoroutine()
+		v, err := eval(script)
+		s.NoError(err, name)
+		s.Equal(int64(100), v, name)
+		s.LessOrEqual(runtime.NumGoroutine(), before, name)
+	}
+}
@@ -0,0 +1,337 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+)
+
+// Valid flags of the conversions of string.format, like in Lua.
+const (
+	floatFlags    = "-+ #0"
+	hexFlags      = "-#0"
+	intFlags      = "-+ 0"
+	unsignedFlags = "-0"
+	charFlags     = "-"
+)
+
+// maxFormat limits the length of a conversion specification.
+const maxFormat = 22
+
+var ErrFormatTooLong = errors.New("invalid format string to 'format'")
+
+// strFormat formats its arguments like C's sprintf, with the conversions
+// and output of PUC-Lua: integer conversions accept floats with integer
+// values, %s converts any value like tostring and %q writes a literal
+// that Lua can read back.
+var strFormat = &Function{
+	Name: "format",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		f, err := checkString(d, "format", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		argi := 0
+		for i := 0; i < len(f); i++ {
+			if f[i] != '%' {
+				sb.WriteByte(f[i])
+				continue
+			}
+			i++
+			if i < len(f) && f[i] == '%' {
+				sb.WriteByte('%')
+				continue
+			}
+			argi++
+			if argi >= len(args) {
+				return nil, ArgError("format", argi, "no value")
+			}
+			n := len(f[i:]) - len(strings.TrimLeft(f[i:], floatFlags+"123456789."))
+			if n >= maxFormat {
+				return nil, ErrFormatTooLong
+			}
+			// form is the specification with the conversion, e.g. "%-5.2f"
+			form := "%" + f[i:i+n]
+			i += n
+			if i < len(f) {
+				form += f[i : i+1]
+			}
+			s, err := formatItem(d, form, args, argi)
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteString(s)
+		}
+		return []interface{}{sb.String()}, nil
+	},
+}
+
+// formatItem formats the argument i with the specification form.
+func formatItem(d *meta.Dispatcher, form string, args []interface{}, i int) (string, error) {
+	conv := form[len(form)-1]
+	switch conv {
+	case 'c':
+		c, err := checkInteger(d, "format", args, i)
+		if err != nil {
+			return "", err
+		}
+		if err := checkFormat(form, charFlags, false); err != nil {
+			return "", err
+		}
+		return parseSpec(form).pad(string([]byte{byte(c)})), nil
+	case 'd', 'i', 'u', 'o', 'x', 'X':
+		n, err := checkInteger(d, "format", args, i)
+		if err != nil {
+			return "", err
+		}
+		flags := hexFlags
+		switch conv {
+		case 'd', 'i':
+			flags = intFlags
+		case 'u':
+			flags = unsignedFlags
+		}
+		if err := checkFormat(form, flags, true); err != nil {
+			return "", err
+		}
+		if conv == 'd' || conv == 'i' {
+			return fmt.Sprintf(form[:len(form)-1]+"d", n), nil
+		}
+		if conv == 'u' {
+			form = form[:len(form)-1] + "d"
+		} else if n == 0 {
+			// C writes no 0x prefix for zero
+			form = strings.Replace(form, "#", "", -1)
+		}
+		return fmt.Sprintf(form, uint64(n)), nil
+	case 'a', 'A', 'e', 'E', 'f', 'F', 'g', 'G':
+		v, err := checkNumber(d, "format", args, i)
+		if err != nil {
+			return "", err
+		}
+		if err := checkFormat(form, floatFlags, true); err != nil {
+			return "", err
+		}
+		return formatFloat(form, v), nil
+	case 'q':
+		if len(form) > 2 {
+			return "", errors.New("specifier '%q' cannot have modifiers")
+		}
+		return quote(args[i], i)
+	case 's':
+		s, err := toString(d, args[i])
+		if err != nil {
+			return "", err
+		}
+		if len(form) == 2 {
+			return s, nil
+		}
+		if strings.IndexByte(s, 0) >= 0 {
+			return "", ArgError("format", i, "string contains zeros")
+		}
+		if err := checkFormat(form, charFlags, true); err != nil {
+			return "", err
+		}
+		sp := parseSpec(form)
+		if sp.prec < 0 && len(s) >= 100 {
+			return s, nil
+		}
+		// Go counts runes in widths and precisions of strings, C counts bytes
+		if sp.prec >= 0 && sp.prec < len(s) {
+			s = s[:sp.prec]
+		}
+		return sp.pad(s), nil
+	default:
+		return "", fmt.Errorf("invalid conversion '%s' to 'format'", form)
+	}
+}
+
+// checkFormat checks that a specification has only the given flags,
+// a width of at most two digits and, if precision is set, a precision
+// of at most two digits.
+func checkFormat(form, flags string, precision bool) error {
+	spec := strings.TrimLeft(form[1:], flags)
+	if spec[0] != '0' {
+		spec = skipDigits(spec)
+		if spec[0] == '.' && precision {
+			spec = skipDigits(spec[1:])
+		}
+	}
+	if c := spec[0] | 0x20; c < 'a' || c > 'z' {
+		return fmt.Errorf("invalid conversion specification: '%s'", form)
+	}
+	return nil
+}
+
+// skipDigits skips up to two digits at the start of s.
+func skipDigits(s string) string {
+	for i := 0; i < 2 && s[0] >= '0' && s[0] <= '9'; i++ {
+		s = s[1:]
+	}
+	return s
+}
+
+// spec is a parsed conversion specification.
+type spec struct {
+	flags string
+	width int
+	// prec is -1 if the specification has no precision
+	prec int
+}
+
+// parseSpec parses a specification that passed checkFormat.
+func parseSpec(form string) spec {
+	body := form[1 : len(form)-1]
+	rest := strings.TrimLeft(body, floatFlags)
+	sp := spec{flags: body[:len(body)-len(rest)], prec: -1}
+	width, prec, hasPrec := strings.Cut(rest, ".")
+	sp.width, _ = strconv.Atoi(width)
+	if hasPrec {
+		sp.prec, _ = strconv.Atoi(prec)
+	}
+	return sp
+}
+
+func (sp spec) has(flag byte) bool {
+	return strings.IndexByte(sp.flags, flag) >= 0
+}
+
+// pad pads s with spaces to the width of the specification.
+func (sp spec) pad(s string) string {
+	if len(s) >= sp.width {
+		return s
+	}
+	if sp.has('-') {
+		return s + strings.Repeat(" ", sp.width-len(s))
+	}
+	return strings.Repeat(" ", sp.width-len(s)) + s
+}
+
+// formatFloat formats a number with a floating-point conversion.
+func formatFloat(form string, v interface{}) string {
+	f, _ := number.ToFloat(v)
+	sp := parseSpec(form)
+	conv := form[len(form)-1]
+	upper := conv == 'A' || conv == 'E' || conv == 'F' || conv == 'G'
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		s := "inf"
+		if math.IsNaN(f) {
+			s = "nan"
+		}
+		s = sp.sign(f) + s
+		if upper {
+			s = strings.ToUpper(s)
+		}
+		return sp.pad(s)
+	}
+	switch conv {
+	case 'a', 'A':
+		return hexFloat(sp, f, upper)
+	case 'g', 'G':
+		// C's default precision is 6, while Go prints the shortest representation
+		if sp.prec < 0 {
+			form = form[:len(form)-1] + ".6" + form[len(form)-1:]
+		}
+	}
+	return fmt.Sprintf(form, f)
+}
+
+// sign returns the sign to write before a float.
+func (sp spec) sign(f float64) string {
+	switch {
+	case math.Signbit(f):
+		return "-"
+	case sp.has('+'):
+		return "+"
+	case sp.has(' '):
+		return " "
+	default:
+		return ""
+	}
+}
+
+// hexFloat formats a finite float with %a like C: the exponent
+// has no leading zeros and zero padding goes after the 0x prefix.
+func hexFloat(sp spec, f float64, upper bool) string {
+	s := strconv.FormatFloat(math.Abs(f), 'x', sp.prec, 64)
+	p := strings.IndexByte(s, 'p')
+	mant, exp := s[2:p], strings.TrimLeft(s[p+2:], "0")
+	if exp == "" {
+		exp = "0"
+	}
+	if sp.has('#') && !strings.Contains(mant, ".") {
+		mant += "."
+	}
+	sign := sp.sign(f)
+	s = mant + "p" + s[p+1:p+2] + exp
+	if sp.has('0') && !sp.has('-') {
+		if n := sp.width - len(sign) - len("0x") - len(s); n > 0 {
+			s = strings.Repeat("0", n) + s
+		}
+	}
+	s = sign + "0x" + s
+	if upper {
+		s = strings.ToUpper(s)
+	}
+	return sp.pad(s)
+}
+
+// quote writes a value as a Lua literal for %q.
+func quote(v interface{}, i int) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return quoteString(v), nil
+	case int64:
+		if v == math.MinInt64 {
+			return "0x8000000000000000", nil
+		}
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "1e9999", nil
+		case math.IsInf(v, -1):
+			return "-1e9999", nil
+		case math.IsNaN(v):
+			return "(0/0)", nil
+		}
+		return hexFloat(spec{prec: -1}, v, false), nil
+	case nil:
+		return "nil", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", ArgError("format", i, "value has no literal form")
+	}
+}
+
+// quoteString quotes a string, escaping quotes, backslashes, newlines
+// and control characters.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\' || c == '\n':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 32 || c == 127:
+			if i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+				fmt.Fprintf(&sb, "\\%03d", c)
+			} else {
+				fmt.Fprintf(&sb, "\\%d", c)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
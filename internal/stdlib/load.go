@@ -0,0 +1,199 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/meta"
+)
+
+var (
+	ErrReader      = errors.New("reader function must return a string")
+	ErrBinaryChunk = errors.New("binary chunks are not supported")
+)
+
+// maxChunkIDLength limits the length of a chunk name derived from the source.
+const maxChunkIDLength = 60
+
+// ChunkID returns the name of a chunk used in messages, like Lua:
+// "=name" and "@file" stand for name and file, and another
+// source is shown as [string "its first line"].
+func ChunkID(source string) string {
+	if strings.HasPrefix(source, "=") || strings.HasPrefix(source, "@") {
+		return source[1:]
+	}
+	line, _, multiline := strings.Cut(source, "\n")
+	if multiline || len(line) > maxChunkIDLength {
+		if len(line) > maxChunkIDLength {
+			line = line[:maxChunkIDLength]
+		}
+		return fmt.Sprintf(`[string "%s..."]`, line)
+	}
+	return fmt.Sprintf(`[string "%s"]`, line)
+}
+
+// load compiles a chunk from a string or from the pieces returned
+// by a reader function, and returns it as a function. The chunk
+// gets the fourth argument, even nil, as its environment.
+// A compilation error is returned as nil and its message.
+var load = &Function{
+	Name: "load",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		mode, err := optString(d, "load", args, 2, "bt")
+		if err != nil {
+			return nil, err
+		}
+		var env *meta.Env
+		if len(args) > 3 {
+			env = &meta.Env{Value: args[3]}
+		}
+		var src, chunkname string
+		if s, ok := arg(args, 0).(string); ok {
+			src = s
+			if chunkname, err = optString(d, "load", args, 1, s); err != nil {
+				return nil, err
+			}
+		} else {
+			if chunkname, err = optString(d, "load", args, 1, "=(load)"); err != nil {
+				return nil, err
+			}
+			reader, err := checkFunction(d, "load", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			if src, err = readChunk(d, reader); errors.Is(err, coroutine.ErrClosing) {
+				return nil, err
+			} else if err != nil {
+				return []interface{}{nil, meta.ErrorValue(err)}, nil
+			}
+		}
+		return loadChunk(d, src, chunkname, mode, env)
+	},
+}
+
+// readChunk joins the pieces of a chunk returned by a reader function
+// until it returns nil or an empty string.
+func readChunk(d *meta.Dispatcher, reader interface{}) (string, error) {
+	var sb strings.Builder
+	for {
+		res, err := d.Callback(reader, nil)
+		if err != nil {
+			return "", err
+		}
+		switch v := arg(res, 0).(type) {
+		case nil:
+			return sb.String(), nil
+		case string:
+			if v == "" {
+				return sb.String(), nil
+			}
+			sb.WriteString(v)
+		default:
+			return "", ErrReader
+		}
+	}
+}
+
+// loadChunk returns the compiled chunk, or nil and the message of the error.
+func loadChunk(d *meta.Dispatcher, src, chunkname, mode string, env *meta.Env) ([]interface{}, error) {
+	fn, err := compileChunk(d, src, chunkname, mode, env)
+	if err != nil {
+		return []interface{}{nil, err.Error()}, nil
+	}
+	return []interface{}{fn}, nil
+}
+
+// compileChunk compiles a chunk if mode allows its kind: 't' allows
+// text chunks and 'b' binary chunks, which cannot be loaded anyway.
+func compileChunk(d *meta.Dispatcher, src, chunkname, mode string, env *meta.Env) (interface{}, error) {
+	kind := "text"
+	if strings.HasPrefix(src, "\x1b") {
+		kind = "binary"
+	}
+	if !strings.Contains(mode, kind[:1]) {
+		return nil, fmt.Errorf("attempt to load a %s chunk (mode is '%s')", kind, mode)
+	}
+	if kind == "binary" {
+		return nil, ErrBinaryChunk
+	}
+	return d.Load(src, ChunkID(chunkname), env)
+}
+
+// readFile reads a Lua file, or the standard input if filename is empty,
+// and returns its source and its chunk name. A first line starting
+// with '#', like a shebang line, is skipped.
+func readFile(filename string) (string, string, error) {
+	chunkname := "@" + filename
+	var b []byte
+	var err error
+	if filename == "" {
+		chunkname = "=stdin"
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return "", "", fmt.Errorf("cannot open %s: %w", chunkname[1:], err)
+	}
+	src := string(b)
+	if strings.HasPrefix(src, "#") {
+		// the newline is kept so that the lines keep their numbers
+		_, rest, _ := strings.Cut(src, "\n")
+		src = "\n" + rest
+	}
+	return src, chunkname, nil
+}
+
+// loadfile is load for a file, by default the standard input.
+var loadfile = &Function{
+	Name: "loadfile",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		filename, err := optString(d, "loadfile", args, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		mode, err := optString(d, "loadfile", args, 1, "bt")
+		if err != nil {
+			return nil, err
+		}
+		var env *meta.Env
+		if len(args) > 2 {
+			env = &meta.Env{Value: args[2]}
+		}
+		src, chunkname, err := readFile(filename)
+		if err != nil {
+			return []interface{}{nil, err.Error()}, nil
+		}
+		return loadChunk(d, src, chunkname, mode, env)
+	},
+}
+
+// dofile runs a file, by default the standard input, and returns
+// its results. Unlike loadfile, it raises compilation errors.
+var dofile = &Function{
+	Name: "dofile",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		filename, err := optString(d, "dofile", args, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		src, chunkname, err := readFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		fn, err := compileChunk(d, src, chunkname, "bt", nil)
+		if err != nil {
+			return nil, err
+		}
+		return d.Callback(fn, nil)
+	},
+}
@@ -0,0 +1,259 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/pattern"
+	"lua-interpreter/internal/table"
+)
+
+var ErrReplacementEscape = errors.New("invalid use of '%' in replacement string")
+
+// strFind returns the positions of the first match of a pattern
+// and its captures; a true fourth argument or a pattern without
+// special characters makes a plain substring search.
+var strFind = &Function{
+	Name: "find",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		return findAux(d, "find", args, true)
+	},
+}
+
+// strMatch returns the captures of the first match of a pattern,
+// or the whole match if it has no captures.
+var strMatch = &Function{
+	Name: "match",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		return findAux(d, "match", args, false)
+	},
+}
+
+func findAux(d *meta.Dispatcher, fname string, args []interface{}, find bool) ([]interface{}, error) {
+	s, err := checkString(d, fname, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	p, err := checkString(d, fname, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	i, err := optInteger(d, fname, args, 2, 1)
+	if err != nil {
+		return nil, err
+	}
+	init := int(startPos(i, len(s)) - 1)
+	if init > len(s) {
+		return []interface{}{nil}, nil
+	}
+	if find && (meta.Truthy(arg(args, 3)) || !pattern.HasSpecials(p)) {
+		k := strings.Index(s[init:], p)
+		if k < 0 {
+			return []interface{}{nil}, nil
+		}
+		return []interface{}{int64(init + k + 1), int64(init + k + len(p))}, nil
+	}
+	m, err := pattern.Find(s, p, init)
+	if err != nil || m == nil {
+		return []interface{}{nil}, err
+	}
+	if !find {
+		return m.Captures(true)
+	}
+	caps, err := m.Captures(false)
+	if err != nil {
+		return nil, err
+	}
+	return append([]interface{}{int64(m.Start + 1), int64(m.End)}, caps...), nil
+}
+
+// strGmatch returns an iterator over the matches of a pattern,
+// which returns the captures of each match. A match cannot end
+// where the previous one ended, so that an empty match does not
+// repeat after a non-empty one.
+var strGmatch = &Function{
+	Name: "gmatch",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "gmatch", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		p, err := checkString(d, "gmatch", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		i, err := optInteger(d, "gmatch", args, 2, 1)
+		if err != nil {
+			return nil, err
+		}
+		pos := int(startPos(i, len(s)) - 1)
+		if pos > len(s) {
+			pos = len(s) + 1
+		}
+		lastMatch := -1
+		return []interface{}{&Function{
+			Name: "gmatch_iterator",
+			Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+				for ; pos <= len(s); pos++ {
+					m, err := pattern.MatchAt(s, p, pos)
+					if err != nil {
+						return nil, err
+					}
+					if m != nil && m.End != lastMatch {
+						pos, lastMatch = m.End, m.End
+						return m.Captures(true)
+					}
+				}
+				return []interface{}{nil}, nil
+			},
+		}}, nil
+	},
+}
+
+// strGsub replaces the matches of a pattern, at most n of them, with
+// a string where %0-%9 stand for the captures, with the value of
+// a table indexed by the first capture, or with the result of a function
+// called with the captures. A nil or false value keeps the match.
+// It returns the new string and the number of matches.
+var strGsub = &Function{
+	Name: "gsub",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "gsub", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		p, err := checkString(d, "gsub", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		maxN, err := optInteger(d, "gsub", args, 3, int64(len(s)+1))
+		if err != nil {
+			return nil, err
+		}
+		repl := arg(args, 2)
+		switch d.TypeName(repl) {
+		case "string", "number", "table", "function":
+		default:
+			return nil, TypeError(d, "gsub", args, 2, "string/function/table")
+		}
+
+		anchor := strings.HasPrefix(p, "^")
+		if anchor {
+			p = p[1:]
+		}
+		var sb strings.Builder
+		n, src, lastMatch := int64(0), 0, -1
+		for n < maxN {
+			m, err := pattern.MatchAt(s, p, src)
+			if err != nil {
+				return nil, err
+			}
+			if m != nil && m.End != lastMatch {
+				n++
+				if err := addReplacement(d, &sb, m, s, repl); err != nil {
+					return nil, err
+				}
+				src, lastMatch = m.End, m.End
+			} else if src < len(s) {
+				sb.WriteByte(s[src])
+				src++
+			} else {
+				break
+			}
+			if anchor {
+				break
+			}
+		}
+		if src < len(s) {
+			sb.WriteString(s[src:])
+		}
+		return []interface{}{sb.String(), n}, nil
+	},
+}
+
+// addReplacement writes the replacement of the match m of s.
+func addReplacement(d *meta.Dispatcher, sb *strings.Builder, m *pattern.Match, s string, repl interface{}) error {
+	whole := s[m.Start:m.End]
+	var v interface{}
+	switch r := repl.(type) {
+	case string, int64, float64:
+		return addReplacementString(sb, m, whole, r)
+	case *table.Table:
+		c, err := m.Capture(0)
+		if err != nil {
+			return err
+		}
+		if v, err = d.Index(r, c); err != nil {
+			return err
+		}
+	default:
+		caps, err := m.Captures(true)
+		if err != nil {
+			return err
+		}
+		res, err := d.Callback(r, caps)
+		if err != nil {
+			return err
+		}
+		v = arg(res, 0)
+	}
+	switch v := v.(type) {
+	case nil, bool:
+		if !meta.Truthy(v) {
+			// keep the original text
+			sb.WriteString(whole)
+			return nil
+		}
+	case string:
+		sb.WriteString(v)
+		return nil
+	case int64, float64:
+		sb.WriteString(number.ToString(v))
+		return nil
+	}
+	return fmt.Errorf("invalid replacement value (a %s)", d.TypeName(v))
+}
+
+// addReplacementString writes a replacement string, where %0 stands
+// for the whole match, %1-%9 for the captures and %% for a percent sign.
+func addReplacementString(sb *strings.Builder, m *pattern.Match, whole string, repl interface{}) error {
+	r, ok := repl.(string)
+	if !ok {
+		r = number.ToString(repl)
+	}
+	for {
+		i := strings.IndexByte(r, '%')
+		if i < 0 {
+			break
+		}
+		sb.WriteString(r[:i])
+		var c byte
+		if i+1 < len(r) {
+			c = r[i+1]
+		}
+		switch {
+		case c == '%':
+			sb.WriteByte('%')
+		case c == '0':
+			sb.WriteString(whole)
+		case '1' <= c && c <= '9':
+			v, err := m.Capture(int(c - '1'))
+			if err != nil {
+				return err
+			}
+			if pos, ok := v.(int64); ok {
+				sb.WriteString(number.ToString(pos))
+			} else {
+				sb.WriteString(v.(string))
+			}
+		default:
+			return ErrReplacementEscape
+		}
+		r = r[i+2:]
+	}
+	sb.WriteString(r)
+	return nil
+}
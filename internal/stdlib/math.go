@@ -0,0 +1,285 @@
+package stdlib
+
+import (
+	"errors"
+	"math"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/table"
+)
+
+var errWrongArgs = errors.New("wrong number of arguments")
+
+func newMathLib() *table.Table {
+	lib := table.New(0, 28)
+	_ = lib.Set("type", mathType)
+	_ = lib.Set("tointeger", mathToInteger)
+	_ = lib.Set("abs", mathAbs)
+	_ = lib.Set("ceil", mathCeil)
+	_ = lib.Set("floor", mathFloor)
+	_ = lib.Set("fmod", mathFmod)
+	_ = lib.Set("modf", mathModf)
+	_ = lib.Set("max", mathMax)
+	_ = lib.Set("min", mathMin)
+	_ = lib.Set("ult", mathUlt)
+	_ = lib.Set("log", mathLog)
+	_ = lib.Set("atan", mathAtan)
+	for name, fn := range map[string]func(float64) float64{
+		"sqrt": math.Sqrt,
+		"exp":  math.Exp,
+		"sin":  math.Sin,
+		"cos":  math.Cos,
+		"tan":  math.Tan,
+		"asin": math.Asin,
+		"acos": math.Acos,
+	} {
+		_ = lib.Set(name, floatFunction(name, fn))
+	}
+	_ = lib.Set("huge", math.Inf(1))
+	_ = lib.Set("pi", math.Pi)
+	_ = lib.Set("maxinteger", int64(math.MaxInt64))
+	_ = lib.Set("mininteger", int64(math.MinInt64))
+	addRandom(lib)
+	return lib
+}
+
+// checkFloat returns the i-th argument that must be a number
+// or a string convertible to a number, as a float.
+func checkFloat(d *meta.Dispatcher, fname string, args []interface{}, i int) (float64, error) {
+	n, err := checkNumber(d, fname, args, i)
+	if err != nil {
+		return 0, err
+	}
+	f, _ := number.ToFloat(n)
+	return f, nil
+}
+
+// floatFunction makes a library function of a function of floats.
+func floatFunction(name string, fn func(float64) float64) *Function {
+	return &Function{
+		Name: name,
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			x, err := checkFloat(d, name, args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{fn(x)}, nil
+		},
+	}
+}
+
+// floatToNumber returns a float with an integer value as an integer.
+func floatToNumber(f float64) interface{} {
+	if i, ok := number.FloatToInteger(f); ok {
+		return i
+	}
+	return f
+}
+
+// roundFunction makes floor or ceil, which keep integers as they are
+// and return integers for floats that fit.
+func roundFunction(name string, round func(float64) float64) *Function {
+	return &Function{
+		Name: name,
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			if i, ok := arg(args, 0).(int64); ok {
+				return []interface{}{i}, nil
+			}
+			x, err := checkFloat(d, name, args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{floatToNumber(round(x))}, nil
+		},
+	}
+}
+
+var (
+	mathFloor = roundFunction("floor", math.Floor)
+	mathCeil  = roundFunction("ceil", math.Ceil)
+)
+
+// mathAbs wraps around for the minimum integer, like Lua.
+var mathAbs = &Function{
+	Name: "abs",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if i, ok := arg(args, 0).(int64); ok {
+			if i < 0 {
+				i = -i
+			}
+			return []interface{}{i}, nil
+		}
+		x, err := checkFloat(d, "abs", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{math.Abs(x)}, nil
+	},
+}
+
+// mathFmod returns the remainder of a division rounded towards zero;
+// it is an integer for integer arguments.
+var mathFmod = &Function{
+	Name: "fmod",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		a, aok := arg(args, 0).(int64)
+		b, bok := arg(args, 1).(int64)
+		if aok && bok {
+			switch b {
+			case 0:
+				return nil, ArgError("fmod", 1, "zero")
+			case -1:
+				// avoids the overflow of the minimum integer
+				return []interface{}{int64(0)}, nil
+			}
+			return []interface{}{a % b}, nil
+		}
+		x, err := checkFloat(d, "fmod", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		y, err := checkFloat(d, "fmod", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{math.Mod(x, y)}, nil
+	},
+}
+
+// mathModf returns the integral part, rounded towards zero,
+// and the fractional part of a number, both as floats.
+var mathModf = &Function{
+	Name: "modf",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if i, ok := arg(args, 0).(int64); ok {
+			return []interface{}{i, 0.0}, nil
+		}
+		x, err := checkFloat(d, "modf", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		ip := math.Trunc(x)
+		frac := 0.0
+		if x != ip {
+			// inf has no fractional part
+			frac = x - ip
+		}
+		return []interface{}{ip, frac}, nil
+	},
+}
+
+// extremum makes max or min, which return the greatest or least
+// of their arguments, keeping its type.
+func extremum(name string, better func(a, b interface{}) bool) *Function {
+	return &Function{
+		Name: name,
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			res, err := checkNumber(d, name, args, 0)
+			if err != nil {
+				return nil, err
+			}
+			for i := 1; i < len(args); i++ {
+				n, err := checkNumber(d, name, args, i)
+				if err != nil {
+					return nil, err
+				}
+				if better(n, res) {
+					res = n
+				}
+			}
+			return []interface{}{res}, nil
+		},
+	}
+}
+
+var (
+	mathMax = extremum("max", func(a, b interface{}) bool { return number.LessThan(b, a) })
+	mathMin = extremum("min", number.LessThan)
+)
+
+// mathUlt compares two integers as unsigned integers.
+var mathUlt = &Function{
+	Name: "ult",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		a, err := checkInteger(d, "ult", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := checkInteger(d, "ult", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{uint64(a) < uint64(b)}, nil
+	},
+}
+
+// mathLog returns the natural logarithm, or the logarithm in a base.
+var mathLog = &Function{
+	Name: "log",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		x, err := checkFloat(d, "log", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		if arg(args, 1) == nil {
+			return []interface{}{math.Log(x)}, nil
+		}
+		base, err := checkFloat(d, "log", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		switch base {
+		case 2:
+			return []interface{}{math.Log2(x)}, nil
+		case 10:
+			return []interface{}{math.Log10(x)}, nil
+		}
+		return []interface{}{math.Log(x) / math.Log(base)}, nil
+	},
+}
+
+// mathAtan returns the arc tangent of y/x, using the signs of both
+// to find the quadrant; x is 1 by default.
+var mathAtan = &Function{
+	Name: "atan",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		y, err := checkFloat(d, "atan", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		x := 1.0
+		if arg(args, 1) != nil {
+			if x, err = checkFloat(d, "atan", args, 1); err != nil {
+				return nil, err
+			}
+		}
+		return []interface{}{math.Atan2(y, x)}, nil
+	},
+}
+
+var mathType = &Function{
+	Name: "type",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if len(args) == 0 {
+			return nil, ArgError("type", 0, "value expected")
+		}
+		if t := number.Type(args[0]); t != "" {
+			return []interface{}{t}, nil
+		}
+		return []interface{}{nil}, nil
+	},
+}
+
+var mathToInteger = &Function{
+	Name: "tointeger",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		if len(args) == 0 {
+			return nil, ArgError("tointeger", 0, "value expected")
+		}
+		if i, ok := number.ToInteger(args[0]); ok {
+			return []interface{}{i}, nil
+		}
+		return []interface{}{nil}, nil
+	},
+}
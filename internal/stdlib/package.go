@@ -0,0 +1,238 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/table"
+)
+
+var (
+	ErrSearchers = errors.New("'package.searchers' must be a table")
+	ErrPreload   = errors.New("'package.preload' must be a table")
+	ErrPath      = errors.New("'package.path' must be a string")
+)
+
+// defaultPath is the package.path used when LUA_PATH is not set;
+// ";;" in LUA_PATH stands for it.
+const defaultPath = "./?.lua;./?/init.lua"
+
+// config describes the path templates, like package.config: the directory
+// separator, the template separator, the name mark, the executable
+// directory mark and the mark of the part of a name to ignore.
+const config = "/\n;\n?\n!\n-\n"
+
+// newPackageLib returns the package library, with the libraries
+// of globals in package.loaded.
+func newPackageLib(globals *table.Table) *table.Table {
+	lib := table.New(0, 6)
+	loaded := table.New(0, 0)
+	for name, v, _ := globals.Next(nil); name != nil; name, v, _ = globals.Next(name) {
+		if _, ok := v.(*table.Table); ok {
+			_ = loaded.Set(name, v)
+		}
+	}
+	_ = loaded.Set("package", lib)
+	searchers := table.New(2, 0)
+	_ = searchers.Set(int64(1), newPreloadSearcher(lib))
+	_ = searchers.Set(int64(2), newLuaSearcher(lib))
+
+	_ = lib.Set("loaded", loaded)
+	_ = lib.Set("preload", table.New(0, 0))
+	_ = lib.Set("searchers", searchers)
+	_ = lib.Set("path", luaPath())
+	_ = lib.Set("config", config)
+	_ = lib.Set("searchpath", pkgSearchpath)
+	return lib
+}
+
+// luaPath returns the initial package.path, from LUA_PATH_5_4 or LUA_PATH.
+func luaPath() string {
+	path, ok := os.LookupEnv("LUA_PATH_5_4")
+	if !ok {
+		path, ok = os.LookupEnv("LUA_PATH")
+	}
+	if !ok {
+		return defaultPath
+	}
+	if i := strings.Index(path, ";;"); i >= 0 {
+		path = strings.Trim(path[:i]+";"+defaultPath+";"+path[i+2:], ";")
+	}
+	return path
+}
+
+// newRequire returns require, which loads a module with the first searcher
+// of package.searchers that finds it, calls its loader with the name
+// of the module and the data of the searcher, and keeps its result
+// in package.loaded. Requiring a module while it loads is an error.
+func newRequire(lib *table.Table) *Function {
+	loading := make(map[string]bool)
+	return &Function{
+		Name: "require",
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			name, err := checkString(d, "require", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			loaded, ok := lib.Get("loaded").(*table.Table)
+			if !ok {
+				return nil, errors.New("'package.loaded' must be a table")
+			}
+			if v := loaded.Get(name); meta.Truthy(v) {
+				return []interface{}{v}, nil
+			}
+			if loading[name] {
+				return nil, fmt.Errorf("loop or previous error loading module '%s'", name)
+			}
+			loader, data, err := findLoader(d, lib, name)
+			if err != nil {
+				return nil, err
+			}
+			loading[name] = true
+			defer delete(loading, name)
+			res, err := d.Callback(loader, []interface{}{name, data})
+			if err != nil {
+				return nil, err
+			}
+			if v := arg(res, 0); v != nil {
+				_ = loaded.Set(name, v)
+			} else if loaded.Get(name) == nil {
+				_ = loaded.Set(name, true)
+			}
+			return []interface{}{loaded.Get(name), data}, nil
+		},
+	}
+}
+
+// findLoader calls the searchers until one returns a loader, and returns
+// it with its data. The messages of the other searchers explain
+// why the module was not found.
+func findLoader(d *meta.Dispatcher, lib *table.Table, name string) (interface{}, interface{}, error) {
+	searchers, ok := lib.Get("searchers").(*table.Table)
+	if !ok {
+		return nil, nil, ErrSearchers
+	}
+	var msg strings.Builder
+	for i := int64(1); ; i++ {
+		searcher := searchers.Get(i)
+		if searcher == nil {
+			return nil, nil, fmt.Errorf("module '%s' not found:%s", name, msg.String())
+		}
+		res, err := d.Callback(searcher, []interface{}{name})
+		if err != nil {
+			return nil, nil, err
+		}
+		v := arg(res, 0)
+		if s, ok := v.(string); ok {
+			msg.WriteString("\n\t" + s)
+		} else if d.TypeName(v) == "function" {
+			return v, arg(res, 1), nil
+		}
+	}
+}
+
+// newPreloadSearcher returns the searcher of the loaders in package.preload.
+func newPreloadSearcher(lib *table.Table) *Function {
+	return &Function{
+		Name: "searcher_preload",
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			name, err := checkString(d, "searcher_preload", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			preload, ok := lib.Get("preload").(*table.Table)
+			if !ok {
+				return nil, ErrPreload
+			}
+			if loader := preload.Get(name); loader != nil {
+				return []interface{}{loader, ":preload:"}, nil
+			}
+			return []interface{}{fmt.Sprintf("no field package.preload['%s']", name)}, nil
+		},
+	}
+}
+
+// newLuaSearcher returns the searcher of Lua files in package.path.
+// The loader it returns is the compiled file, and its data the file name.
+func newLuaSearcher(lib *table.Table) *Function {
+	return &Function{
+		Name: "searcher_Lua",
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			name, err := checkString(d, "searcher_Lua", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			path, ok := lib.Get("path").(string)
+			if !ok {
+				return nil, ErrPath
+			}
+			filename, msg := searchPath(name, path, ".", "/")
+			if filename == "" {
+				return []interface{}{msg}, nil
+			}
+			src, chunkname, err := readFile(filename)
+			if err == nil {
+				var fn interface{}
+				if fn, err = compileChunk(d, src, chunkname, "bt", nil); err == nil {
+					return []interface{}{fn, filename}, nil
+				}
+			}
+			return nil, fmt.Errorf("error loading module '%s' from file '%s':\n\t%s", name, filename, err)
+		},
+	}
+}
+
+// searchPath returns the first file that can be read among the templates
+// of path, separated by ';', where '?' stands for the name with sep
+// replaced by rep. If there is none, it returns the message that lists
+// the files tried.
+func searchPath(name, path, sep, rep string) (string, string) {
+	if sep != "" {
+		name = strings.ReplaceAll(name, sep, rep)
+	}
+	var tried []string
+	for _, template := range strings.Split(path, ";") {
+		if template == "" {
+			continue
+		}
+		filename := strings.ReplaceAll(template, "?", name)
+		if f, err := os.Open(filename); err == nil {
+			f.Close()
+			return filename, ""
+		}
+		tried = append(tried, fmt.Sprintf("no file '%s'", filename))
+	}
+	return "", strings.Join(tried, "\n\t")
+}
+
+// pkgSearchpath returns the file that require would load for a name
+// from a path, or nil and the list of the files tried.
+var pkgSearchpath = &Function{
+	Name: "searchpath",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		name, err := checkString(d, "searchpath", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		path, err := checkString(d, "searchpath", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		sep, err := optString(d, "searchpath", args, 2, ".")
+		if err != nil {
+			return nil, err
+		}
+		rep, err := optString(d, "searchpath", args, 3, "/")
+		if err != nil {
+			return nil, err
+		}
+		filename, msg := searchPath(name, path, sep, rep)
+		if filename == "" {
+			return []interface{}{nil, msg}, nil
+		}
+		return []interface{}{filename}, nil
+	},
+}
@@ -0,0 +1,127 @@
+package stdlib
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"time"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/table"
+)
+
+// rng is the xoshiro256** generator of math.random, as in Lua 5.4,
+// so that seeded sequences are the same as in the reference
+// implementation. Each math library has its own generator.
+type rng [4]uint64
+
+func (r *rng) next() uint64 {
+	res := bits.RotateLeft64(r[1]*5, 7) * 9
+	t := r[1] << 17
+	r[2] ^= r[0]
+	r[3] ^= r[1]
+	r[1] ^= r[2]
+	r[0] ^= r[3]
+	r[2] ^= t
+	r[3] = bits.RotateLeft64(r[3], 45)
+	return res
+}
+
+// seed sets the state from two integers and discards
+// the first values to spread the seed.
+func (r *rng) seed(n1, n2 uint64) {
+	*r = rng{n1, 0xff, n2, 0}
+	for i := 0; i < 16; i++ {
+		r.next()
+	}
+}
+
+// randomFloat converts a random value to a float in [0, 1)
+// from its 53 higher bits.
+func randomFloat(v uint64) float64 {
+	return float64(v>>11) * 0x1p-53
+}
+
+// project projects a random value into [0, n], drawing new values
+// instead of taking a remainder so that all results are equally likely.
+func (r *rng) project(ran, n uint64) uint64 {
+	if n&(n+1) == 0 {
+		// n + 1 is a power of 2
+		return ran & n
+	}
+	// the smallest 2^b - 1 not smaller than n
+	lim := n
+	lim |= lim >> 1
+	lim |= lim >> 2
+	lim |= lim >> 4
+	lim |= lim >> 8
+	lim |= lim >> 16
+	lim |= lim >> 32
+	for ran &= lim; ran > n; ran &= lim {
+		ran = r.next()
+	}
+	return ran
+}
+
+// addRandom adds random and randomseed to the math library, sharing
+// a generator seeded with a random value.
+func addRandom(lib *table.Table) {
+	r := &rng{}
+	r.seed(uint64(time.Now().UnixNano()), rand.Uint64())
+
+	_ = lib.Set("random", &Function{
+		Name: "random",
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			rv := r.next()
+			var low, up int64
+			var err error
+			switch len(args) {
+			case 0:
+				return []interface{}{randomFloat(rv)}, nil
+			case 1:
+				low = 1
+				if up, err = checkInteger(d, "random", args, 0); err != nil {
+					return nil, err
+				}
+				if up == 0 {
+					// a random integer with all bits random
+					return []interface{}{int64(rv)}, nil
+				}
+			case 2:
+				if low, err = checkInteger(d, "random", args, 0); err != nil {
+					return nil, err
+				}
+				if up, err = checkInteger(d, "random", args, 1); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, errWrongArgs
+			}
+			if low > up {
+				return nil, ArgError("random", 0, "interval is empty")
+			}
+			return []interface{}{int64(r.project(rv, uint64(up)-uint64(low)) + uint64(low))}, nil
+		},
+	})
+
+	// randomseed seeds the generator with one or two integers, or with
+	// a random value, and returns the two components of the seed
+	_ = lib.Set("randomseed", &Function{
+		Name: "randomseed",
+		Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+			var n1, n2 int64
+			if len(args) == 0 {
+				n1, n2 = int64(time.Now().UnixNano()), int64(rand.Uint64())
+			} else {
+				var err error
+				if n1, err = checkInteger(d, "randomseed", args, 0); err != nil {
+					return nil, err
+				}
+				if n2, err = optInteger(d, "randomseed", args, 1, 0); err != nil {
+					return nil, err
+				}
+			}
+			r.seed(uint64(n1), uint64(n2))
+			return []interface{}{n1, n2}, nil
+		},
+	})
+}
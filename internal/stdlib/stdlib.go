@@ -0,0 +1,181 @@
+// Package stdlib implements the Lua standard library functions
+// that work the same way in both engines.
+package stdlib
+
+import (
+	"fmt"
+	"strconv"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/table"
+)
+
+// Function is a library function. It gets the dispatcher of the engine
+// that calls it, so that it can call functions and metamethods.
+type Function struct {
+	Name string
+	Fn   func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error)
+}
+
+// Globals returns a new global environment with the library values: the
+// table of the global variables, which is also the value of _G.
+func Globals() *table.Table {
+	libs := map[string]interface{}{
+		"setmetatable":   setMetatable,
+		"getmetatable":   getMetatable,
+		"type":           luaType,
+		"tostring":       tostring,
+		"tonumber":       tonumber,
+		"select":         luaSelect,
+		"rawget":         rawget,
+		"rawset":         rawset,
+		"rawequal":       rawequal,
+		"rawlen":         rawlen,
+		"unpack":         tabUnpack,
+		"collectgarbage": newCollectGarbage(),
+		"load":           load,
+		"loadfile":       loadfile,
+		"dofile":         dofile,
+		"next":           next,
+		"pairs":          pairs,
+		"ipairs":         ipairs,
+		"error":          luaError,
+		"pcall":          pcall,
+		"xpcall":         xpcall,
+		"assert":         assert,
+		"math":           newMathLib(),
+		"coroutine":      newCoroutineLib(),
+		"string":         newStringLib(),
+		"table":          newTableLib(),
+	}
+	globals := table.New(0, len(libs)+4)
+	for name, v := range libs {
+		_ = globals.Set(name, v)
+	}
+	_ = globals.Set("_G", globals)
+	_ = globals.Set("_VERSION", "Lua 5.4")
+	lib := newPackageLib(globals)
+	_ = globals.Set("package", lib)
+	_ = globals.Set("require", newRequire(lib))
+	return globals
+}
+
+// StringMetatable returns the metatable shared by all strings, which
+// indexes the string library of globals, so that s:upper() works.
+func StringMetatable(globals *table.Table) *table.Table {
+	mt := table.New(0, 1)
+	_ = mt.Set("__index", globals.Get("string"))
+	return mt
+}
+
+// ArgError is the error of a bad argument to a library function.
+func ArgError(fname string, i int, msg string) error {
+	return fmt.Errorf("bad argument #%d to '%s' (%s)", i+1, fname, msg)
+}
+
+// TypeError is the error of an argument of a wrong type.
+func TypeError(d *meta.Dispatcher, fname string, args []interface{}, i int, expected string) error {
+	got := "no value"
+	if i < len(args) {
+		got = d.TypeName(args[i])
+	}
+	return ArgError(fname, i, fmt.Sprintf("%s expected, got %s", expected, got))
+}
+
+// CheckTable returns the i-th argument that must be a table.
+func CheckTable(d *meta.Dispatcher, fname string, args []interface{}, i int) (*table.Table, error) {
+	if i < len(args) {
+		if t, ok := args[i].(*table.Table); ok {
+			return t, nil
+		}
+	}
+	return nil, TypeError(d, fname, args, i, "table")
+}
+
+// checkAny returns the i-th argument, which may be nil but not absent.
+func checkAny(fname string, args []interface{}, i int) (interface{}, error) {
+	if i < len(args) {
+		return args[i], nil
+	}
+	return nil, ArgError(fname, i, "value expected")
+}
+
+// arg returns the i-th argument or nil.
+func arg(args []interface{}, i int) interface{} {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// checkString returns the i-th argument that must be a string
+// or a number, which is converted to a string.
+func checkString(d *meta.Dispatcher, fname string, args []interface{}, i int) (string, error) {
+	if i < len(args) {
+		switch v := args[i].(type) {
+		case string:
+			return v, nil
+		case int64, float64:
+			return number.ToString(v), nil
+		}
+	}
+	return "", TypeError(d, fname, args, i, "string")
+}
+
+// optString returns the i-th argument like checkString, or def if it is absent or nil.
+func optString(d *meta.Dispatcher, fname string, args []interface{}, i int, def string) (string, error) {
+	if arg(args, i) == nil {
+		return def, nil
+	}
+	return checkString(d, fname, args, i)
+}
+
+// checkNumber returns the i-th argument that must be a number
+// or a string convertible to a number.
+func checkNumber(d *meta.Dispatcher, fname string, args []interface{}, i int) (interface{}, error) {
+	if n, ok := meta.ToNumber(arg(args, i)); ok {
+		return n, nil
+	}
+	return nil, TypeError(d, fname, args, i, "number")
+}
+
+// checkInteger returns the i-th argument that must be a number
+// or a string with an integer value.
+func checkInteger(d *meta.Dispatcher, fname string, args []interface{}, i int) (int64, error) {
+	n, err := checkNumber(d, fname, args, i)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := number.ToInteger(n)
+	if !ok {
+		return 0, ArgError(fname, i, "number has no integer representation")
+	}
+	return v, nil
+}
+
+// optInteger returns the i-th argument like checkInteger, or def if it is absent or nil.
+func optInteger(d *meta.Dispatcher, fname string, args []interface{}, i int, def int64) (int64, error) {
+	if arg(args, i) == nil {
+		return def, nil
+	}
+	return checkInteger(d, fname, args, i)
+}
+
+// toString converts a value the way tostring does.
+func toString(d *meta.Dispatcher, v interface{}) (string, error) {
+	return d.ToString(v, func(v interface{}) string {
+		switch v := v.(type) {
+		case nil:
+			return "nil"
+		case bool:
+			return strconv.FormatBool(v)
+		case string:
+			return v
+		case int64, float64:
+			return number.ToString(v)
+		default:
+			return fmt.Sprintf("%s: %p", d.TypeName(v), v)
+		}
+	})
+}
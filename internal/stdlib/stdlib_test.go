@@ -0,0 +1,58 @@
+package stdlib_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"lua-interpreter/internal/ast"
+	"lua-interpreter/internal/interpreter"
+)
+
+// engines are the two ways to run a script
+var engines = map[string]func(string) (ast.Value, error){
+	"ast": interpreter.Eval,
+	"vm":  interpreter.EvalWithStackMachine,
+}
+
+// scriptTest is a script and the value it returns.
+type scriptTest struct {
+	script   string
+	expected interface{}
+}
+
+// errorTest is a one-line script and the message of the error it raises.
+type errorTest struct {
+	script   string
+	expected string
+}
+
+type StdlibSuite struct {
+	suite.Suite
+}
+
+func TestStdlibSuite(t *testing.T) {
+	suite.Run(t, new(StdlibSuite))
+}
+
+// run runs the scripts on both engines, each after defs.
+func (s *StdlibSuite) run(defs string, tests []scriptTest) {
+	for name, eval := range engines {
+		for _, test := range tests {
+			v, err := eval(defs + test.script)
+			s.NoError(err, "%s: %s", name, test.script)
+			s.Equal(test.expected, v, "%s: %s", name, test.script)
+		}
+	}
+}
+
+// runChunkErrors runs the scripts on both engines and checks the errors
+// they raise at their start.
+func (s *StdlibSuite) runChunkErrors(tests []errorTest) {
+	for name, eval := range engines {
+		for _, test := range tests {
+			_, err := eval(test.script)
+			s.EqualError(err, `[string "`+test.script+`"]:1:1: `+test.expected, "%s: %s", name, test.script)
+		}
+	}
+}
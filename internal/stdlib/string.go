@@ -0,0 +1,235 @@
+package stdlib
+
+import (
+	"errors"
+	"strings"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/table"
+)
+
+// maxStringSize limits the strings built by the library, so that a huge
+// string.rep fails with an error instead of exhausting the memory.
+const maxStringSize = 1 << 31
+
+var ErrStringTooLarge = errors.New("resulting string too large")
+
+func newStringLib() *table.Table {
+	lib := table.New(0, 13)
+	_ = lib.Set("len", strLen)
+	_ = lib.Set("sub", strSub)
+	_ = lib.Set("upper", strUpper)
+	_ = lib.Set("lower", strLower)
+	_ = lib.Set("rep", strRep)
+	_ = lib.Set("reverse", strReverse)
+	_ = lib.Set("byte", strByte)
+	_ = lib.Set("char", strChar)
+	_ = lib.Set("format", strFormat)
+	_ = lib.Set("find", strFind)
+	_ = lib.Set("match", strMatch)
+	_ = lib.Set("gmatch", strGmatch)
+	_ = lib.Set("gsub", strGsub)
+	return lib
+}
+
+// startPos converts a relative initial position to an absolute one
+// in a string of length n: negative positions count from the end,
+// and positions before the start are clipped to 1.
+func startPos(pos int64, n int) int64 {
+	switch {
+	case pos > 0:
+		return pos
+	case pos == 0 || pos < -int64(n):
+		return 1
+	default:
+		return int64(n) + pos + 1
+	}
+}
+
+// endPos converts a relative final position to an absolute one
+// in a string of length n, clipping it to [0, n].
+func endPos(pos int64, n int) int64 {
+	switch {
+	case pos > int64(n):
+		return int64(n)
+	case pos >= 0:
+		return pos
+	case pos < -int64(n):
+		return 0
+	default:
+		return int64(n) + pos + 1
+	}
+}
+
+var strLen = &Function{
+	Name: "len",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "len", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{int64(len(s))}, nil
+	},
+}
+
+var strSub = &Function{
+	Name: "sub",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "sub", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		i, err := checkInteger(d, "sub", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		j, err := optInteger(d, "sub", args, 2, -1)
+		if err != nil {
+			return nil, err
+		}
+		start, end := startPos(i, len(s)), endPos(j, len(s))
+		if start > end {
+			return []interface{}{""}, nil
+		}
+		return []interface{}{s[start-1 : end]}, nil
+	},
+}
+
+// mapBytes returns s with f applied to each of its bytes.
+func mapBytes(s string, f func(byte) byte) string {
+	b := []byte(s)
+	for i, c := range b {
+		b[i] = f(c)
+	}
+	return string(b)
+}
+
+// strUpper converts ASCII letters only, like Lua in the C locale.
+var strUpper = &Function{
+	Name: "upper",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "upper", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{mapBytes(s, func(c byte) byte {
+			if 'a' <= c && c <= 'z' {
+				return c - 'a' + 'A'
+			}
+			return c
+		})}, nil
+	},
+}
+
+var strLower = &Function{
+	Name: "lower",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "lower", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{mapBytes(s, func(c byte) byte {
+			if 'A' <= c && c <= 'Z' {
+				return c - 'A' + 'a'
+			}
+			return c
+		})}, nil
+	},
+}
+
+// strRep returns n copies of a string separated by an optional separator.
+var strRep = &Function{
+	Name: "rep",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "rep", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		n, err := checkInteger(d, "rep", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		sep, err := optString(d, "rep", args, 2, "")
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return []interface{}{""}, nil
+		}
+		if l := int64(len(s) + len(sep)); l > 0 && l > maxStringSize/n {
+			return nil, ErrStringTooLarge
+		}
+		var sb strings.Builder
+		sb.Grow(int(n)*(len(s)+len(sep)) - len(sep))
+		for i := int64(0); i < n; i++ {
+			if i > 0 {
+				sb.WriteString(sep)
+			}
+			sb.WriteString(s)
+		}
+		return []interface{}{sb.String()}, nil
+	},
+}
+
+var strReverse = &Function{
+	Name: "reverse",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "reverse", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, len(s))
+		for i := range b {
+			b[i] = s[len(s)-1-i]
+		}
+		return []interface{}{string(b)}, nil
+	},
+}
+
+// strByte returns the codes of the bytes from i (1 by default)
+// to j (i by default).
+var strByte = &Function{
+	Name: "byte",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		s, err := checkString(d, "byte", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		i, err := optInteger(d, "byte", args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		start := startPos(i, len(s))
+		j, err := optInteger(d, "byte", args, 2, start)
+		if err != nil {
+			return nil, err
+		}
+		end := endPos(j, len(s))
+		if start > end {
+			return nil, nil
+		}
+		res := make([]interface{}, 0, end-start+1)
+		for k := start - 1; k < end; k++ {
+			res = append(res, int64(s[k]))
+		}
+		return res, nil
+	},
+}
+
+var strChar = &Function{
+	Name: "char",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		b := make([]byte, len(args))
+		for i := range args {
+			c, err := checkInteger(d, "char", args, i)
+			if err != nil {
+				return nil, err
+			}
+			if c < 0 || c > 255 {
+				return nil, ArgError("char", i, "value out of range")
+			}
+			b[i] = byte(c)
+		}
+		return []interface{}{string(b)}, nil
+	},
+}
@@ -0,0 +1,99 @@
+package stdlib_test
+
+func (s *StdlibSuite) TestStringLibrary() {
+	s.run("", []scriptTest{
+		{"return string.len('abc') + #string.upper('x')", int64(4)},
+		{"local s = 'Hello' return s:upper() .. s:lower() .. s:reverse()", "HELLOhelloolleH"},
+		{"return ('x'):rep(3) .. ('ab'):rep(3, ',') .. ('x'):rep(0)", "xxxab,ab,ab"},
+		{"local s = 'Hello' return s:sub(2, 3) .. s:sub(-3) .. s:sub(0) .. s:sub(10) .. s:sub(2, -2) .. s:sub(-100, 2)", "ellloHelloellHe"},
+		{"local a, b, c = ('Hello'):byte(1, -3) return a + b + c", int64(72 + 101 + 108)},
+		{"return ('Hello'):byte(-1)", int64(111)},
+		{"return ('x'):byte(2)", nil},
+		{"return string.char(72, 105) .. string.char()", "Hi"},
+		{"return string.len(123) + string.len(1.5)", int64(6)},
+		// the string metatable indexes the string table
+		{"return getmetatable('').__index == string", true},
+		{"string.twice = function(s) return s .. s end return ('ab'):twice()", "abab"},
+		{"return ('%d-%s'):format(5, 'x')", "5-x"},
+
+		// string.format
+		{"return string.format('[%5d|%-5d|%05d|%+d|% d|%.3d|%5.d]', 42, 42, -42, 42, 42, 7, 0)", "[   42|42   |-0042|+42| 42|007|     ]"},
+		{"return string.format('%x %X %#x %#x %o %#o %u %x', 255, 255, 255, 0, 8, 8, 42, -1)", "ff FF 0xff 0 10 010 42 ffffffffffffffff"},
+		{"return string.format('%d %i %c%c', 3.0, '10', 76, 117)", "3 10 Lu"},
+		{"return string.format('%e|%.2E|%10.3f|%-8.2f|', 12345.678, 0.00012, 3.14159, 2.5)", "1.234568e+04|1.20E-04|     3.142|2.50    |"},
+		{"return string.format('%g %g %g %g %G %#g %.3g', 100000, 1e6, 1/3, 1e-5, 1e-10, 1, 1234567)", "100000 1e+06 0.333333 1e-05 1E-10 1.00000 1.23e+06"},
+		{"return string.format('%a %A %.3a %a %a %010a %#a', 1, 1.5, 1, 0.1, 0, 1, 1)", "0x1p+0 0X1.8P+0 0x1.000p+0 0x1.999999999999ap-4 0x0p+0 0x00001p+0 0x1.p+0"},
+		{"return string.format('%f %e %5.1f|%-5g|%+f %F', 1/0, -1/0, 1/0, 1/0, 1/0, 1/0)", "inf -inf   inf|inf  |+inf INF"},
+		{"return string.format('%s %10s %-4s| %.2s %5.2s %s %s', 'x', 'hi', 'hi', 'hello', 'abc', 1, 2.0)", "x         hi hi  | he    ab 1 2.0"},
+		{"return string.format('%s %s', true, nil)", "true nil"},
+		{"return string.format('%s', setmetatable({}, {__tostring = function() return 'obj' end}))", "obj"},
+		{"return string.format('100%%')", "100%"},
+		{`return string.format('%q', 'a "b"\n\0c\r1\\')`, "\"a \\\"b\\\"\\\n\\0c\\0131\\\\\""},
+		{"return string.format('%q %q %q %q %q %q', 1, 1.5, 1/0, -1/0, -9223372036854775807 - 1, 2^63)", "1 0x1.8p+0 1e9999 -1e9999 0x8000000000000000 0x1p+63"},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"string.format('%d', 1.5)", "bad argument #2 to 'format' (number has no integer representation)"},
+		{"string.format('%d', 'x')", "bad argument #2 to 'format' (number expected, got string)"},
+		{"string.format('%d %d', 1)", "bad argument #3 to 'format' (no value)"},
+		{"string.format('%y', 1)", "invalid conversion '%y' to 'format'"},
+		{"string.format('%', 1)", "invalid conversion '%' to 'format'"},
+		{"string.format('%#d', 1)", "invalid conversion specification: '%#d'"},
+		{"string.format('%123d', 1)", "invalid conversion specification: '%123d'"},
+		{"string.format('%.3c', 1)", "invalid conversion specification: '%.3c'"},
+		{"string.format('%10q', 1)", "specifier '%q' cannot have modifiers"},
+		{"string.format('%q', {})", "bad argument #2 to 'format' (value has no literal form)"},
+		{"string.format('%-----------------------d', 1)", "invalid format string to 'format'"},
+		{"string.format('%5s', 'a\\0')", "bad argument #2 to 'format' (string contains zeros)"},
+		{"string.char(256)", "bad argument #1 to 'char' (value out of range)"},
+		{"string.char(-1)", "bad argument #1 to 'char' (value out of range)"},
+		{"string.rep()", "bad argument #1 to 'rep' (string expected, got no value)"},
+		{"string.rep('x', 1e10)", "resulting string too large"},
+		{"string.sub('x')", "bad argument #2 to 'sub' (number expected, got no value)"},
+		{"string.upper({})", "bad argument #1 to 'upper' (string expected, got table)"},
+	})
+}
+
+func (s *StdlibSuite) TestPatternMatching() {
+	s.run("", []scriptTest{
+		{"local a, b = string.find('hello world', 'o w') return a * 100 + b", int64(507)},
+		{"local a, b, c, d = ('hello'):find('()ll()') return a .. b .. c .. d", "3435"},
+		{"local a, b = ('a+b'):find('+', 1, true) return a + b", int64(4)},
+		{"return ('abc'):find('b', -1)", nil},
+		{"return ('abc'):find('', 10)", nil},
+		{"local a, b = ('abc'):find('', 4) return a .. b", "43"},
+		{"local k, v = ('key = value'):match('(%w+)%s*=%s*(%w+)') return k .. v", "keyvalue"},
+		{"return ('  trim  '):match('^%s*(.-)%s*$')", "trim"},
+		{"return ('f(a(b)c)d'):match('%b()')", "(a(b)c)"},
+		{"return ('say \"hi\" now'):match([[(['\"])(.-)%1]])", "\""},
+		{"return ('hello'):match('^e')", nil},
+		{"local s = '' for k, v in ('a=1, b=2'):gmatch('(%w+)=(%w+)') do s = s .. k .. v end return s", "a1b2"},
+		{"local s = '' for w in ('abc'):gmatch('') do s = s .. '[' .. w .. ']' end return s", "[][][][]"},
+		{"local s = '' for w in ('hello world'):gmatch('%a+', 3) do s = s .. w .. ';' end return s", "llo;world;"},
+		{"return (('hello world'):gsub('o', '0'))", "hell0 w0rld"},
+		{"local s, n = ('hello world'):gsub('(%w+)', '<%1>') return s .. n", "<hello> <world>2"},
+		{"local s, n = ('hello world'):gsub('%w+', '%0 %0', 1) return s .. n", "hello hello world1"},
+		{"local s, n = ('abc'):gsub('', '-') return s .. n", "-a-b-c-4"},
+		{"return (('$name is $age'):gsub('%$(%w+)', { name = 'Bob', age = 42 }))", "Bob is 42"},
+		{"return (('abc'):gsub('%w', function(c) if c ~= 'b' then return c:upper() .. '.' end end))", "A.bC."},
+		{"return (('hello hello'):gsub('^h', 'H'))", "Hello hello"},
+		{"return (('abc'):gsub('()', '%1'))", "1a2b3c4"},
+		{"return (('THE (quick) fox'):gsub('%f[%a]%a+', 'W'))", "W (W) W"},
+		// table replacements respect __index
+		{"local t = setmetatable({}, { __index = function(_, k) return k:upper() end }) return (('a b'):gsub('%a', t))", "A B"},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"string.find('a', '%')", "malformed pattern (ends with '%')"},
+		{"string.find('a', '[a')", "malformed pattern (missing ']')"},
+		{"string.find('a', '%b')", "malformed pattern (missing arguments to '%b')"},
+		{"string.find('a', '%f')", "missing '[' after '%f' in pattern"},
+		{"string.find('a', '(a')", "unfinished capture"},
+		{"string.match('a', 'a)')", "invalid pattern capture"},
+		{"string.match('a', '%1')", "invalid capture index %1"},
+		{"string.gsub('a', 'a', '%2')", "invalid capture index %2"},
+		{"string.gsub('a', 'a', '%x')", "invalid use of '%' in replacement string"},
+		{"string.gsub('a', 'a')", "bad argument #3 to 'gsub' (string/function/table expected, got no value)"},
+		{"string.gsub('a', 'a', { a = {} })", "invalid replacement value (a table)"},
+	})
+}
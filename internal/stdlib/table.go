@@ -0,0 +1,443 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/table"
+)
+
+var (
+	ErrInvalidOrder = errors.New("invalid order function for sorting")
+	ErrLength       = errors.New("object length is not an integer")
+)
+
+// maxUnpack limits the number of values table.unpack returns,
+// like the stack limit of Lua.
+const maxUnpack = 1000000
+
+func newTableLib() *table.Table {
+	lib := table.New(0, 7)
+	_ = lib.Set("insert", tabInsert)
+	_ = lib.Set("remove", tabRemove)
+	_ = lib.Set("concat", tabConcat)
+	_ = lib.Set("sort", tabSort)
+	_ = lib.Set("unpack", tabUnpack)
+	_ = lib.Set("pack", tabPack)
+	_ = lib.Set("move", tabMove)
+	return lib
+}
+
+// length returns the length of a value with the # operator,
+// which must be an integer.
+func length(d *meta.Dispatcher, v interface{}) (int64, error) {
+	l, err := d.Len(v)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := meta.ToNumber(l)
+	i, ok := number.ToInteger(n)
+	if !ok {
+		return 0, ErrLength
+	}
+	return i, nil
+}
+
+// checkLength returns the i-th argument that must be a table, and its length.
+func checkLength(d *meta.Dispatcher, fname string, args []interface{}, i int) (*table.Table, int64, error) {
+	t, err := CheckTable(d, fname, args, i)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := length(d, t)
+	return t, n, err
+}
+
+// tabInsert inserts a value at the end of a list, or at a position
+// in [1, #t + 1], shifting up the following elements.
+var tabInsert = &Function{
+	Name: "insert",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, n, err := checkLength(d, "insert", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		e := n + 1
+		var pos int64
+		switch len(args) {
+		case 2:
+			pos = e
+		case 3:
+			if pos, err = checkInteger(d, "insert", args, 1); err != nil {
+				return nil, err
+			}
+			if uint64(pos)-1 >= uint64(e) {
+				return nil, ArgError("insert", 1, "position out of bounds")
+			}
+			for i := e; i > pos; i-- {
+				v, err := d.Index(t, i-1)
+				if err != nil {
+					return nil, err
+				}
+				if err := d.SetIndex(t, i, v); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, errors.New("wrong number of arguments to 'insert'")
+		}
+		return nil, d.SetIndex(t, pos, args[len(args)-1])
+	},
+}
+
+// tabRemove removes and returns the last element of a list, or the one
+// at a position in [1, #t + 1], shifting down the following elements.
+var tabRemove = &Function{
+	Name: "remove",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, size, err := checkLength(d, "remove", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		pos, err := optInteger(d, "remove", args, 1, size)
+		if err != nil {
+			return nil, err
+		}
+		if pos != size && uint64(pos)-1 > uint64(size) {
+			return nil, ArgError("remove", 1, "position out of bounds")
+		}
+		res, err := d.Index(t, pos)
+		if err != nil {
+			return nil, err
+		}
+		for ; pos < size; pos++ {
+			v, err := d.Index(t, pos+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetIndex(t, pos, v); err != nil {
+				return nil, err
+			}
+		}
+		return []interface{}{res}, d.SetIndex(t, pos, nil)
+	},
+}
+
+// tabConcat joins the strings and numbers t[i] to t[j] with a separator.
+var tabConcat = &Function{
+	Name: "concat",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, last, err := checkLength(d, "concat", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		sep, err := optString(d, "concat", args, 1, "")
+		if err != nil {
+			return nil, err
+		}
+		i, err := optInteger(d, "concat", args, 2, 1)
+		if err != nil {
+			return nil, err
+		}
+		if last, err = optInteger(d, "concat", args, 3, last); err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		for ; i <= last; i++ {
+			v, err := d.Index(t, i)
+			if err != nil {
+				return nil, err
+			}
+			switch v := v.(type) {
+			case string:
+				sb.WriteString(v)
+			case int64, float64:
+				sb.WriteString(number.ToString(v))
+			default:
+				return nil, fmt.Errorf("invalid value (at index %d) in table for 'concat'", i)
+			}
+			if i < last {
+				sb.WriteString(sep)
+			}
+			if i == math.MaxInt64 {
+				break
+			}
+		}
+		return []interface{}{sb.String()}, nil
+	},
+}
+
+// tabUnpack returns t[i] to t[j], by default the whole list.
+var tabUnpack = &Function{
+	Name: "unpack",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t := arg(args, 0)
+		i, err := optInteger(d, "unpack", args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		var e int64
+		if arg(args, 2) == nil {
+			if e, err = length(d, t); err != nil {
+				return nil, err
+			}
+		} else if e, err = checkInteger(d, "unpack", args, 2); err != nil {
+			return nil, err
+		}
+		if i > e {
+			return nil, nil
+		}
+		if uint64(e)-uint64(i) >= maxUnpack {
+			return nil, errors.New("too many results to unpack")
+		}
+		res := make([]interface{}, 0, e-i+1)
+		for ; ; i++ {
+			v, err := d.Index(t, i)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+			if i == e {
+				return res, nil
+			}
+		}
+	},
+}
+
+// tabPack returns a list of its arguments, with their number in field n.
+var tabPack = &Function{
+	Name: "pack",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t := table.New(len(args), 1)
+		for i, v := range args {
+			_ = t.Set(int64(i+1), v)
+		}
+		_ = t.Set("n", int64(len(args)))
+		return []interface{}{t}, nil
+	},
+}
+
+// tabMove copies a1[f] to a1[e] into a2[t], by default a2 = a1,
+// in an order that keeps overlapping ranges correct, and returns a2.
+var tabMove = &Function{
+	Name: "move",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		f, err := checkInteger(d, "move", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		e, err := checkInteger(d, "move", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		t, err := checkInteger(d, "move", args, 3)
+		if err != nil {
+			return nil, err
+		}
+		dst := 0
+		if arg(args, 4) != nil {
+			dst = 4
+		}
+		a1, err := CheckTable(d, "move", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		a2, err := CheckTable(d, "move", args, dst)
+		if err != nil {
+			return nil, err
+		}
+		if e < f {
+			return []interface{}{a2}, nil
+		}
+		if f <= 0 && e >= math.MaxInt64+f {
+			return nil, ArgError("move", 2, "too many elements to move")
+		}
+		n := e - f + 1
+		if t > math.MaxInt64-n+1 {
+			return nil, ArgError("move", 3, "destination wrap around")
+		}
+		forward := t > e || t <= f
+		if !forward && dst != 0 {
+			same, err := d.Equal(a1, a2)
+			if err != nil {
+				return nil, err
+			}
+			forward = !same
+		}
+		for k := int64(0); k < n; k++ {
+			i := k
+			if !forward {
+				i = n - 1 - k
+			}
+			v, err := d.Index(a1, f+i)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetIndex(a2, t+i, v); err != nil {
+				return nil, err
+			}
+		}
+		return []interface{}{a2}, nil
+	},
+}
+
+// tabSort sorts a list in place with < or a comparison function.
+// It reads the list into a slice and sorts it with the quicksort
+// of Lua, which detects inconsistent comparison functions.
+var tabSort = &Function{
+	Name: "sort",
+	Fn: func(d *meta.Dispatcher, args []interface{}) ([]interface{}, error) {
+		t, n, err := checkLength(d, "sort", args, 0)
+		if err != nil || n <= 1 {
+			return nil, err
+		}
+		if n >= math.MaxInt32 {
+			return nil, ArgError("sort", 0, "array too big")
+		}
+		s := &sorter{d: d, a: make([]interface{}, n+1)}
+		if fn := arg(args, 1); fn != nil {
+			if s.fn, err = checkFunction(d, "sort", args, 1); err != nil {
+				return nil, err
+			}
+		}
+		for i := int64(1); i <= n; i++ {
+			if s.a[i], err = d.Index(t, i); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.sort(1, int(n), 0); err != nil {
+			return nil, err
+		}
+		for i := int64(1); i <= n; i++ {
+			if err := d.SetIndex(t, i, s.a[i]); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	},
+}
+
+// sorter sorts the 1-based list a.
+type sorter struct {
+	d  *meta.Dispatcher
+	fn interface{}
+	a  []interface{}
+}
+
+// less compares two values with the comparison function or <.
+func (s *sorter) less(x, y interface{}) (bool, error) {
+	if s.fn == nil {
+		return s.d.LessThan(x, y)
+	}
+	res, err := s.d.Callback(s.fn, []interface{}{x, y})
+	if err != nil {
+		return false, err
+	}
+	return meta.Truthy(arg(res, 0)), nil
+}
+
+// sort sorts a[lo..up]; a non-zero rnd randomizes the choice of pivots
+// of large intervals, which is set when the partitions get imbalanced.
+func (s *sorter) sort(lo, up int, rnd uint) error {
+	a := s.a
+	for lo < up {
+		// sort a[lo], a[p] and a[up]
+		if lt, err := s.less(a[up], a[lo]); err != nil {
+			return err
+		} else if lt {
+			a[lo], a[up] = a[up], a[lo]
+		}
+		if up-lo == 1 {
+			break
+		}
+		p := (lo + up) / 2
+		if up-lo >= 100 && rnd != 0 {
+			r4 := (up - lo) / 4
+			p = int(rnd%uint(r4*2)) + lo + r4
+		}
+		if lt, err := s.less(a[p], a[lo]); err != nil {
+			return err
+		} else if lt {
+			a[p], a[lo] = a[lo], a[p]
+		} else if lt, err := s.less(a[up], a[p]); err != nil {
+			return err
+		} else if lt {
+			a[p], a[up] = a[up], a[p]
+		}
+		if up-lo == 2 {
+			break
+		}
+		// the median is the pivot, kept at up - 1
+		a[p], a[up-1] = a[up-1], a[p]
+		p, err := s.partition(lo, up)
+		if err != nil {
+			return err
+		}
+		// recurse into the smaller interval and loop over the larger one
+		var n int
+		if p-lo < up-p {
+			if err := s.sort(lo, p-1, rnd); err != nil {
+				return err
+			}
+			n = p - lo
+			lo = p + 1
+		} else {
+			if err := s.sort(p+1, up, rnd); err != nil {
+				return err
+			}
+			n = up - p
+			up = p - 1
+		}
+		if (up-lo)/128 > n {
+			rnd = uint(time.Now().UnixNano())
+		}
+	}
+	return nil
+}
+
+// partition partitions a[lo..up] around the pivot P at a[up - 1], so that
+// a[lo..p - 1] <= P == a[p] <= a[p + 1..up], and returns p. Running past
+// the ends of the interval means that the comparison is inconsistent.
+func (s *sorter) partition(lo, up int) (int, error) {
+	a := s.a
+	pivot := a[up-1]
+	i, j := lo, up-1
+	for {
+		for {
+			i++
+			lt, err := s.less(a[i], pivot)
+			if err != nil {
+				return 0, err
+			}
+			if !lt {
+				break
+			}
+			if i == up-1 {
+				return 0, ErrInvalidOrder
+			}
+		}
+		for {
+			j--
+			lt, err := s.less(pivot, a[j])
+			if err != nil {
+				return 0, err
+			}
+			if !lt {
+				break
+			}
+			if j < i {
+				return 0, ErrInvalidOrder
+			}
+		}
+		if j < i {
+			a[up-1], a[i] = a[i], a[up-1]
+			return i, nil
+		}
+		a[i], a[j] = a[j], a[i]
+	}
+}
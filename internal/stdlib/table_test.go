@@ -0,0 +1,35 @@
+package stdlib_test
+
+func (s *StdlibSuite) TestTableLibrary() {
+	s.run("", []scriptTest{
+		{"local t = { 1, 2, 3 } table.insert(t, 4) table.insert(t, 1, 0) table.insert(t, 3, 1.5) return table.concat(t, ',')", "0,1,1.5,2,3,4"},
+		{"local t = { 1, 2, 3, 4 } local a, b = table.remove(t), table.remove(t, 1) return a .. b .. table.concat(t, ',')", "412,3"},
+		{"local t = { 1, 2 } return table.remove({}) == nil and table.remove(t, #t + 1) == nil and #t", int64(2)},
+		{"return table.concat({}) .. table.concat({ 1, 2.5, 'x' }, '-') .. table.concat({ 1, 2, 3, 4 }, ',', 2, 3)", "1-2.5-x2,3"},
+		{"local a, b, c = table.unpack({ 1, 2, 3 }, 2, 4) return a + b + (c == nil and 0 or 1)", int64(5)},
+		{"local p = table.pack(1, nil, 3) return p.n + p[3]", int64(6)},
+		{"return table.concat(table.move({ 1, 2, 3, 4, 5 }, 1, 3, 3), ',')", "1,2,1,2,3"},
+		{"return table.concat(table.move({ 1, 2, 3, 4, 5 }, 2, 5, 1), ',')", "2,3,4,5,5"},
+		{"local d = table.move({ 1, 2, 3 }, 1, 3, 2, {}) return d[1] == nil and d[2] + d[4]", int64(4)},
+		{"local t = { 5, 2, 8, 1, 9, 3 } table.sort(t) return table.concat(t, ',')", "1,2,3,5,8,9"},
+		{"local t = { 5, 2, 8, 1, 9, 3 } table.sort(t, function(a, b) return a > b end) return table.concat(t, ',')", "9,8,5,3,2,1"},
+		{"local t = { 'banana', 'apple', 'cherry' } table.sort(t) return table.concat(t, ' ')", "apple banana cherry"},
+		{"local t, i = {}, 1 while i <= 1000 do t[i] = (i * 7919) % 1009 i = i + 1 end table.sort(t) i = 2 while i <= #t do if t[i - 1] > t[i] then return false end i = i + 1 end return #t", int64(1000)},
+		// the functions go through __index, __newindex and __len
+		{"local t = setmetatable({}, { __index = function(_, i) return i * 10 end, __len = function() return 3 end }) return table.concat(t, ',')", "10,20,30"},
+		{"n = 0 local t = setmetatable({}, { __newindex = function(t, k, v) n = n + k end }) table.insert(t, 'x') table.insert(t, 'y') return n", int64(2)},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"table.insert({}, 10, 1)", "bad argument #2 to 'insert' (position out of bounds)"},
+		{"table.insert({}, 1, 2, 3)", "wrong number of arguments to 'insert'"},
+		{"table.remove({}, 10)", "bad argument #2 to 'remove' (position out of bounds)"},
+		{"table.concat({ 1, {}, 3 })", "invalid value (at index 2) in table for 'concat'"},
+		{"table.unpack({}, 1, 1e7)", "too many results to unpack"},
+		{"table.move({}, -1, 9223372036854775807, 1)", "bad argument #3 to 'move' (too many elements to move)"},
+		{"table.sort({ 1, 2 }, 3)", "bad argument #2 to 'sort' (function expected, got number)"},
+		{"table.sort({ 3, 2, 1, 5, 4, 7 }, function() return true end)", "invalid order function for sorting"},
+		{"table.insert(nil, 1)", "bad argument #1 to 'insert' (table expected, got nil)"},
+		{"table.concat(setmetatable({}, { __len = math.type }))", "object length is not an integer"},
+	})
+}
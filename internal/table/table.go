@@ -0,0 +1,225 @@
+package table
+
+import (
+	"errors"
+	"math"
+
+	"lua-interpreter/internal/number"
+)
+
+var (
+	ErrNilIndex = errors.New("table index is nil")
+	ErrNaNIndex = errors.New("table index is NaN")
+	ErrNextKey  = errors.New("invalid key to 'next'")
+)
+
+// Table is a Lua table. Values with the keys 1..n live in the array part,
+// all other keys in the hash part. The array part never ends with nil
+// and the hash part never holds the key n+1, so n is always a border.
+type Table struct {
+	Metatable *Table
+
+	array []interface{}
+	// hash maps keys to their entries; removed keys keep their entry
+	// with a nil value until the hash part is compacted
+	hash    map[interface{}]int
+	entries []entry
+	// removed counts the entries with a nil value
+	removed int
+}
+
+type entry struct {
+	key   interface{}
+	value interface{}
+}
+
+// New creates a table with room for narr array values and nrec other keys.
+func New(narr, nrec int) *Table {
+	t := &Table{}
+	if narr > 0 {
+		t.array = make([]interface{}, 0, narr)
+	}
+	if nrec > 0 {
+		t.hash = make(map[interface{}]int, nrec)
+		t.entries = make([]entry, 0, nrec)
+	}
+	return t
+}
+
+// NormalizeKey converts floats with an integer value to integers,
+// so that t[1] and t[1.0] are the same field.
+func NormalizeKey(key interface{}) interface{} {
+	if f, ok := key.(float64); ok {
+		if i, ok := number.FloatToInteger(f); ok {
+			return i
+		}
+	}
+	return key
+}
+
+// Get returns the value of a key, or nil if the table has no such key.
+func (t *Table) Get(key interface{}) interface{} {
+	key = NormalizeKey(key)
+	if i, ok := key.(int64); ok && i >= 1 && i <= int64(len(t.array)) {
+		return t.array[i-1]
+	}
+	if idx, ok := t.hash[key]; ok {
+		return t.entries[idx].value
+	}
+	return nil
+}
+
+// Set assigns a value to a key; assigning nil removes the key.
+// It fails for nil and NaN keys.
+func (t *Table) Set(key, value interface{}) error {
+	switch k := key.(type) {
+	case nil:
+		return ErrNilIndex
+	case float64:
+		if math.IsNaN(k) {
+			return ErrNaNIndex
+		}
+	}
+	key = NormalizeKey(key)
+	if i, ok := key.(int64); ok {
+		n := int64(len(t.array))
+		if i >= 1 && i <= n {
+			t.array[i-1] = value
+			if value == nil && i == n {
+				t.trimArray()
+			}
+			return nil
+		}
+		if i == n+1 && value != nil {
+			t.setHash(key, nil)
+			t.array = append(t.array, value)
+			t.migrate()
+			return nil
+		}
+	}
+	t.setHash(key, value)
+	return nil
+}
+
+// Length returns a border of the table: an index n such that
+// t[n] is not nil and t[n+1] is nil, or 0 if t[1] is nil.
+func (t *Table) Length() int64 {
+	return int64(len(t.array))
+}
+
+// Next returns the key and the value that follow a key in the traversal
+// order: the array part first, then the other keys in insertion order.
+// The nil key starts a traversal, and a nil result key ends it.
+// Fields may be assigned or cleared during a traversal.
+func (t *Table) Next(key interface{}) (interface{}, interface{}, error) {
+	key = NormalizeKey(key)
+	start := 0 // the first entry of the hash part to look at
+	switch k := key.(type) {
+	case nil:
+		if k, v := t.nextInArray(0); k != nil {
+			return k, v, nil
+		}
+	case int64:
+		if k >= 1 && k <= int64(len(t.array)) {
+			if k, v := t.nextInArray(int(k)); k != nil {
+				return k, v, nil
+			}
+			break
+		}
+		if idx, ok := t.hash[key]; ok {
+			start = idx + 1
+		} else if k < 1 {
+			return nil, nil, ErrNextKey
+		}
+		// otherwise the key was cleared from the end of the array part
+	default:
+		idx, ok := t.hash[key]
+		if !ok {
+			return nil, nil, ErrNextKey
+		}
+		start = idx + 1
+	}
+	for _, e := range t.entries[start:] {
+		if e.value != nil {
+			return e.key, e.value, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// nextInArray returns the first non-nil value of the array part from index i (0-based).
+func (t *Table) nextInArray(i int) (interface{}, interface{}) {
+	for ; i < len(t.array); i++ {
+		if t.array[i] != nil {
+			return int64(i + 1), t.array[i]
+		}
+	}
+	return nil, nil
+}
+
+// trimArray drops the nils from the end of the array part.
+func (t *Table) trimArray() {
+	n := len(t.array)
+	for n > 0 && t.array[n-1] == nil {
+		n--
+	}
+	clear(t.array[n:])
+	t.array = t.array[:n]
+}
+
+// migrate moves the keys that continue the array part from the hash part.
+func (t *Table) migrate() {
+	if len(t.entries) == t.removed {
+		return
+	}
+	for {
+		key := int64(len(t.array)) + 1
+		idx, ok := t.hash[key]
+		if !ok || t.entries[idx].value == nil {
+			return
+		}
+		t.array = append(t.array, t.entries[idx].value)
+		t.setHash(key, nil)
+	}
+}
+
+func (t *Table) setHash(key, value interface{}) {
+	if idx, ok := t.hash[key]; ok {
+		e := &t.entries[idx]
+		switch {
+		case e.value == nil && value != nil:
+			t.removed--
+		case e.value != nil && value == nil:
+			t.removed++
+		}
+		e.value = value
+		return
+	}
+	if value == nil {
+		return
+	}
+	if t.removed > len(t.entries)/2 {
+		t.compact()
+	}
+	if t.hash == nil {
+		t.hash = make(map[interface{}]int)
+	}
+	t.hash[key] = len(t.entries)
+	t.entries = append(t.entries, entry{key: key, value: value})
+}
+
+// compact drops the removed keys from the hash part.
+func (t *Table) compact() {
+	entries := t.entries[:0]
+	for _, e := range t.entries {
+		if e.value == nil {
+			delete(t.hash, e.key)
+			continue
+		}
+		t.hash[e.key] = len(entries)
+		entries = append(entries, e)
+	}
+	clear(t.entries[len(entries):])
+	t.entries = entries
+	t.removed = 0
+}
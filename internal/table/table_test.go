@@ -0,0 +1,144 @@
+package table_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"lua-interpreter/internal/table"
+)
+
+type TableSuite struct {
+	suite.Suite
+}
+
+func TestTableSuite(t *testing.T) {
+	suite.Run(t, new(TableSuite))
+}
+
+func (s *TableSuite) TestKeyNormalization() {
+	t := table.New(0, 0)
+	s.NoError(t.Set(1.0, "a"))
+	s.Equal("a", t.Get(int64(1)))
+	s.NoError(t.Set(int64(2), "b"))
+	s.Equal("b", t.Get(2.0))
+	s.NoError(t.Set(2.5, "c"))
+	s.Equal("c", t.Get(2.5))
+	s.Nil(t.Get(int64(3)))
+	s.Equal(int64(2), t.Length())
+}
+
+func (s *TableSuite) TestInvalidKeys() {
+	t := table.New(0, 0)
+	s.ErrorIs(t.Set(nil, int64(1)), table.ErrNilIndex)
+	s.ErrorIs(t.Set(math.NaN(), int64(1)), table.ErrNaNIndex)
+	s.Nil(t.Get(nil))
+	s.Nil(t.Get(math.NaN()))
+}
+
+func (s *TableSuite) TestRemove() {
+	t := table.New(0, 0)
+	s.NoError(t.Set("x", int64(1)))
+	s.NoError(t.Set("x", nil))
+	s.Nil(t.Get("x"))
+	s.NoError(t.Set("x", int64(2)))
+	s.Equal(int64(2), t.Get("x"))
+
+	// removed keys are dropped once the hash part is compacted
+	for i := 0; i < 100; i++ {
+		s.NoError(t.Set(float64(i)+0.5, int64(i)))
+		s.NoError(t.Set(float64(i)+0.5, nil))
+	}
+	s.Equal(int64(2), t.Get("x"))
+	s.Nil(t.Get(0.5))
+}
+
+func (s *TableSuite) TestBorder() {
+	t := table.New(0, 0)
+	s.Equal(int64(0), t.Length())
+	for i := int64(1); i <= 5; i++ {
+		s.NoError(t.Set(i, i))
+	}
+	s.Equal(int64(5), t.Length())
+
+	s.NoError(t.Set(int64(5), nil))
+	s.Equal(int64(4), t.Length())
+
+	// a hole in the middle keeps a valid border
+	s.NoError(t.Set(int64(2), nil))
+	n := t.Length()
+	s.NotNil(t.Get(n))
+	s.Nil(t.Get(n + 1))
+
+	// removing the end skips the holes before it
+	s.NoError(t.Set(int64(4), nil))
+	s.NoError(t.Set(int64(3), nil))
+	s.Equal(int64(1), t.Length())
+}
+
+func (s *TableSuite) TestMigration() {
+	t := table.New(0, 0)
+	// keys set out of order end up in the array part
+	s.NoError(t.Set(int64(3), "c"))
+	s.NoError(t.Set(int64(2), "b"))
+	s.Equal(int64(0), t.Length())
+	s.NoError(t.Set(int64(1), "a"))
+	s.Equal(int64(3), t.Length())
+	s.Equal("a", t.Get(int64(1)))
+	s.Equal("b", t.Get(int64(2)))
+	s.Equal("c", t.Get(int64(3)))
+
+	s.NoError(t.Set(int64(0), "zero"))
+	s.NoError(t.Set(int64(-1), "minus"))
+	s.Equal("zero", t.Get(int64(0)))
+	s.Equal("minus", t.Get(int64(-1)))
+	s.Equal(int64(3), t.Length())
+}
+
+func (s *TableSuite) TestLargeArray() {
+	const n = 1000000
+	t := table.New(0, 0)
+	for i := int64(1); i <= n; i++ {
+		if err := t.Set(i, i); err != nil {
+			s.FailNow(err.Error())
+		}
+	}
+	s.Equal(int64(n), t.Length())
+	s.Equal(int64(n/2), t.Get(float64(n/2)))
+}
+
+func (s *TableSuite) TestNext() {
+	t := table.New(0, 0)
+	s.NoError(t.Set(int64(1), "a"))
+	s.NoError(t.Set(int64(2), "b"))
+	s.NoError(t.Set("x", "c"))
+	s.NoError(t.Set(2.5, "d"))
+
+	var keys []interface{}
+	var k interface{}
+	for {
+		var err error
+		k, _, err = t.Next(k)
+		s.Require().NoError(err)
+		if k == nil {
+			break
+		}
+		keys = append(keys, k)
+	}
+	s.Equal([]interface{}{int64(1), int64(2), "x", 2.5}, keys)
+
+	// clearing the current field does not break the traversal
+	s.NoError(t.Set("x", nil))
+	k, v, err := t.Next("x")
+	s.NoError(err)
+	s.Equal(2.5, k)
+	s.Equal("d", v)
+	s.NoError(t.Set(int64(2), nil))
+	k, _, err = t.Next(int64(2))
+	s.NoError(err)
+	s.Equal(2.5, k)
+
+	_, _, err = t.Next("missing")
+	s.ErrorIs(err, table.ErrNextKey)
+}
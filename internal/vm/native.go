@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"fmt"
+
+	"lua-interpreter/internal/bytecode"
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/stdlib"
+	"lua-interpreter/internal/table"
+)
+
+// NativeFunction is a function implemented in Go.
+// It returns the list of its results.
+type NativeFunction struct {
+	Fn func(vm *VM, args []bytecode.Value) ([]bytecode.Value, error)
+}
+
+func toString(val bytecode.Value) string {
+	switch v := val.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return v
+	case int64, float64:
+		return number.ToString(v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case *bytecode.Function, *NativeFunction, *stdlib.Function:
+		return fmt.Sprintf("function: %p", v)
+	case *table.Table:
+		return fmt.Sprintf("table: %p", v)
+	case *coroutine.Coroutine:
+		return fmt.Sprintf("thread: %p", v)
+	default:
+		return fmt.Sprintf("<unknown:%T>", v)
+	}
+}
+
+// typeName returns the Lua type of a value.
+func typeName(val bytecode.Value) string {
+	switch val.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case *bytecode.Function, *NativeFunction, *stdlib.Function:
+		return "function"
+	case *table.Table:
+		return "table"
+	case *coroutine.Coroutine:
+		return "thread"
+	default:
+		return "userdata"
+	}
+}
+
+func (vm *VM) registerBuiltins() {
+	vm.globals = stdlib.Globals()
+	_ = vm.globals.Set("print", &NativeFunction{
+		Fn: func(vm *VM, args []bytecode.Value) ([]bytecode.Value, error) {
+			for i, arg := range args {
+				if i > 0 {
+					fmt.Print("\t")
+				}
+				s, err := vm.meta.ToString(arg, toString)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Print(s)
+			}
+			fmt.Println()
+			return nil, nil
+		},
+	})
+	vm.meta.Strings = stdlib.StringMetatable(vm.globals)
+}
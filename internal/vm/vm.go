@@ -1,74 +1,195 @@
 package vm
 
 import (
-	"errors"
 	"fmt"
-	"math"
 
+	"lua-interpreter/internal/ast"
 	"lua-interpreter/internal/bytecode"
+	"lua-interpreter/internal/coroutine"
+	"lua-interpreter/internal/meta"
+	"lua-interpreter/internal/number"
+	"lua-interpreter/internal/stdlib"
+	"lua-interpreter/internal/table"
 )
 
+var arithmeticOperations = map[bytecode.OpCode]number.Op{
+	bytecode.OpAdd:  number.OpAdd,
+	bytecode.OpSub:  number.OpSub,
+	bytecode.OpMul:  number.OpMul,
+	bytecode.OpDiv:  number.OpDiv,
+	bytecode.OpIDiv: number.OpIDiv,
+	bytecode.OpMod:  number.OpMod,
+	bytecode.OpPow:  number.OpPow,
+	bytecode.OpBAnd: number.OpBAnd,
+	bytecode.OpBOr:  number.OpBOr,
+	bytecode.OpBXor: number.OpBXor,
+	bytecode.OpShl:  number.OpShl,
+	bytecode.OpShr:  number.OpShr,
+}
+
 type VM struct {
-	// Instruction pointer
-	pc       int
 	bytecode bytecode.Bytecode
 	stack    []bytecode.Value
 	// Stack pointer
 	sp      int
-	locals  []bytecode.Value
-	globals map[string]bytecode.Value
-	// Call frames
-	frames []callFrame
-	// Current frame index
-	currentFrame int
+	globals *table.Table
+	// meta dispatches metamethods
+	meta *meta.Dispatcher
+	// nOpen is the number of values pushed by the last call or '...'
+	// that kept all its values (bytecode.MultRet)
+	nOpen int
+}
+
+// threadState is the part of the VM state that each coroutine has its own.
+type threadState struct {
+	stack []bytecode.Value
+	sp    int
+	nOpen int
 }
 
+// callFrame is the state of a running function.
 type callFrame struct {
-	fn *bytecode.Function
-	// Return PC (where to return after function call)
-	returnPC int
-	// Base stack pointer for this frame
-	basePointer int
-	// Number of expected results
-	expectedResults int
+	bytecode bytecode.Bytecode
+	// Instruction pointer, it points to the next instruction
+	pc      int
+	locals  []bytecode.Value
+	varargs []bytecode.Value
+	// Stack pointer at the start of the call, restored on return
+	base int
+	// tbc holds the values of the to-be-closed variables in declaration order
+	tbc []bytecode.Value
+	// env is the upvalue _ENV of the function
+	env *meta.Env
+}
+
+// envAt returns the value of _ENV for a global variable, the local variable
+// in slot or the upvalue if slot is -1, and whether it is a "local" or an "upvalue".
+func (f *callFrame) envAt(slot int) (bytecode.Value, string) {
+	if slot < 0 {
+		return f.env.Value, "upvalue"
+	}
+	return f.locals[slot], "local"
 }
 
 func NewVM(bc bytecode.Bytecode) *VM {
 	vm := &VM{
-		pc:       0,
 		bytecode: bc,
 		stack:    make([]bytecode.Value, 1000),
 		sp:       0,
-		locals:   make([]bytecode.Value, len(bc.LocalVars)),
-		globals:  make(map[string]bytecode.Value),
-		frames:   make([]callFrame, 100),
 	}
+	vm.meta = &meta.Dispatcher{Call: vm.callValue, TypeName: typeName, MaxCallDepth: meta.DefaultMaxCallDepth}
+	vm.meta.Threads = coroutine.NewState(vm.callValue)
+	vm.meta.Threads.Switch = vm.switchThread
 
 	vm.registerBuiltins()
 
 	return vm
 }
 
-func (vm *VM) Run() (bytecode.Value, error) {
-	for vm.pc < len(vm.bytecode.Code) {
-		inst := vm.bytecode.Code[vm.pc]
-		err := vm.executeInstruction(inst)
+// SetCompiler sets the function that compiles the chunks loaded by the scripts.
+func (vm *VM) SetCompiler(compile func(src, chunk string) (bytecode.Bytecode, error)) {
+	vm.meta.Load = func(src, chunk string, env *meta.Env) (interface{}, error) {
+		bc, err := compile(src, chunk)
 		if err != nil {
-			return nil, fmt.Errorf("error at pc=%d: %w", vm.pc, err)
+			return nil, err
+		}
+		if env == nil {
+			env = &meta.Env{Value: vm.globals}
 		}
-		vm.pc++
+		setEnv(bc, env)
+		return &bytecode.Function{Bytecode: bc, IsVararg: true, Env: env}, nil
 	}
+}
 
-	if vm.sp > 0 {
-		return vm.stack[vm.sp-1], nil
+// setEnv sets the upvalue _ENV of the functions defined in a chunk,
+// which are compiled anew for each chunk loaded.
+func setEnv(bc bytecode.Bytecode, env *meta.Env) {
+	for _, inst := range bc.Code {
+		if inst.Op == bytecode.OpPushFunction {
+			f := inst.Args[0].(*bytecode.Function)
+			f.Env = env
+			setEnv(f.Bytecode, env)
+		}
+	}
+}
+
+// SetMaxCallDepth limits the number of nested calls in a thread;
+// a deeper call raises a "stack overflow" error.
+func (vm *VM) SetMaxCallDepth(n int) {
+	vm.meta.MaxCallDepth = n
+}
+
+// Threads returns the coroutines of the VM.
+func (vm *VM) Threads() *coroutine.State {
+	return vm.meta.Threads
+}
+
+// switchThread saves the stack of the thread that stops running
+// and restores the one of the thread that runs, a new coroutine
+// starting with an empty stack.
+func (vm *VM) switchThread(from, to *coroutine.Coroutine) {
+	from.Data = threadState{stack: vm.stack, sp: vm.sp, nOpen: vm.nOpen}
+	if ts, ok := to.Data.(threadState); ok {
+		vm.stack, vm.sp, vm.nOpen = ts.stack, ts.sp, ts.nOpen
+		return
+	}
+	vm.stack, vm.sp, vm.nOpen = make([]bytecode.Value, 1000), 0, 0
+}
+
+// Run executes the main chunk and returns the first value it returns.
+func (vm *VM) Run() (bytecode.Value, error) {
+	env := &meta.Env{Value: vm.globals}
+	setEnv(vm.bytecode, env)
+	frame := &callFrame{
+		bytecode: vm.bytecode,
+		locals:   make([]bytecode.Value, len(vm.bytecode.LocalVars)),
+		base:     vm.sp,
+		env:      env,
+	}
+	results, err := vm.execute(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > 0 {
+		return results[0], nil
 	}
 	return nil, nil
 }
 
-func (vm *VM) executeInstruction(inst bytecode.Instruction) error {
+// execute runs a frame until it returns. The to-be-closed variables
+// of the frame are closed however it exits.
+func (vm *VM) execute(f *callFrame) (results []bytecode.Value, err error) {
+	defer func() {
+		if err != nil {
+			vm.sp = f.base
+			err = vm.closeValues(f, 0, err)
+		}
+	}()
+	for f.pc < len(f.bytecode.Code) {
+		inst := f.bytecode.Code[f.pc]
+		f.pc++
+		if inst.Op == bytecode.OpReturn {
+			n := vm.count(inst.Args[0].(int), inst.Args[1].(bool))
+			results = make([]bytecode.Value, n)
+			copy(results, vm.stack[vm.sp-n:vm.sp])
+			vm.sp = f.base
+			return results, vm.closeValues(f, 0, nil)
+		}
+		if err := vm.executeInstruction(f, inst); err != nil {
+			if inst.Op == bytecode.OpCall || inst.Op == bytecode.OpTailCall {
+				return nil, ast.NewCallError(f.bytecode.Chunk, inst.Pos, inst.Args[3].(string), err)
+			}
+			return nil, ast.NewError(f.bytecode.Chunk, inst.Pos, err)
+		}
+	}
+	vm.sp = f.base
+	return nil, vm.closeValues(f, 0, nil)
+}
+
+func (vm *VM) executeInstruction(f *callFrame, inst bytecode.Instruction) error {
 	switch inst.Op {
 	case bytecode.OpPushNumber:
-		vm.push(inst.Args[0].(float64))
+		vm.push(inst.Args[0])
 	case bytecode.OpPushString:
 		vm.push(inst.Args[0].(string))
 	case bytecode.OpPushNil:
@@ -76,291 +197,307 @@ func (vm *VM) executeInstruction(inst bytecode.Instruction) error {
 	case bytecode.OpPushBool:
 		vm.push(inst.Args[0].(bool))
 	case bytecode.OpPushVarArg:
-		// Get varargs from current frame's locals
-		if vm.currentFrame >= 0 {
-			if varargs, ok := vm.locals[len(vm.locals)-1].([]bytecode.Value); ok {
-				for _, arg := range varargs {
-					vm.push(arg)
-				}
-			} else {
-				vm.push(nil)
-			}
-		} else {
-			vm.push(nil)
-		}
+		vm.pushResults(f.varargs, inst.Args[0].(int))
 	case bytecode.OpPushFunction:
 		vm.push(inst.Args[0].(*bytecode.Function))
-	case bytecode.OpReturn:
-		var nResults int
-		if vm.sp > 0 {
-			nResults = 1 // For now, always return 1 value
+	case bytecode.OpAdd, bytecode.OpSub, bytecode.OpMul, bytecode.OpDiv,
+		bytecode.OpIDiv, bytecode.OpMod, bytecode.OpPow,
+		bytecode.OpBAnd, bytecode.OpBOr, bytecode.OpBXor, bytecode.OpShl, bytecode.OpShr:
+		b := vm.pop()
+		a := vm.pop()
+		res, err := vm.meta.Arith(arithmeticOperations[inst.Op], a, b)
+		if err != nil {
+			return addVarInfo(err, inst, a, b)
 		}
-		err := vm.return_(nResults)
+		vm.push(res)
+	case bytecode.OpUnm, bytecode.OpBNot:
+		a := vm.pop()
+		op := number.OpUnm
+		if inst.Op == bytecode.OpBNot {
+			op = number.OpBNot
+		}
+		res, err := vm.meta.Arith(op, a, a)
 		if err != nil {
-			return err
+			return addVarInfo(err, inst, a, a)
 		}
-	case bytecode.OpAdd:
-		b := vm.pop().(float64)
-		a := vm.pop().(float64)
-		vm.push(a + b)
-	case bytecode.OpSub:
-		b := vm.pop().(float64)
-		a := vm.pop().(float64)
-		vm.push(a - b)
-	case bytecode.OpMul:
-		b := vm.pop().(float64)
-		a := vm.pop().(float64)
-		vm.push(a * b)
-	case bytecode.OpDiv:
-		b := vm.pop().(float64)
-		a := vm.pop().(float64)
-		vm.push(a / b)
-	case bytecode.OpMod:
-		b := vm.pop().(float64)
-		a := vm.pop().(float64)
-		vm.push(math.Mod(a, b))
-	case bytecode.OpPow:
-		b := vm.pop().(float64)
-		a := vm.pop().(float64)
-		vm.push(math.Pow(a, b))
-	case bytecode.OpUnm:
-		a := vm.pop().(float64)
-		vm.push(-a)
+		vm.push(res)
 	case bytecode.OpNot:
 		a := vm.pop()
-		vm.push(vm.isFalse(a))
+		vm.push(!meta.Truthy(a))
 	case bytecode.OpLen:
 		a := vm.pop()
-		switch v := a.(type) {
-		case string:
-			vm.push(float64(len(v)))
-		case map[interface{}]bytecode.Value:
-			vm.push(float64(len(v)))
-		default:
-			return fmt.Errorf("length operator not supported for type %T", a)
+		res, err := vm.meta.Len(a)
+		if err != nil {
+			return addVarInfo(err, inst, a)
 		}
+		vm.push(res)
 	case bytecode.OpConcat:
-		b := vm.pop().(string)
-		a := vm.pop().(string)
-		vm.push(a + b)
-	case bytecode.OpEq:
 		b := vm.pop()
 		a := vm.pop()
-		vm.push(vm.equals(a, b))
-	case bytecode.OpLt:
-		b := vm.pop()
-		a := vm.pop()
-		vm.push(vm.lessThan(a, b))
-	case bytecode.OpLe:
-		b := vm.pop()
-		a := vm.pop()
-		vm.push(vm.lessOrEqual(a, b))
-	case bytecode.OpNeq:
+		res, err := vm.meta.Concat(a, b)
+		if err != nil {
+			return addVarInfo(err, inst, a, b)
+		}
+		vm.push(res)
+	case bytecode.OpEq, bytecode.OpNeq:
 		b := vm.pop()
 		a := vm.pop()
-		vm.push(!vm.equals(a, b))
-	case bytecode.OpGt:
+		eq, err := vm.meta.Equal(a, b)
+		if err != nil {
+			return err
+		}
+		vm.push(eq == (inst.Op == bytecode.OpEq))
+	case bytecode.OpLt, bytecode.OpGt:
 		b := vm.pop()
 		a := vm.pop()
-		vm.push(!vm.lessOrEqual(a, b))
-	case bytecode.OpGe:
+		if inst.Op == bytecode.OpGt {
+			a, b = b, a
+		}
+		res, err := vm.meta.LessThan(a, b)
+		if err != nil {
+			return err
+		}
+		vm.push(res)
+	case bytecode.OpLe, bytecode.OpGe:
 		b := vm.pop()
 		a := vm.pop()
-		vm.push(!vm.lessThan(a, b))
+		if inst.Op == bytecode.OpGe {
+			a, b = b, a
+		}
+		res, err := vm.meta.LessEqual(a, b)
+		if err != nil {
+			return err
+		}
+		vm.push(res)
 	case bytecode.OpGetLocal:
 		idx := inst.Args[0].(int)
-		if idx >= len(vm.locals) {
+		if idx >= len(f.locals) {
 			return fmt.Errorf("local variable index out of range: %d", idx)
 		}
-		vm.push(vm.locals[idx])
+		vm.push(f.locals[idx])
 	case bytecode.OpSetLocal:
 		idx := inst.Args[0].(int)
-		if idx >= len(vm.locals) {
+		if idx >= len(f.locals) {
 			return fmt.Errorf("local variable index out of range: %d", idx)
 		}
-		vm.locals[idx] = vm.peek()
+		f.locals[idx] = vm.pop()
 	case bytecode.OpGetGlobal:
-		name := inst.Args[0].(string)
-		vm.push(vm.globals[name])
+		env, kind := f.envAt(inst.Args[1].(int))
+		v, err := vm.meta.GetGlobal(env, kind, inst.Args[0].(string))
+		if err != nil {
+			return err
+		}
+		vm.push(v)
 	case bytecode.OpSetGlobal:
-		name := inst.Args[0].(string)
-		vm.globals[name] = vm.peek()
+		env, kind := f.envAt(inst.Args[1].(int))
+		if err := vm.meta.SetGlobal(env, kind, inst.Args[0].(string), vm.pop()); err != nil {
+			return err
+		}
+	case bytecode.OpGetEnv:
+		vm.push(f.env.Value)
+	case bytecode.OpSetEnv:
+		f.env.Value = vm.pop()
 	case bytecode.OpNewTable:
-		vm.push(make(map[interface{}]bytecode.Value))
+		vm.push(table.New(0, 0))
 	case bytecode.OpGetTable:
 		key := vm.pop()
-		table := vm.pop().(map[interface{}]bytecode.Value)
-		vm.push(table[key])
+		t := vm.pop()
+		res, err := vm.meta.Index(t, key)
+		if err != nil {
+			return addVarInfo(err, inst, t)
+		}
+		vm.push(res)
 	case bytecode.OpSetTable:
+		// the table and the key stay on the stack below the value,
+		// with Args[0] values between them and the value
 		value := vm.pop()
-		key := vm.pop()
-		table := vm.pop().(map[interface{}]bytecode.Value)
-		table[key] = value
-	case bytecode.OpCall:
-		nArgs := inst.Args[0].(int)
-		err := vm.call(nArgs, 1)
+		offset := inst.Args[0].(int)
+		key := vm.stack[vm.sp-1-offset]
+		t := vm.stack[vm.sp-2-offset]
+		if err := vm.meta.SetIndex(t, key, value); err != nil {
+			return addVarInfo(err, inst, t)
+		}
+	case bytecode.OpSetList:
+		// the table is below the values
+		n := vm.count(inst.Args[1].(int), inst.Args[2].(bool))
+		t := vm.stack[vm.sp-n-1].(*table.Table)
+		for i := 0; i < n; i++ {
+			if err := t.Set(inst.Args[0].(int64)+int64(i), vm.stack[vm.sp-n+i]); err != nil {
+				return err
+			}
+		}
+		vm.sp -= n
+	case bytecode.OpPop:
+		vm.sp -= inst.Args[0].(int)
+	case bytecode.OpSelf:
+		// replaces the object with its method and the object as the first argument
+		obj := vm.pop()
+		method, err := vm.meta.Index(obj, inst.Args[0])
 		if err != nil {
-			return err
+			return addVarInfo(err, inst, obj)
+		}
+		vm.push(method)
+		vm.push(obj)
+	case bytecode.OpCall, bytecode.OpTailCall:
+		nArgs := vm.count(inst.Args[0].(int), inst.Args[2].(bool))
+		args := make([]bytecode.Value, nArgs)
+		copy(args, vm.stack[vm.sp-nArgs:vm.sp])
+		fn := vm.stack[vm.sp-nArgs-1]
+		vm.sp -= nArgs + 1
+		if lf, ok := fn.(*bytecode.Function); ok && inst.Op == bytecode.OpTailCall {
+			// the called function replaces the frame, which has nothing to close
+			*f = *newFrame(lf, args, f.base)
+			vm.sp = f.base
+			return nil
+		}
+		results, err := vm.callValue(fn, args)
+		if err != nil {
+			return addVarInfo(err, inst, fn)
 		}
-	case bytecode.OpTailCall:
-		nArgs := inst.Args[0].(int)
-		return vm.tailCall(nArgs)
+		vm.pushResults(results, inst.Args[1].(int))
 	case bytecode.OpTest:
-		cond := vm.pop()
-		vm.push(cond)
+		if !meta.Truthy(vm.pop()) {
+			f.pc += inst.Args[0].(int)
+		}
+	case bytecode.OpTestSet:
+		// keeps the value as the result of and/or when it decides it, else drops it
+		if meta.Truthy(vm.stack[vm.sp-1]) == inst.Args[1].(bool) {
+			f.pc += inst.Args[0].(int)
+		} else {
+			vm.sp--
+		}
 	case bytecode.OpJmp:
-		cond := vm.pop()
-		if vm.isFalse(cond) {
-			offset := inst.Args[0].(int)
-			vm.pc += offset - 1
+		f.pc += inst.Args[0].(int)
+	case bytecode.OpForPrep:
+		// the initial value, the limit and the step become the loop control
+		loop, err := number.NewForLoop(vm.stack[vm.sp-3], vm.stack[vm.sp-2], vm.stack[vm.sp-1])
+		if err != nil {
+			return err
 		}
+		vm.sp -= 3
+		f.locals[inst.Args[0].(int)] = loop
+	case bytecode.OpForLoop:
+		v, ok := f.locals[inst.Args[0].(int)].(*number.ForLoop).Next()
+		if !ok {
+			f.pc += inst.Args[2].(int)
+			break
+		}
+		f.locals[inst.Args[1].(int)] = v
+	case bytecode.OpToBeClosed:
+		idx := inst.Args[0].(int)
+		val := f.locals[idx]
+		if !vm.meta.Closable(val) {
+			return fmt.Errorf("variable '%s' got a non-closable value", f.bytecode.LocalVars[idx])
+		}
+		f.tbc = append(f.tbc, val)
+	case bytecode.OpClose:
+		return vm.closeValues(f, inst.Args[0].(int), nil)
 	default:
 		return fmt.Errorf("unknown opcode: %v", inst.Op)
 	}
 	return nil
 }
 
-func (vm *VM) push(v bytecode.Value) {
-	vm.stack[vm.sp] = v
-	vm.sp++
-}
-
-func (vm *VM) pop() bytecode.Value {
-	vm.sp--
-	return vm.stack[vm.sp]
-}
-
-func (vm *VM) peek() bytecode.Value {
-	return vm.stack[vm.sp-1]
+// addVarInfo describes in a type error the variable the wrong operand
+// of the instruction was read from.
+func addVarInfo(err error, inst bytecode.Instruction, operands ...bytecode.Value) error {
+	return meta.AddVarInfo(err, operands, func(i int) string {
+		if i < len(inst.VarInfo) {
+			return inst.VarInfo[i]
+		}
+		return ""
+	})
 }
 
-func (vm *VM) isFalse(v bytecode.Value) bool {
-	switch v := v.(type) {
-	case nil:
-		return true
-	case bool:
-		return !v
-	default:
-		return false
+func (vm *VM) push(v bytecode.Value) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, make([]bytecode.Value, len(vm.stack))...)
 	}
+	vm.stack[vm.sp] = v
+	vm.sp++
 }
 
-func (vm *VM) equals(a, b bytecode.Value) bool {
-	if a == nil && b == nil {
-		return true
+// pushResults pushes exactly n of the values, padding them with nils,
+// or all of them for bytecode.MultRet.
+func (vm *VM) pushResults(vals []bytecode.Value, n int) {
+	if n == bytecode.MultRet {
+		n = len(vals)
+		vm.nOpen = n
 	}
-	if a == nil || b == nil {
-		return false
+	for i := 0; i < n; i++ {
+		if i < len(vals) {
+			vm.push(vals[i])
+		} else {
+			vm.push(nil)
+		}
 	}
-	return a == b
 }
 
-func (vm *VM) lessThan(a, b bytecode.Value) bool {
-	switch va := a.(type) {
-	case float64:
-		if vb, ok := b.(float64); ok {
-			return va < vb
-		}
-	case string:
-		if vb, ok := b.(string); ok {
-			return va < vb
-		}
+// count returns the number of values on top of the stack:
+// n values and, if open, the values of the last open call or '...'.
+func (vm *VM) count(n int, open bool) int {
+	if open {
+		return n + vm.nOpen
 	}
-	return false
+	return n
 }
 
-func (vm *VM) lessOrEqual(a, b bytecode.Value) bool {
-	return vm.lessThan(a, b) || vm.equals(a, b)
+func (vm *VM) pop() bytecode.Value {
+	vm.sp--
+	return vm.stack[vm.sp]
 }
 
-func (vm *VM) call(nArgs int, nResults int) error {
-	fn := vm.stack[vm.sp-nArgs-1]
-
+// callValue calls a function value, or a value with the __call metamethod,
+// and returns all its results.
+func (vm *VM) callValue(fn bytecode.Value, args []bytecode.Value) ([]bytecode.Value, error) {
+	if err := vm.meta.EnterCall(); err != nil {
+		return nil, err
+	}
+	defer vm.meta.LeaveCall()
 	switch f := fn.(type) {
 	case *bytecode.Function:
-		// Create new call frame
-		frame := &vm.frames[vm.currentFrame+1]
-		frame.fn = f
-		frame.returnPC = vm.pc
-		frame.basePointer = vm.sp - nArgs - 1
-		frame.expectedResults = nResults
-
-		// Set up new execution environment
-		vm.currentFrame++
-		vm.pc = 0
-		vm.bytecode = f.Bytecode
-
-		vm.locals = make([]bytecode.Value, len(f.Bytecode.LocalVars))
-		for i := 0; i < f.NumParams && i < nArgs; i++ {
-			vm.locals[i] = vm.stack[frame.basePointer+1+i]
-		}
-
-		if f.IsVararg && nArgs > f.NumParams {
-			varargs := make([]bytecode.Value, nArgs-f.NumParams)
-			copy(varargs, vm.stack[frame.basePointer+1+f.NumParams:frame.basePointer+1+nArgs])
-			vm.locals[f.NumParams] = varargs
-		}
-
-		vm.sp = frame.basePointer + 1
-
-	case func([]bytecode.Value) (bytecode.Value, error):
-		args := make([]bytecode.Value, nArgs)
-		copy(args, vm.stack[vm.sp-nArgs:vm.sp])
-
-		result, err := f(args)
-		if err != nil {
-			return err
-		}
-
-		vm.sp -= nArgs + 1
-		vm.push(result)
-
-	default:
-		return fmt.Errorf("attempt to call a %T value", fn)
+		return vm.execute(newFrame(f, args, vm.sp))
+	case *NativeFunction:
+		return f.Fn(vm, args)
+	case *stdlib.Function:
+		return f.Fn(vm.meta, args)
 	}
-
-	return nil
-}
-
-func (vm *VM) tailCall(nArgs int) error {
-	// Similar to regular call but reuses current frame
-	return vm.call(nArgs, vm.frames[vm.currentFrame].expectedResults)
-}
-
-func (vm *VM) return_(nResults int) error {
-	if vm.currentFrame < 0 {
-		return errors.New("no function to return from")
+	if h, ok := vm.meta.CallHandler(fn); ok {
+		return vm.callValue(h, append([]bytecode.Value{fn}, args...))
 	}
+	return nil, &meta.TypeError{Op: "call", Value: fn, Type: typeName(fn)}
+}
 
-	frame := &vm.frames[vm.currentFrame]
-
-	for i := 0; i < nResults && i < frame.expectedResults; i++ {
-		vm.stack[frame.basePointer+i] = vm.stack[vm.sp-nResults+i]
+// newFrame creates the frame of a call of f with args
+// whose stack starts at base.
+func newFrame(f *bytecode.Function, args []bytecode.Value, base int) *callFrame {
+	frame := &callFrame{
+		bytecode: f.Bytecode,
+		locals:   make([]bytecode.Value, len(f.Bytecode.LocalVars)),
+		base:     base,
+		env:      f.Env,
 	}
-
-	// Restore previous execution environment
-	vm.currentFrame--
-	if vm.currentFrame >= 0 {
-		prevFrame := &vm.frames[vm.currentFrame]
-		vm.pc = prevFrame.returnPC
-		vm.bytecode = prevFrame.fn.Bytecode
+	copy(frame.locals, args[:min(len(args), f.NumParams)])
+	if f.IsVararg && len(args) > f.NumParams {
+		frame.varargs = append([]bytecode.Value(nil), args[f.NumParams:]...)
 	}
-
-	// Adjust stack pointer
-	vm.sp = frame.basePointer + frame.expectedResults
-
-	return nil
+	return frame
 }
 
-func (vm *VM) registerBuiltins() {
-	vm.globals["print"] = func(args []bytecode.Value) (bytecode.Value, error) {
-		for i, arg := range args {
-			if i > 0 {
-				fmt.Print("\t")
-			}
-			fmt.Print(arg)
+// closeValues closes the to-be-closed values of a frame above level
+// in reverse order. err is the error that exits their scope, if any;
+// an error in a __close metamethod replaces it.
+func (vm *VM) closeValues(f *callFrame, level int, err error) error {
+	for len(f.tbc) > level {
+		val := f.tbc[len(f.tbc)-1]
+		f.tbc = f.tbc[:len(f.tbc)-1]
+		var errValue bytecode.Value
+		if err != nil {
+			errValue = meta.ErrorValue(err)
+		}
+		if closeErr := vm.meta.Close(val, errValue); closeErr != nil {
+			err = closeErr
 		}
-		fmt.Println()
-		return nil, nil
 	}
+	return err
 }
@@ -2,11 +2,15 @@ package test
 
 import (
 	_ "embed"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
 
+	"lua-interpreter/internal/ast"
+	"lua-interpreter/internal/coroutine"
 	"lua-interpreter/internal/interpreter"
+	"lua-interpreter/internal/table"
 )
 
 type ParserSuite struct {
@@ -20,29 +24,980 @@ var (
 	ifLua string
 	//go:embed "testdata/factorial.lua"
 	factorialLua string
+	//go:embed "testdata/closures.lua"
+	closuresLua string
 )
 
+// engines are the two ways to run a script
+var engines = map[string]func(string) (ast.Value, error){
+	"ast": interpreter.Eval,
+	"vm":  interpreter.EvalWithStackMachine,
+}
+
+// scriptTest is a script and the value it returns.
+type scriptTest struct {
+	script   string
+	expected interface{}
+}
+
+// errorTest is a script and the error it raises.
+type errorTest struct {
+	script   string
+	expected string
+}
+
 func TestParserSuite(t *testing.T) {
 	suite.Run(t, new(ParserSuite))
 }
 
+// run runs the scripts on both engines, each after defs, and checks
+// that they return the expected values.
+func (s *ParserSuite) run(defs string, tests []scriptTest) {
+	for name, eval := range engines {
+		for _, test := range tests {
+			v, err := eval(defs + test.script)
+			s.NoError(err, "%s: %s", name, test.script)
+			s.Equal(test.expected, v, "%s: %s", name, test.script)
+		}
+	}
+}
+
+// runErrors runs the scripts on both engines and checks the errors they raise.
+func (s *ParserSuite) runErrors(tests []errorTest) {
+	for name, eval := range engines {
+		for _, test := range tests {
+			_, err := eval(test.script)
+			s.EqualError(err, test.expected, "%s: %s", name, test.script)
+		}
+	}
+}
+
+// runChunkErrors is runErrors for one-line scripts that fail at their start:
+// the messages are given without the position.
+func (s *ParserSuite) runChunkErrors(tests []errorTest) {
+	for i := range tests {
+		tests[i].expected = `[string "` + tests[i].script + `"]:1:1: ` + tests[i].expected
+	}
+	s.runErrors(tests)
+}
+
 func (s *ParserSuite) TestLoopStatements() {
 	v, err := interpreter.Eval(loopsLua)
 	s.NoError(err, "should not return an error")
-	s.IsType(float64(0), v, "should return a float64 value")
-	s.Equal(float64(88), v, "should return the expected value")
+	s.IsType(int64(0), v, "should return an integer value")
+	s.Equal(int64(88), v, "should return the expected value")
 }
 
 func (s *ParserSuite) TestIfStatement() {
 	v, err := interpreter.Eval(ifLua)
 	s.NoError(err, "should not return an error")
-	s.IsType(float64(0), v, "should return a float64 value")
-	s.Equal(float64(41), v, "should return the expected value")
+	s.IsType(int64(0), v, "should return an integer value")
+	s.Equal(int64(41), v, "should return the expected value")
 }
 
 func (s *ParserSuite) TestRecursiveFactorial() {
 	v, err := interpreter.Eval(factorialLua)
 	s.NoError(err, "should not return an error")
-	s.IsType(float64(0), v, "should return a float64 value")
-	s.Equal(float64(120), v, "should return the expected value")
+	s.IsType(int64(0), v, "should return an integer value")
+	s.Equal(int64(120), v, "should return the expected value")
+}
+
+func (s *ParserSuite) TestErrorPositions() {
+	_, err := interpreter.EvalChunk("local a = 1\nlocal b = a +", "errors.lua")
+	s.Error(err)
+	s.Equal("errors.lua:2:14: unexpected token: <eof>", err.Error())
+
+	_, err = interpreter.EvalChunk("local a = 1\nif a then\n  local b = ~1.5\nend", "errors.lua")
+	s.Error(err)
+	s.Equal("errors.lua:3:13: number has no integer representation", err.Error())
+
+	_, err = interpreter.Eval("goto nowhere")
+	s.Error(err)
+	s.Equal(`[string "goto nowhere"]:1:1: no visible label 'nowhere' for <goto> at line 1`, err.Error())
+}
+
+func (s *ParserSuite) TestNumerals() {
+	v, err := interpreter.Eval("return 0x1p4 + 1e1 + 0xA + .5 + 2.5E-1 + 0X.8")
+	s.NoError(err)
+	s.Equal(37.25, v)
+
+	_, err = interpreter.EvalChunk("local a = 3..2", "numerals.lua")
+	s.Error(err)
+	s.Equal("numerals.lua:1:11: malformed number near '3..2'", err.Error())
+}
+
+func (s *ParserSuite) TestIntegerArithmetic() {
+	s.run("", []scriptTest{
+		{"local a, b = 7, 2 return a // b", int64(3)},
+		{"local a, b = -7, 2 return a // b", int64(-4)},
+		{"local a, b = 7, -3 return a % b", int64(-2)},
+		{"local a, b = -7.5, 2 return a % b", 0.5},
+		{"local a, b = 7, 2 return a / b", 3.5},
+		{"local a, b = 4, 2 return a / b", 2.0},
+		{"local a, b = 2, 10 return a ^ b", 1024.0},
+		{"local a, b = 3, 1.0 return a * b", 3.0},
+		{"local a = 9007199254740993 return a + 2", int64(9007199254740995)},
+		{"local a = 0x7fffffffffffffff return a + 1", int64(math.MinInt64)},
+		{"local a = 0xffffffffffffffff return a", int64(-1)},
+		{"local a = 9223372036854775808 return a", 9223372036854775808.0},
+		{"local a, b = 1, 1.0 return a == b", true},
+		{"local a = 9007199254740993 return a < 9007199254740992.0", false},
+		{"local a = 3 return math.type(a)", "integer"},
+		{"local a = 3.0 return math.type(a)", "float"},
+		{"local a = '3' return math.type(a)", nil},
+		{"local a = 3.0 return math.tointeger(a)", int64(3)},
+		{"local a = 3.5 return math.tointeger(a)", nil},
+	})
+}
+
+func (s *ParserSuite) TestBitwiseOperators() {
+	s.run("", []scriptTest{
+		{"local a, b = 12, 10 return a & b", int64(8)},
+		{"local a, b = 12, 10 return a | b", int64(14)},
+		{"local a, b = 12, 10 return a ~ b", int64(6)},
+		{"local a = 0 return ~a", int64(-1)},
+		{"local a, b = 1, 4 return a << b", int64(16)},
+		{"local a, b = 256, 4 return a >> b", int64(16)},
+		{"local a = -1 return a >> 60", int64(15)},
+		{"local a = 1 return a << 63", int64(math.MinInt64)},
+		{"local a = 1 return a << 64", int64(0)},
+		{"local a = 16 return a << -2", int64(4)},
+		{"local a = 16 return a >> -2", int64(64)},
+		{"local a, b = 3.0, 5 return a | b", int64(7)},
+		// bitwise operators bind less tightly than concatenation and arithmetic
+		{"local a = 1 return a << 1 + 1", int64(4)},
+		{"local a = 6 return a & 3 | 8", int64(10)},
+		{"local a = 5 return a ~ 1 & 3", int64(4)},
+	})
+}
+
+func (s *ParserSuite) TestComparisons() {
+	s.run("", []scriptTest{
+		{"local a, b = 'a', 'b' return a < b", true},
+		{"local a, b = 'abc', 'abd' return a <= b", true},
+		{"local a, b = 'b', 'abc' return a > b", true},
+		{"local a, b = '', 'a' return a >= b", false},
+		{"local a, b = 'Z', 'a' return a < b", true},
+		{"local a, b = '10', '9' return a < b", true},
+		{"local a, b = 1, 1.5 return a < b", true},
+		{"local a, b = 2, 2.0 return a <= b", true},
+		{"local a, b = 'a', 'a' return a ~= b", false},
+		{"local a, b = 1, '1' return a == b", false},
+		{"local a = 0/0 return a == a", false},
+		{"local a, b = 1, 0 return a / b", math.Inf(1)},
+		{"local a, b = -1, 0.0 return a // b", math.Inf(-1)},
+		{"local a, inf = -5.5, 1/0 return a % inf", math.Inf(1)},
+		{"local a, b = -5, 3.0 return a // b", -2.0},
+		{"local a, b = 5, -3.0 return a % b", -1.0},
+		{"local a, b = 2, 0.5 return a ^ b", math.Sqrt(2)},
+		{"local a, b = 2, -1 return a ^ b", 0.5},
+		{"local a = 2 return -a ^ 2", -4.0},
+	})
+}
+
+func (s *ParserSuite) TestCoercions() {
+	s.run("", []scriptTest{
+		{"local n = 5 return 'n=' .. n", "n=5"},
+		{"local x = 1.5 return x .. '|'", "1.5|"},
+		{"local x = 2.0 return x .. ''", "2.0"},
+		{"local x = 1e100 return 'x' .. x", "x1e+100"},
+		{"local a, b = 1, 2 return a .. b", "12"},
+		{"local x = 1/3 return '' .. x", "0.33333333333333"},
+		{"local x = -0x7fffffffffffffff - 1 return x .. ''", "-9223372036854775808"},
+		{"local s = '10' return s + 1", int64(11)},
+		{"local s = '10' return 1 + s", int64(11)},
+		{"local s = '3.0' return s + 1", 4.0},
+		{"local s = ' 0x10 ' return s * 2", int64(32)},
+		{"local s = '1e1' return s - 1", 9.0},
+		{"local s = '7' return s // 2", int64(3)},
+		{"local s = '2' return s ^ 2", 4.0},
+		{"local s = '-3' return -s", int64(3)},
+		{"local s = '6' return s & 3", int64(2)},
+		{"local s = '1' return s << 4", int64(16)},
+		{"local s = '3.0' return ~s", int64(-4)},
+		{"local a, b = '10', '20' return a + b", int64(30)},
+		// strings are not converted for comparisons
+		{"local s = '10' return s == 10", false},
+	})
+
+	s.runErrors([]errorTest{
+		{"local s = 'abc' return s + 1", `[string "local s = 'abc' return s + 1"]:1:24: attempt to perform arithmetic on a string value (local 's')`},
+		{"local s = '10' return s + {}", `[string "local s = '10' return s + {}"]:1:23: attempt to perform arithmetic on a table value`},
+		{"local s = '1.5' return s | 0", `[string "local s = '1.5' return s | 0"]:1:24: number has no integer representation`},
+		{"local s = 'x' return s & 1", `[string "local s = 'x' return s & 1"]:1:22: attempt to perform bitwise operation on a string value (local 's')`},
+		{"local s = '10' return s < 5", `[string "local s = '10' return s < 5"]:1:23: attempt to compare string with number`},
+		{"local b = true return 'x' .. b", `[string "local b = true return 'x' .. b"]:1:23: attempt to concatenate a boolean value (local 'b')`},
+	})
+}
+
+func (s *ParserSuite) TestIntegerErrors() {
+	s.runErrors([]errorTest{
+		{"local a, b = 1, 0\nreturn a // b", `[string "local a, b = 1, 0..."]:2:8: attempt to perform 'n//0'`},
+		{"local a, b = 1, 0\nreturn a % b", `[string "local a, b = 1, 0..."]:2:8: attempt to perform 'n%0'`},
+		{"local a = 1.5 return a >> 1", `[string "local a = 1.5 return a >> 1"]:1:22: number has no integer representation`},
+		{"local a = 2^63 return a | 0", `[string "local a = 2^63 return a | 0"]:1:23: number has no integer representation`},
+		{"local a = 'a' return a < 1", `[string "local a = 'a' return a < 1"]:1:22: attempt to compare string with number`},
+		{"local a = {} return a ~ 1", `[string "local a = {} return a ~ 1"]:1:21: attempt to perform bitwise operation on a table value (local 'a')`},
+		{"for i = 1, 10, 0 do end", `[string "for i = 1, 10, 0 do end"]:1:1: 'for' step is zero`},
+		{"for i = 1, 'x' do end", `[string "for i = 1, 'x' do end"]:1:1: 'for' limit must be a number`},
+	})
+}
+
+func (s *ParserSuite) TestNumericForLoop() {
+	s.run("", []scriptTest{
+		{`
+local n = 0
+for i = 0x7ffffffffffffffd, 0x7fffffffffffffff do
+  n = n + 1
+end
+local f = 0
+for x = 1, 2, 0.25 do
+  f = f + x
+end
+return n * 100 + f`, 307.5},
+		{"local n = 0 for i = math.mininteger, math.mininteger + 2 do n = n + 1 end return n", int64(3)},
+		{"local s = '' for i = 10, 1, -3 do s = s .. i end return s", "10741"},
+		{"local n = 0 for i = 1, 0 do n = n + 1 end return n", int64(0)},
+		{"local n = 0 for i = 1, 3.5 do n = i end return n", int64(3)},
+		{"for i = 1, 2 do return math.type(i) end", "integer"},
+		{"for i = 1.0, 2 do return math.type(i) end", "float"},
+		{"local s = '' for i = 1, 3 do for j = 1, 2 do s = s .. i .. j end end return s", "111221223132"},
+		{"local n = 0 for i = 1, 10 do if i > 3 then break end n = i end return n", int64(3)},
+		{"local s = '' for i = 1, 3 do if i == 2 then goto continue end s = s .. i ::continue:: end return s", "13"},
+	})
+}
+
+func (s *ParserSuite) TestClosures() {
+	v, err := interpreter.Eval(closuresLua)
+	s.NoError(err)
+	s.Equal(int64(3212348), v)
+}
+
+func (s *ParserSuite) TestLexicalScoping() {
+	tests := []struct {
+		script   string
+		expected interface{}
+	}{
+		// functions do not see the locals of their caller
+		{"local function f() return x end\nlocal function g() local x = 1 return f() end\nreturn g()", nil},
+		// a redeclared local is a new variable
+		{"local x = 1\nlocal f = function() return x end\nlocal x = 2\nreturn f() * 10 + x", int64(12)},
+		{"local x = 1\nlocal x = x + 1\nreturn x", int64(2)},
+		// a local is not visible in its own initializer
+		{"x = 'global'\nlocal x = function() return x end\nreturn x()", "global"},
+		// local functions can call themselves
+		{"local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end\nreturn fib(10)", int64(55)},
+		// nested closures reach variables two levels up
+		{"local function outer() local a = 1 return function() return function() a = a + 1 return a end end end\nlocal f = outer()()\nf()\nreturn f()", int64(3)},
+		// a return inside a loop leaves the function
+		{"local function find() for i = 1, 10 do if i == 4 then return i end end return 0 end\nreturn find()", int64(4)},
+		{"local function first() local i = 0 while true do i = i + 1 if i == 3 then return i end end end\nreturn first()", int64(3)},
+		// the until condition sees the locals of the loop body
+		{"local n = 0\nrepeat local done = n >= 2 n = n + 1 until done\nreturn n", int64(3)},
+		// a return from a closure does not end the function that defined it
+		{"local r = 0\nlocal function f() return 1 end\nr = f()\nr = r + 1\nreturn r", int64(2)},
+		// every pass through a label creates fresh locals
+		{"local fs, i = { false }, 1\n::top::\nlocal v = i\nfs[i] = function() return v end\ni = i + 1\nif i <= 3 then goto top end\nreturn fs[1]() + fs[2]() * 10 + fs[3]() * 100", int64(321)},
+	}
+
+	for _, test := range tests {
+		v, err := interpreter.Eval(test.script)
+		s.NoError(err, test.script)
+		s.Equal(test.expected, v, test.script)
+	}
+}
+
+func (s *ParserSuite) TestMultipleValues() {
+	// f is global, so that functions compiled for the VM can call it
+	const defs = "function f() return 1, 2, 3 end\nlocal function id(...) return ... end\n"
+	s.run(defs, []scriptTest{
+		// missing values are nil, extra values are dropped
+		{"local a, b, c, d = f()\nif d == nil then return a * 100 + b * 10 + c end", int64(123)},
+		{"local a, b = 1\nreturn b", nil},
+		{"local a = 1, 2\nreturn a", int64(1)},
+		{"local a, b\na, b = f()\nreturn a * 10 + b", int64(12)},
+		// parentheses truncate to one value
+		{"local a, b = (f())\nreturn b", nil},
+		{"local function p(...) local a, b = (...) return b end\nreturn p(1, 2)", nil},
+		// only the last expression of a list is expanded
+		{"local function sum(a, b, c) return a + b + c end\nreturn sum(f())", int64(6)},
+		{"local function sum(a, b, c) return a + b + c end\nreturn sum(f(), 10, 20)", int64(31)},
+		{"local t = { f(), f() }\nif t[5] == nil then return t[1] * 1000 + t[2] * 100 + t[3] * 10 + t[4] end", int64(1123)},
+		{"local t = { f(), (f()) }\nreturn t[3]", nil},
+		{"local function count(...) local t = { ... } return t[3] end\nreturn count(f())", int64(3)},
+		// return passes on all the values of a call
+		{"local a, b, c = id(id(f()))\nreturn c", int64(3)},
+		{"local function tail() return f() end\nlocal a, b, c = tail()\nreturn c", int64(3)},
+		{"local a, b, c = id(1, nil, 3)\nreturn c", int64(3)},
+		// a table returned by a function is a single value
+		{"local function arr() return { 1, 2 } end\nlocal t, u = arr()\nif u == nil then return t[2] end", int64(2)},
+		// self comes before the expanded arguments
+		{"local obj = { n = 5 }\nfunction obj:add(...) local x, y = ... return self.n + x + y end\nreturn obj:add(f())", int64(8)},
+		// the main chunk is a vararg function without arguments
+		{"local a = ...\nreturn a", nil},
+	})
+}
+
+func (s *ParserSuite) TestTables() {
+	s.run("", []scriptTest{
+		{"local t = {} return #t", int64(0)},
+		{"local t = { 10, 20, 30, } return #t", int64(3)},
+		{"local t = { 1, 2; 3 } t[4] = 4 return #t", int64(4)},
+		{"local t = { 1, 2, 3 } t[3] = nil return #t", int64(2)},
+		// integral float keys are the same as integer keys
+		{"local t = {} t[1.0] = 'a' return t[1]", "a"},
+		{"local t = { 'a', 'b' } return t[2.0]", "b"},
+		{"local t = { [1] = 'x' } t[1.0] = 'y' return t[1]", "y"},
+		{"local t = {} t[3] = 3 t[2] = 2 t[1] = 1 return #t", int64(3)},
+		{"local t = { x = 1, ['y'] = 2 } t.x = nil return t.x", nil},
+		{"local t = { x = 1, ['y'] = 2 } return t.y", int64(2)},
+		{"local t, k = {}, 'k' t[k] = 'v' return t.k", "v"},
+		{"local t = { n = { 5 } } return t.n[1]", int64(5)},
+		{"local a, t = 1, {} t.x, a = a, 2 return t.x + a", int64(3)},
+		{"local t = { '' } return #t[1]", int64(0)},
+	})
+}
+
+func (s *ParserSuite) TestTableErrors() {
+	tests := []struct {
+		script   string
+		expected string
+	}{
+		{"local t = {} t[nil] = 1", `[string "local t = {} t[nil] = 1"]:1:14: table index is nil`},
+		{"local t, k = {}, 0/0 t[k] = 1", `[string "local t, k = {}, 0/0 t[k] = 1"]:1:22: table index is NaN`},
+		{"local t = { [nil] = 1 }", `[string "local t = { [nil] = 1 }"]:1:13: table index is nil`},
+	}
+
+	for _, test := range tests {
+		_, err := interpreter.Eval(test.script)
+		s.EqualError(err, test.expected)
+	}
+}
+
+func (s *ParserSuite) TestAssignment() {
+	// f and g are global, so that functions compiled for the VM can call them
+	const defs = "function f() return 1, 2, 3 end\nfunction g() end\n"
+	s.run(defs, []scriptTest{
+		// swaps
+		{"local a, b = 1, 2\na, b = b, a\nreturn a * 10 + b", int64(21)},
+		{"a, b = 1, 2\na, b = b, a\nreturn a * 10 + b", int64(21)},
+		{"local a, b, c = 1, 2, 3\na, b, c = c, a, b\nreturn a * 100 + b * 10 + c", int64(312)},
+		{"local t = {1, 2}\nt[1], t[2] = t[2], t[1]\nreturn t[1] * 10 + t[2]", int64(21)},
+		{"local t = {x = 1, y = 2}\nt.x, t.y = t.y, t.x\nreturn t.x * 10 + t.y", int64(21)},
+		{"local a, t = 1, {2}\na, t[1] = t[1], a\nreturn a * 10 + t[1]", int64(21)},
+		// the tables and keys of the targets are evaluated before any assignment
+		{"local t, i = {}, 1\nt[i], i = i, t[i]\nreturn i == nil and t[1] == 1", true},
+		{"local t, i = {}, 1\ni, t[i] = 2, 20\nreturn t[1] * 10 + i", int64(202)},
+		{"local t, u = {}, {}\nlocal x = t\nx, x.a = u, 1\nreturn t.a == 1 and u.a == nil", true},
+		{"local t = {}\nlocal n = 1\nt[n], t[n + 1] = f()\nreturn t[1] * 10 + t[2]", int64(12)},
+		// the values are evaluated before any assignment
+		{"local a = 1\nlocal b, c = a + 1, a + 2\na, b = b + 10, a\nreturn a * 10 + b", int64(121)},
+		// extra values are dropped
+		{"local a, b = 0, 0\na, b = 1, 2, 3\nreturn a * 10 + b", int64(12)},
+		{"local a = 0\na = f()\nreturn a", int64(1)},
+		{"local a, b = 0, 0\na, b = f(), 10\nreturn a * 100 + b", int64(110)},
+		// missing values are nil
+		{"local a, b, c = 1, 2, 3\na, b, c = 10\nreturn b == nil and c == nil and a == 10", true},
+		{"local a, b = 1, 2\na, b = g()\nreturn a == nil and b == nil", true},
+		{"local a, b, c, d = 0, 0, 0, 0\na, b, c, d = f()\nreturn d == nil and a * 100 + b * 10 + c == 123", true},
+		{"local a, b, c = 0, 0, 0\na, b, c = 5, f()\nreturn a * 100 + b * 10 + c", int64(512)},
+		{"local a, b, c = 0, 0, 0\na, b, c = (f())\nreturn b == nil and c == nil and a == 1", true},
+		// the same variable assigned twice gets its first value, as in the reference implementation
+		{"local a\na, a = 1, 2\nreturn a", int64(1)},
+		// a local declaration evaluates its values before the new locals are in scope
+		{"local a, b = 1, 2\ndo local a, b = b, a return a * 10 + b end", int64(21)},
+		{"local a = 5\nlocal a, b = a + 1, a\nreturn a * 10 + b", int64(65)},
+		{"local a, b, c = 7, f()\nreturn a * 100 + b * 10 + c", int64(712)},
+		// nested fields
+		{"local t = {u = {}}\nt.u.v, t.w = 1, 2\nreturn t.u.v * 10 + t.w", int64(12)},
+		{"local t = {{}, {}}\nt[1].x, t[2].x = 'a', 'b'\nreturn t[1].x .. t[2].x", "ab"},
+	})
+
+	// __newindex runs in assignment order, from the last target to the first
+	v, err := interpreter.Eval(`
+local log = ""
+local t = setmetatable({}, {__newindex = function(t, k, v) log = log .. k end})
+t.a, t.b, t.c = 1, 2, 3
+return log`)
+	s.NoError(err)
+	s.Equal("cba", v)
+
+	_, err = interpreter.Eval("local a\na, f() = 1, 2")
+	s.EqualError(err, `[string "local a..."]:2:8: unexpected token: =`)
+}
+
+func (s *ParserSuite) TestMetatables() {
+	// V is a vector class with overloaded operators, defined with globals
+	// so that the VM can run it
+	const vector = `
+V = {}
+V.__index = V
+V.__add = function(a, b) return V.new(a.x + b.x) end
+V.__unm = function(a) return V.new(-a.x) end
+V.__eq = function(a, b) return a.x == b.x end
+V.__lt = function(a, b) return a.x < b.x end
+V.__le = function(a, b) return a.x <= b.x end
+V.__len = function(a) return a.x end
+V.__concat = function(a, b) return "concat" end
+V.__call = function(self, y) return self.x * y end
+V.__tostring = function(a) return "V(" .. a.x .. ")" end
+function V.new(x) return setmetatable({ x = x }, V) end
+function V:get() return self.x end
+`
+	s.run(vector, []scriptTest{
+		{"return (V.new(1) + V.new(2)).x", int64(3)},
+		{"return (-V.new(1)).x", int64(-1)},
+		{"return V.new(1) == V.new(1)", true},
+		{"return V.new(1) ~= V.new(2)", true},
+		{"return V.new(1) < V.new(2)", true},
+		{"return V.new(2) <= V.new(1)", false},
+		{"return V.new(2) > V.new(1)", true},
+		{"return #V.new(7)", int64(7)},
+		{"return V.new(1) .. 'x'", "concat"},
+		{"return 1 .. V.new(1)", "concat"},
+		{"return V.new(3)(2)", int64(6)},
+		{"return V.new(4):get()", int64(4)},
+		{"return getmetatable(V.new(1)) == V", true},
+		{"return getmetatable(setmetatable({}, { __metatable = 'locked' }))", "locked"},
+		// __index and __newindex chains
+		{"return setmetatable({}, { __index = { a = 1 } }).a", int64(1)},
+		{"return setmetatable({}, { __index = setmetatable({}, { __index = { a = 2 } }) }).a", int64(2)},
+		{"return setmetatable({}, { __index = function(t, k) return k .. '!' end }).foo", "foo!"},
+		{"store = {} t = setmetatable({}, { __newindex = store }) t.a = 1 return store.a", int64(1)},
+		{"store = {} t = setmetatable({}, { __newindex = store }) t.a = 1 return t.a", nil},
+		{"log = 0 t = setmetatable({}, { __newindex = function(t, k, v) log = v end }) t.a = 5 return log", int64(5)},
+		{"t = setmetatable({ a = 1 }, { __newindex = function() error() end }) t.a = 2 return t.a", int64(2)},
+		// __eq is not called for the same table or for different types
+		{"n = 0 t = setmetatable({}, { __eq = function() n = n + 1 return false end }) return t == t", true},
+		{"t = setmetatable({}, { __eq = function() return true end }) return t == 1", false},
+	})
+}
+
+func (s *ParserSuite) TestMetatableErrors() {
+	tests := []struct {
+		script   string
+		expected string
+	}{
+		{"local t = setmetatable({}, { __metatable = 1 }) setmetatable(t, {})", `[string "local t = setmetatable({}, { __metatable = 1 }) setmetatable..."]:1:49: cannot change a protected metatable`},
+		{"setmetatable(1, {})", `[string "setmetatable(1, {})"]:1:1: bad argument #1 to 'setmetatable' (table expected, got number)`},
+		{"setmetatable({}, 1)", `[string "setmetatable({}, 1)"]:1:1: bad argument #2 to 'setmetatable' (nil or table expected)`},
+		{"local t = setmetatable({}, {}) t()", `[string "local t = setmetatable({}, {}) t()"]:1:32: attempt to call a table value (local 't')`},
+		{"local t = setmetatable({}, {}) return t < t", `[string "local t = setmetatable({}, {}) return t < t"]:1:39: attempt to compare two table values`},
+		{"return {} .. 'x'", `[string "return {} .. 'x'"]:1:8: attempt to concatenate a table value`},
+		{"local t = {} t.__index = t setmetatable(t, t) return t.x", `[string "local t = {} t.__index = t setmetatable(t, t) return t.x"]:1:54: '__index' chain too long; possibly a loop`},
+		{"local t = setmetatable({}, { __tostring = function() return true end }) print(t)", `[string "local t = setmetatable({}, { __tostring = function() return ..."]:1:73: '__tostring' must return a string`},
+	}
+
+	for _, test := range tests {
+		_, err := interpreter.Eval(test.script)
+		s.EqualError(err, test.expected)
+	}
+}
+
+func (s *ParserSuite) TestToBeClosedVariables() {
+	tests := []struct {
+		script   string
+		expected interface{}
+	}{
+		// variables are closed in reverse order at the end of their block
+		{`local log = ''
+local function closer(name)
+  return setmetatable({}, { __close = function() log = log .. name end })
+end
+do
+  local a <close> = closer('a')
+  local b <close> = closer('b')
+  log = log .. '-'
+end
+return log`, "-ba"},
+		// a return closes the variables after the values are evaluated
+		{`local log = 'x'
+local function f()
+  local c <close> = setmetatable({}, { __close = function() log = 'closed' end })
+  return log
+end
+return f() .. log`, "xclosed"},
+		// nil and false need no closing
+		{"do local a <close> = nil local b <close> = false end return 1", int64(1)},
+		{`local n = 0
+local i = 1
+while i <= 3 do
+  local c <close> = setmetatable({}, { __close = function() n = n + 1 end })
+  i = i + 1
+end
+return n`, int64(3)},
+	}
+
+	for _, test := range tests {
+		v, err := interpreter.Eval(test.script)
+		s.NoError(err, test.script)
+		s.Equal(test.expected, v, test.script)
+	}
+
+	_, err := interpreter.Eval("local x <close> = 1")
+	s.EqualError(err, `[string "local x <close> = 1"]:1:1: variable 'x' got a non-closable value`)
+	_, err = interpreter.Eval("local x <const> = 1 x = 2")
+	s.EqualError(err, `[string "local x <const> = 1 x = 2"]:1:21: attempt to assign to const variable 'x'`)
+	_, err = interpreter.Eval("local x <static> = 1")
+	s.EqualError(err, `[string "local x <static> = 1"]:1:10: unknown attribute 'static'`)
+	_, err = interpreter.Eval("local x <close>, y <close> = nil")
+	s.EqualError(err, `[string "local x <close>, y <close> = nil"]:1:21: multiple to-be-closed variables in local list`)
+}
+
+func (s *ParserSuite) TestGenericFor() {
+	s.run("", []scriptTest{
+		{"local s = 0 for i, v in ipairs({ 10, 20, 30 }) do s = s + i * v end return s", int64(140)},
+		{"local n = 0 for i in ipairs({ 1, 2, nil, 4 }) do n = i end return n", int64(2)},
+		{"local s = '' for k, v in pairs({ 'a', 'b', x = 'c' }) do s = s .. k .. v end return s", "1a2bxc"},
+		{"local n = 0 for k, v in next, { a = 1, b = 2 } do n = n + v end return n", int64(3)},
+		{"local n = 0 for k in pairs({}) do n = n + 1 end return n", int64(0)},
+		{"local s = 0 for i, v in ipairs({ 1, 2, 3, 4 }) do if i == 3 then break end s = s + v end return s", int64(3)},
+		{"local s = 0 while true do s = s + 1 if s == 5 then break end end return s", int64(5)},
+		// fields may be cleared during a traversal
+		{"local t = { 1, 2, x = 3, y = 4 } for k in pairs(t) do t[k] = nil end return next(t)", nil},
+		// an iterator written in Lua with its state and control value
+		{"function iter(max, i) if i < max then return i + 1, i * 2 end end local s = 0 for i, d in iter, 3, 0 do s = s + i + d end return s", int64(12)},
+		// __pairs replaces the iteration
+		{"local t = setmetatable({}, { __pairs = function(t) return ipairs({ 5, 6 }) end }) local s = 0 for _, v in pairs(t) do s = s + v end return s", int64(11)},
+		// ipairs respects __index
+		{"local t = setmetatable({}, { __index = function(t, i) if i <= 3 then return i end end }) local s = 0 for _, v in ipairs(t) do s = s + v end return s", int64(6)},
+		// the closing value is closed when the loop ends
+		{"closed = 0 for x in next, {}, nil, setmetatable({}, { __close = function() closed = closed + 1 end }) do end return closed", int64(1)},
+		{"closed = 0 for x in ipairs({ 1, 2 }), { 1, 2 }, 0, setmetatable({}, { __close = function() closed = closed + 1 end }) do break end return closed", int64(1)},
+	})
+}
+
+func (s *ParserSuite) TestGenericForErrors() {
+	tests := []struct {
+		script   string
+		expected string
+	}{
+		{"for k in pairs(nil) do end", `[string "for k in pairs(nil) do end"]:1:10: bad argument #1 to 'pairs' (table expected, got nil)`},
+		{"next({}, 'x')", `[string "next({}, 'x')"]:1:1: invalid key to 'next'`},
+		{"for x in 1 do end", `[string "for x in 1 do end"]:1:1: attempt to call a number value`},
+		{"for x in next, {}, nil, 1 do end", `[string "for x in next, {}, nil, 1 do end"]:1:1: variable '(for state)' got a non-closable value`},
+	}
+
+	for _, test := range tests {
+		_, err := interpreter.Eval(test.script)
+		s.EqualError(err, test.expected)
+	}
+}
+
+func (s *ParserSuite) TestTypeErrors() {
+	s.runErrors([]errorTest{
+		{"return x + 1", `[string "return x + 1"]:1:8: attempt to perform arithmetic on a nil value (global 'x')`},
+		{"local a = 1 return a * {}", `[string "local a = 1 return a * {}"]:1:20: attempt to perform arithmetic on a table value`},
+		{"local t = {} return 2 ^ t.n", `[string "local t = {} return 2 ^ t.n"]:1:21: attempt to perform arithmetic on a nil value (field 'n')`},
+		{"local t = {} return t.a | 1", `[string "local t = {} return t.a | 1"]:1:21: attempt to perform bitwise operation on a nil value (field 'a')`},
+		{"return 1.5 & 1", `[string "return 1.5 & 1"]:1:8: number has no integer representation`},
+		{"local s = 'a' return -s", `[string "local s = 'a' return -s"]:1:22: attempt to perform arithmetic on a string value (local 's')`},
+		{"local t = 1 return t.x", `[string "local t = 1 return t.x"]:1:20: attempt to index a number value (local 't')`},
+		{"local t = {} return t.a.b", `[string "local t = {} return t.a.b"]:1:21: attempt to index a nil value (field 'a')`},
+		{"local t = {} t.a.b = 1", `[string "local t = {} t.a.b = 1"]:1:14: attempt to index a nil value (field 'a')`},
+		{"local t = {} t['a'][1] = 1", `[string "local t = {} t['a'][1] = 1"]:1:14: attempt to index a nil value (field 'a')`},
+		{"local t = {} t.foo()", `[string "local t = {} t.foo()"]:1:14: attempt to call a nil value (field 'foo')`},
+		{"local t = {} t:foo()", `[string "local t = {} t:foo()"]:1:14: attempt to call a nil value (method 'foo')`},
+		{"undefined()", `[string "undefined()"]:1:1: attempt to call a nil value (global 'undefined')`},
+		{"local f = 1 f()", `[string "local f = 1 f()"]:1:13: attempt to call a number value (local 'f')`},
+		{"return #n", `[string "return #n"]:1:8: attempt to get length of a nil value (global 'n')`},
+		{"return 'a' .. {}", `[string "return 'a' .. {}"]:1:8: attempt to concatenate a table value`},
+		{"local t = {} return 'a' .. t.s", `[string "local t = {} return 'a' .. t.s"]:1:21: attempt to concatenate a nil value (field 's')`},
+		{"function a.b() end", `[string "function a.b() end"]:1:1: attempt to index a nil value (global 'a')`},
+		// an error in an operand is passed on
+		{"return (nil)() + 1", `[string "return (nil)() + 1"]:1:9: attempt to call a nil value`},
+		{"local x = 1 return -x.y.z", `[string "local x = 1 return -x.y.z"]:1:21: attempt to index a number value (local 'x')`},
+	})
+
+	// the ast engine tells upvalues from locals
+	_, err := interpreter.Eval("local t function f() return t.x end f()")
+	s.EqualError(err, `[string "local t function f() return t.x end f()"]:1:29: attempt to index a nil value (upvalue 't')`)
+}
+
+func (s *ParserSuite) TestLogicalOperators() {
+	s.run("", []scriptTest{
+		{"return 1 and 'x'", "x"},
+		{"return nil and 1", nil},
+		{"return false or nil", nil},
+		{"return false and nil", false},
+		{"return 0 or 1", int64(0)},
+		{"local x return x or {}", table.New(0, 0)},
+		{"local a = {b = 5} return a and a.b", int64(5)},
+		{"local a return a and a.b", nil},
+		{"local t = {} local n = #t > 0 and t[1] or 'empty' return n", "empty"},
+		{"return not nil", true},
+		{"return not 0", false},
+		{"local x = 1 return not x == nil", false},
+		{"return 1 == 2 or 3 < 4 and 'yes'", "yes"},
+		// the right operand is evaluated only when needed; n is global since the VM has no upvalues yet
+		{"n = 0 function inc() n = n + 1 return true end local r = inc() or inc() return n", int64(1)},
+		{"n = 0 function inc() n = n + 1 return false end local r = inc() and inc() return n", int64(1)},
+		{"n = 0 function inc() n = n + 1 return nil end local r = inc() or inc() return n", int64(2)},
+		{"local t = nil return t and t.x.y", nil},
+		{"return true or undefined()", true},
+		// only the first result of a call is used
+		{"function f() return nil, 2 end return f() or 3", int64(3)},
+		{"local i = 0 while i < 10 and not (i == 3) do i = i + 1 end return i", int64(3)},
+	})
+}
+
+func (s *ParserSuite) TestProtectedCalls() {
+	s.run("", []scriptTest{
+		{"local ok, e = pcall(error, 'x') return e", "x"},
+		{"local ok, e = pcall(error, 'x') return ok", false},
+		{"local ok, a, b = pcall(function(x) return x, x * 2 end, 21) return b", int64(42)},
+		{"local ok = pcall(function() end) return ok", true},
+		// error adds the position of the error call to messages at level 1
+		{"local ok, e = pcall(function() error('boom') end) return e", `[string "local ok, e = pcall(function() error('boom') end) return e"]:1: boom`},
+		{"local ok, e = pcall(function() error('boom', 0) end) return e", "boom"},
+		{"function f() error('boom', 2) end local ok, e = pcall(function() f() end) return e", `[string "function f() error('boom', 2) end local ok, e = pcall(functi..."]:1: boom`},
+		// any value can be an error
+		{"local t = {} local ok, e = pcall(error, t) return e == t", true},
+		{"local ok, e = pcall(error, 42) return e", int64(42)},
+		{"local ok, e = pcall(error) return e", nil},
+		// runtime errors are caught with their message
+		{"local ok, e = pcall(function() return {} < {} end) return e", `[string "local ok, e = pcall(function() return {} < {} end) return e"]:1:39: attempt to compare two table values`},
+		{"local ok, e = pcall(setmetatable, 1) return e", "bad argument #1 to 'setmetatable' (table expected, got number)"},
+		{"local ok, e = pcall(nil) return e", "attempt to call a nil value"},
+		// xpcall passes the error value to the message handler
+		{"local ok, e = xpcall(function() error({ code = 1 }) end, function(e) return e.code + 1 end) return e", int64(2)},
+		{"local ok, a = xpcall(function(x) return x end, print, 5) return a", int64(5)},
+		// assert
+		{"return assert(1, 2)", int64(1)},
+		{"local ok, e = pcall(function() assert(false) end) return e", `[string "local ok, e = pcall(function() assert(false) end) return e"]:1: assertion failed!`},
+		{"local ok, e = pcall(function() assert(nil, 'message') end) return e", "message"},
+		// to-be-closed variables get the error value
+		{"local ok = pcall(function() local c <close> = setmetatable({}, { __close = function(_, e) got = e end }) error('x', 0) end) return got", "x"},
+	})
+}
+
+func (s *ParserSuite) TestTraceback() {
+	// an error raised in a metamethod called from f, at level 2 for f
+	script := "mt = {__index = function() error('x', 2) end}\nfunction f() return setmetatable({}, mt).x end\nf()"
+	for name, eval := range engines {
+		_, err := eval(script)
+		var luaErr *interpreter.LuaError
+		s.Require().ErrorAs(err, &luaErr, name)
+		s.Equal(`[string "mt = {__index = function() error('x', 2) end}..."]:2: x`, luaErr.Error(), name)
+		s.Equal(`stack traceback:
+	[C]: in function 'error'
+	[string "mt = {__index = function() error('x', 2) end}..."]:1: in metamethod 'index'
+	[string "mt = {__index = function() error('x', 2) end}..."]:2: in function 'f'
+	[string "mt = {__index = function() error('x', 2) end}..."]:3: in main chunk`, luaErr.Traceback, name)
+	}
+
+	// an error raised in a function called by a Go function
+	script = "function cmp(a, b) return a.x < b end\ntable.sort({1, 2}, cmp)"
+	for name, eval := range engines {
+		_, err := eval(script)
+		var luaErr *interpreter.LuaError
+		s.Require().ErrorAs(err, &luaErr, name)
+		s.Equal(`stack traceback:
+	[string "function cmp(a, b) return a.x < b end..."]:1: in function <?>
+	[C]: in field 'sort'
+	[string "function cmp(a, b) return a.x < b end..."]:2: in main chunk`, luaErr.Traceback, name)
+	}
+}
+
+func (s *ParserSuite) TestLuaError() {
+	for name, eval := range engines {
+		_, err := eval("function fail() error({ code = 7 }) end\nfail()")
+		var luaErr *interpreter.LuaError
+		s.Require().ErrorAs(err, &luaErr, name)
+		t, ok := luaErr.Value.(*table.Table)
+		s.Require().True(ok, name)
+		s.Equal(int64(7), t.Get("code"), name)
+		s.Equal(`stack traceback:
+	[C]: in function 'error'
+	[string "function fail() error({ code = 7 }) end..."]:1: in function 'fail'
+	[string "function fail() error({ code = 7 }) end..."]:2: in main chunk`, luaErr.Traceback, name)
+
+		_, err = eval("error('stop')")
+		s.EqualError(err, `[string "error('stop')"]:1: stop`, name)
+		_, err = eval("local t = nil + 1")
+		s.ErrorAs(err, &luaErr, name)
+	}
+}
+
+func (s *ParserSuite) TestCoroutineHost() {
+	for name, eval := range engines {
+		v, err := eval("return coroutine.create(function(a) local b = coroutine.yield(a * 2) return a + b end)")
+		s.Require().NoError(err, name)
+		co, ok := v.(*coroutine.Coroutine)
+		s.Require().True(ok, name)
+
+		res, err := co.Resume([]interface{}{int64(5)})
+		s.NoError(err, name)
+		s.Equal([]interface{}{int64(10)}, res, name)
+		s.Equal(coroutine.Suspended, co.Status(), name)
+		res, err = co.Resume([]interface{}{int64(1)})
+		s.NoError(err, name)
+		s.Equal([]interface{}{int64(6)}, res, name)
+		s.Equal(coroutine.Dead, co.Status(), name)
+	}
+}
+
+func (s *ParserSuite) TestCallDepth() {
+	defer func(depth int) { interpreter.MaxCallDepth = depth }(interpreter.MaxCallDepth)
+	interpreter.MaxCallDepth = 200
+
+	const defs = `
+function count(n) if n == 0 then return 0 end return 1 + count(n - 1) end
+function loop(n, acc) if n == 0 then return acc end return loop(n - 1, acc + 1) end
+function even(n) if n == 0 then return true end return odd(n - 1) end
+function odd(n) if n == 0 then return false end return even(n - 1) end
+`
+	s.run(defs, []scriptTest{
+		{"return count(100)", int64(100)},
+		{"local ok, e = pcall(count, 1000)\nreturn not ok and e", `[string "..."]:2:58: stack overflow`},
+		// tail calls do not nest
+		{"return loop(10000, 0)", int64(10000)},
+		{"return even(10001)", false},
+		{"function f(n) if n == 0 then return 'done' end return (f(n - 1)) end\nlocal ok, e = pcall(f, 1000)\nreturn not ok and e", `[string "..."]:6:56: stack overflow`},
+		// a tail call returns all the results, also of library functions
+		{"function f() return math.type(1) end\nreturn f()", "integer"},
+		{"function f(...) return ... end\nfunction g() return f(1, 2, 3) end\nreturn #{g()}", int64(3)},
+		{"function g() return pcall(error, 'x') end\nlocal ok, e = g()\nreturn not ok and e", "x"},
+		// the error of a tail call has the position of the call
+		{"function f() return nil_function() end\nlocal ok, e = pcall(f)\nreturn not ok and e", `[string "..."]:6:21: attempt to call a nil value (global 'nil_function')`},
+		// a call in the scope of a to-be-closed variable is made before closing it
+		{"log = ''\nfunction g() log = log .. 'g' end\nfunction f()\nlocal x <close> = setmetatable({}, {__close = function() log = log .. 'c' end})\nreturn g()\nend\nf()\nreturn log", "gc"},
+	})
+
+	// the traceback of a stack overflow is cut in the middle
+	_, err := interpreter.Eval("function f() f() end\nf()")
+	var luaErr *interpreter.LuaError
+	s.Require().ErrorAs(err, &luaErr)
+	s.Contains(luaErr.Traceback, "\n\t...\t(skipping 181 levels)\n")
+}
+
+func (s *ParserSuite) TestGoto() {
+	s.run("", []scriptTest{
+		// continue
+		{"local s, i = '', 0\nwhile i < 5 do\ni = i + 1\nif i % 2 == 0 then goto continue end\nlocal x = i * 10\ns = s .. x .. ' '\n::continue::\nend\nreturn s", "10 30 50 "},
+		{"local s = ''\nfor _, v in ipairs({1, 2, 3}) do\nif v == 2 then goto continue end\ns = s .. v\n::continue::\nend\nreturn s", "13"},
+		// out of nested loops
+		{"local a = 0\nwhile true do\nwhile true do\na = a + 1\nif a == 3 then goto out end\nend\nend\n::out::\nreturn a", int64(3)},
+		// backward from a nested block
+		{"local n = 0\n::top::\nn = n + 1\ndo\nif n < 3 then goto top end\nend\nreturn n", int64(3)},
+		// the same label in sibling blocks
+		{"local s = ''\ndo goto a; s = s .. 'x'; ::a:: s = s .. 'a' end\ndo goto a; s = s .. 'y'; ::a:: s = s .. 'b' end\nreturn s", "ab"},
+		// a label at the end of a block is outside the scope of its locals
+		{"do\ngoto e\nlocal x = 1\n::e::\nend\nreturn 'ok'", "ok"},
+		// leaving the scope of to-be-closed variables closes them
+		{"log = ''\nfunction ca(_, e) log = log .. (e == nil and 'a' or '!') end\nfunction cb(_, e) log = log .. (e == nil and 'b' or '!') end\ndo\nlocal a <close> = setmetatable({}, {__close = ca})\ndo\nlocal b <close> = setmetatable({}, {__close = cb})\ngoto out\nend\nend\n::out::\nreturn log", "ba"},
+		{"log = ''\nfunction ca(_, e) log = log .. (e == nil and 'a' or '!') end\nfor _ in next, {1}, nil, setmetatable({}, {__close = ca}) do\ngoto out\nend\n::out::\nreturn log", "a"},
+	})
+
+	s.runErrors([]errorTest{
+		{"goto a\nlocal x\n::a:: x = 1", `[string "goto a..."]:1:1: <goto a> at line 1 jumps into the scope of local 'x'`},
+		{"repeat\ngoto a\nlocal x\n::a::\nuntil x", `[string "repeat..."]:2:1: <goto a> at line 2 jumps into the scope of local 'x'`},
+		{"::a::\ndo ::a:: end", `[string "::a::..."]:2:4: label 'a' already defined on line 1`},
+		{"local function f() goto a end\n::a::", `[string "local function f() goto a end..."]:1:20: no visible label 'a' for <goto> at line 1`},
+		{"do ::a:: end\ngoto a", `[string "do ::a:: end..."]:2:1: no visible label 'a' for <goto> at line 2`},
+		{"local x = 1\nif x then break end", `[string "local x = 1..."]:2:11: break outside a loop at line 2`},
+		{"while true do local f = function() break end end", `[string "while true do local f = function() break end end"]:1:36: break outside a loop at line 1`},
+	})
+}
+
+func (s *ParserSuite) TestMathLibrary() {
+	s.run("", []scriptTest{
+		{"return math.abs(-3)", int64(3)},
+		{"return math.abs(-3.5)", 3.5},
+		{"return math.abs(math.mininteger)", int64(math.MinInt64)},
+		{"return math.floor(3.7)", int64(3)},
+		{"return math.floor(-3.7)", int64(-4)},
+		{"return math.ceil(3.2)", int64(4)},
+		{"return math.floor(1e100)", 1e100},
+		{"return math.sqrt(16)", 4.0},
+		{"return math.exp(0)", 1.0},
+		{"return math.log(8, 2)", 3.0},
+		{"return math.log(100, 10)", 2.0},
+		{"return math.log(1)", 0.0},
+		{"return math.sin(0) + math.cos(0) + math.tan(0)", 1.0},
+		{"return math.asin(1) == math.pi / 2 and math.acos(1) == 0", true},
+		{"return math.atan(1, -1)", 3 * math.Pi / 4},
+		{"return math.fmod(-7, 3)", int64(-1)},
+		{"return math.fmod(7.5, 2)", 1.5},
+		{"return math.fmod(math.mininteger, -1)", int64(0)},
+		{"local i, f = math.modf(3.5) return i + f * 10", 8.0},
+		{"local i, f = math.modf(-3.5) return i", -3.0},
+		{"local i, f = math.modf(5) return math.type(i) .. math.type(f)", "integerfloat"},
+		{"return math.max(1, 5, 3)", int64(5)},
+		{"return math.max(1, 2.0)", 2.0},
+		{"return math.min(4, 2, 8)", int64(2)},
+		{"return math.huge", math.Inf(1)},
+		{"return -math.huge", math.Inf(-1)},
+		{"return math.maxinteger", int64(math.MaxInt64)},
+		{"return math.mininteger", int64(math.MinInt64)},
+		{"return math.ult(1, 2) and not math.ult(-1, 2)", true},
+
+		// seeded sequences are reproducible
+		{"math.randomseed(42) local a, b, c = math.random(1, 100), math.random(), math.random(0) math.randomseed(42) return a == math.random(1, 100) and b == math.random() and c == math.random(0)", true},
+		{"local a, b = math.randomseed(7, 3) return a * 10 + b", int64(73)},
+		{"local x = math.random(10) return x >= 1 and x <= 10 and math.type(x)", "integer"},
+		{"local x = math.random() return x >= 0 and x < 1", true},
+		{"return math.random(3, 3)", int64(3)},
+		{"return math.type(math.random(math.mininteger, math.maxinteger))", "integer"},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"math.fmod(1, 0)", "bad argument #2 to 'fmod' (zero)"},
+		{"math.max()", "bad argument #1 to 'max' (number expected, got no value)"},
+		{"math.floor('x')", "bad argument #1 to 'floor' (number expected, got string)"},
+		{"math.random(2, 1)", "bad argument #1 to 'random' (interval is empty)"},
+		{"math.random(1, 2, 3)", "wrong number of arguments"},
+		{"math.random(1.5)", "bad argument #1 to 'random' (number has no integer representation)"},
+	})
+
+	// each interpreter has its own generator
+	seq := "math.randomseed(5) return math.random(1000000) * 1000000 + math.random(1000000)"
+	a, err := interpreter.Eval(seq)
+	s.NoError(err)
+	_, err = interpreter.Eval("math.randomseed(6) math.random()")
+	s.NoError(err)
+	b, err := interpreter.Eval(seq)
+	s.NoError(err)
+	s.Equal(a, b)
+}
+
+func (s *ParserSuite) TestBaseLibrary() {
+	s.run("", []scriptTest{
+		{"return type(nil) .. type(true) .. type(print)", "nilbooleanfunction"},
+		{"return type(coroutine.create(print))", "thread"},
+		// _G is the table of the global variables
+		{"x = 1 _G.y = 2 return _G._G == _G and _G.x + y", int64(3)},
+		{"return _VERSION", "Lua 5.4"},
+		{"return package.loaded._G == _G", true},
+		{"local env = setmetatable({}, {__index = _G}) return load('x = 5 return type(x)', 'c', 't', env)() .. tostring(x)", "numbernil"},
+		{"return tostring(nil) .. tostring(false) .. tostring(1.0)", "nilfalse1.0"},
+		{"return tostring({}):match('^table: 0x%x+$') ~= nil", true},
+		{"return tostring(print):match('^function: 0x%x+$') ~= nil", true},
+		{"return tostring(coroutine.create(print)):match('^thread: 0x%x+$') ~= nil", true},
+		{"t = {} return tostring(t) == tostring(t) and tostring(t) ~= tostring({})", true},
+		{"return tostring(setmetatable({}, {__name = 'Point'})):match('^Point: 0x') ~= nil", true},
+		{"return tostring(setmetatable({}, {__tostring = function() return 'p' end}))", "p"},
+		{"return tostring(setmetatable({}, {__tostring = function() return 42 end}))", "42"},
+		{"return tostring(setmetatable({}, {__tostring = function() return 1.5 end}))", "1.5"},
+		{"return tonumber('0x10')", int64(16)},
+		{"return tonumber(' 1e1 ')", 10.0},
+		{"return tonumber('1a')", nil},
+		{"return tonumber({})", nil},
+		{"return tonumber('ff', 16)", int64(255)},
+		{"return tonumber('ZZ', 36)", int64(1295)},
+		{"return tonumber(' -101 ', 2)", int64(-5)},
+		{"return tonumber('12', 2)", nil},
+		{"return tonumber('', 10)", nil},
+		{"return tonumber('ffffffffffffffff', 16)", int64(-1)},
+		{"return select('#')", int64(0)},
+		{"return select('#', nil, nil)", int64(2)},
+		{"return select(2, 'a', 'b', 'c')", "b"},
+		{"return select(-1, 'a', 'b', 'c')", "c"},
+		{"return select(3, 'a')", nil},
+		{"t = setmetatable({}, {__index = function() return 1 end}) return rawget(t, 'x')", nil},
+		{"t = setmetatable({}, {__newindex = error}) rawset(t, 'x', 2) return t.x", int64(2)},
+		{"return rawequal(1, 1.0) and not rawequal({}, {})", true},
+		{"t = setmetatable({}, {__eq = function() return true end}) return rawequal(t, {})", false},
+		{"return rawlen(setmetatable({1, 2}, {__len = function() return 5 end}))", int64(2)},
+		{"return rawlen('abc')", int64(3)},
+		{"return unpack({1, 2, 3}, 2)", int64(2)},
+		{"return math.type(collectgarbage('count'))", "float"},
+		{"return collectgarbage()", int64(0)},
+		{"collectgarbage('stop') return collectgarbage('isrunning')", false},
+		{"return collectgarbage('generational')", "incremental"},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"type()", "bad argument #1 to 'type' (value expected)"},
+		{"tostring()", "bad argument #1 to 'tostring' (value expected)"},
+		{"tonumber('10', 37)", "bad argument #2 to 'tonumber' (base out of range)"},
+		{"tonumber(10, 16)", "bad argument #1 to 'tonumber' (string expected, got number)"},
+		{"select(0)", "bad argument #1 to 'select' (index out of range)"},
+		{"select(-2, 1)", "bad argument #1 to 'select' (index out of range)"},
+		{"rawget(1, 2)", "bad argument #1 to 'rawget' (table expected, got number)"},
+		{"rawset({}, 1)", "bad argument #3 to 'rawset' (value expected)"},
+		{"rawset({}, nil, 1)", "table index is nil"},
+		{"rawlen(1)", "bad argument #1 to 'rawlen' (table or string expected, got number)"},
+		{"rawequal(1)", "bad argument #2 to 'rawequal' (value expected)"},
+		{"collectgarbage('all')", "bad argument #1 to 'collectgarbage' (invalid option 'all')"},
+	})
+}
+
+func (s *ParserSuite) TestRequire() {
+	const path = "package.path = 'testdata/modules/?.lua;testdata/modules/?/init.lua' "
+	s.run(path, []scriptTest{
+		{"local m, p = require('counter') return m.name .. ' ' .. m.path .. ' ' .. p", "counter testdata/modules/counter.lua testdata/modules/counter.lua"},
+		{"local m = require('counter') return require('counter') == m and loads == 1 and package.loaded.counter == m", true},
+		{"return require('geometry').area(2, 3)", int64(6)},
+		{"return select(2, require('geometry'))", "testdata/modules/geometry/init.lua"},
+		{"return require('silent') == true and silent", true},
+		{"return require('string') == string and package.loaded.package == package", true},
+		{"package.preload.p = function(...) return {...} end local m = require('p') return m[1] .. m[2]", "p:preload:"},
+		{"package.loaded.x = 42 return require('x')", int64(42)},
+		{"table.insert(package.searchers, 1, function(n) return function(m) return m end end) return require('any')", "any"},
+		{"return package.searchpath('cycle.a', package.path)", "testdata/modules/cycle/a.lua"},
+		{"return select(2, package.searchpath('x', 'a/?.lua;b/?'))", "no file 'a/x.lua'\n\tno file 'b/x'"},
+		{"return package.searchpath('a_b', '?', '_', '/')", nil},
+		{"local ok, err = pcall(require, 'cycle.a') return err:match('loop or previous error loading module .*$')", "loop or previous error loading module 'cycle.a'"},
+		{"return select(2, pcall(require, 'missing'))", "module 'missing' not found:\n\tno field package.preload['missing']\n\tno file 'testdata/modules/missing.lua'\n\tno file 'testdata/modules/missing/init.lua'"},
+		{"package.searchers = 1 return select(2, pcall(require, 'x'))", "'package.searchers' must be a table"},
+		{"package.path = 1 return select(2, pcall(require, 'x'))", "'package.path' must be a string"},
+		{"return select(2, pcall(require, 'broken'))", "error loading module 'broken' from file 'testdata/modules/broken.lua':\n\ttestdata/modules/broken.lua:1:11: unexpected token: ="},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"require()", "bad argument #1 to 'require' (string expected, got no value)"},
+	})
+}
+
+func (s *ParserSuite) TestLoad() {
+	s.run("", []scriptTest{
+		{"return load('return 1 + 2')()", int64(3)},
+		{"return select(2, load('return ...')(4, 5))", int64(5)},
+		{"return load('local x = 1 return x', 'name', 't')()", int64(1)},
+		{"return select(2, load('x = '))", `[string "x = "]:1:5: unexpected token: <eof>`},
+		{"return select(2, load('x = ', '=gen'))", "gen:1:5: unexpected token: <eof>"},
+		{"return select(2, load('x = ', '@gen.lua'))", "gen.lua:1:5: unexpected token: <eof>"},
+		{"return select(2, pcall(load('error(\"e\")', '=gen')))", "gen:1: e"},
+		{"return select(2, pcall(load('local t\\nreturn t.x', '=gen')))", "gen:2:8: attempt to index a nil value (local 't')"},
+		{"parts, i = {'return ', '10', ' * 2'}, 0 return load(function() i = i + 1 return parts[i] end)()", int64(20)},
+		{"return select(2, load(function() return 1 end))", "reader function must return a string"},
+		{"return select(2, load('return 1', 'c', 'b'))", "attempt to load a text chunk (mode is 'b')"},
+		{"return select(2, load('\\27Lua', 'c', 't'))", "attempt to load a binary chunk (mode is 't')"},
+		{"local env = {y = 5} load('z = y * 2', 'c', 't', env)() return env.z", int64(10)},
+		{"local env = {y = 5} load('z = y * 2', 'c', 't', env)() return z", nil},
+		{"local env = {} load('function f() return g end g = 1', 'c', 't', env)() return env.f()", int64(1)},
+		{"local env = setmetatable({}, {__index = function(_, k) return k end}) return load('return abc', 'c', 't', env)()", "abc"},
+		{"return select(2, pcall(load('return x', '=c', 't', nil)))", "c:1:8: attempt to index a nil value (upvalue '_ENV')"},
+		{"return select(2, pcall(load('x = 1', 'c', 't', nil)))", `[string "c"]:1:1: attempt to index a nil value (upvalue '_ENV')`},
+		// the env of a chunk is its _ENV
+		{"local env = {} return load('return _ENV', 'c', 't', env)() == env", true},
+		{"return load('return _ENV')() == _G and _ENV == _G", true},
+		{"return load('_ENV = {x = 2} return x')() + (x or 0)", int64(2)},
+		{"local f = load('local function g() return x end _ENV = {x = 3} return g()') return f()", int64(3)},
+		// a local _ENV changes the global variables in its scope
+		{"do local _ENV = {x = 1} y = 2 return x + _ENV.y end", int64(3)},
+		{"do local _ENV = {} y = 2 end return y", nil},
+		{"local _ENV = setmetatable({}, {__index = _G}) x = 4 return _G.x == nil and x", int64(4)},
+		{"local function f() local _ENV = {} return type end return f()", nil},
+		{"return select(2, pcall(load('local _ENV = 1 return x')))", `[string "local _ENV = 1 return x"]:1:23: attempt to index a number value (local '_ENV')`},
+		{"local a, b = loadfile('testdata/load/shebang.lua')(1) return a .. b", "2two"},
+		{"return select(2, loadfile('missing.lua'))", "cannot open missing.lua: no such file or directory"},
+		{"return select(2, pcall(loadfile('testdata/load/boom.lua', 't', {error = error})))", "testdata/load/boom.lua:2: boom"},
+		{"local ok = pcall(loadfile('testdata/load/boom.lua', 't', {error = error})) return x", nil},
+		{"local a, b = dofile('testdata/load/shebang.lua') return a .. b", "1two"},
+		{"pcall(dofile, 'testdata/load/boom.lua') return x", int64(1)},
+	})
+
+	s.runChunkErrors([]errorTest{
+		{"load(5)", "bad argument #1 to 'load' (function expected, got number)"},
+		{"dofile('missing.lua')", "cannot open missing.lua: no such file or directory"},
+	})
+
+	// a loaded function shows its chunk name in the traceback
+	_, err := interpreter.Eval("load('error(\"deep\")', '=gen')()")
+	var luaErr *interpreter.LuaError
+	s.Require().ErrorAs(err, &luaErr)
+	s.Equal("gen:1: deep", luaErr.Error())
+	s.Contains(luaErr.Traceback, "gen:1: in function <?>")
 }
@@ -0,0 +1,61 @@
+-- counter factory: every call creates its own upvalue
+local function counter()
+  local n = 0
+  return function()
+    n = n + 1
+    return n
+  end
+end
+
+local c1, c2 = counter(), counter()
+c1()
+c1()
+c2()
+
+-- two closures sharing one variable
+local function account(balance)
+  local ops = { kind = "account" }
+  ops.deposit = function(v) balance = balance + v end
+  ops.get = function() return balance end
+  return ops
+end
+
+local acc = account(100)
+acc.deposit(20)
+acc.deposit(3)
+
+-- every iteration has a fresh control variable
+local fns = { false }
+for i = 1, 3 do
+  fns[i] = function() return i end
+end
+
+-- and a fresh local inside while loops
+local k, ws = 0, { false }
+while k < 3 do
+  k = k + 1
+  local j = k * 10
+  ws[k] = function() return j end
+end
+
+-- memoizer keeps its cache alive after the factory returned
+local calls = 0
+local function memoize(f)
+  local cache = { size = 0 }
+  return function(x)
+    if cache[x] == nil then
+      cache[x] = f(x)
+    end
+    return cache[x]
+  end
+end
+local square = memoize(function(x)
+  calls = calls + 1
+  return x * x
+end)
+square(4)
+square(4)
+square(5)
+
+return c1() * 1000000 + c2() * 100000 + acc.get() * 100
+  + fns[1]() + fns[2]() + fns[3]() + ws[1]() + ws[3]() + calls
@@ -0,0 +1,3 @@
+#!/usr/bin/env gua
+local a = ...
+return (a or 0) + 1, "two"
@@ -0,0 +1 @@
+return require("cycle.a")
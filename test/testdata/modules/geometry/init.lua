@@ -0,0 +1,7 @@
+local geometry = {}
+
+function geometry.area(w, h)
+	return w * h
+end
+
+return geometry
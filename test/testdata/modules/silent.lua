@@ -0,0 +1 @@
+silent = true